@@ -0,0 +1,396 @@
+// config/config.go
+
+// Package config loads the settings of Flock from a JSON file, environment
+// variables and command line flags, in increasing order of precedence.
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix starts the name of every environment variable read by Load.
+const envPrefix = "FLOCK_"
+
+// Config holds every setting of a Flock server.
+type Config struct {
+	Addr             string // address the server listens on
+	ReadTimeout      time.Duration
+	WriteTimeout     time.Duration
+	IdleTimeout      time.Duration // how long idle keep-alive connections stay open
+	ShutdownTimeout  time.Duration // how long requests in flight get to finish on shutdown
+	MaxHeaderSize    int64
+	LogFormat        string     // text or json
+	LogLevel         slog.Level // least important messages logged
+	TLSCert          string     // certificate file, served with TLSKey over HTTPS
+	TLSKey           string
+	TLSSelfSigned    bool   // serve HTTPS with a certificate kept in DataDir, for use on a LAN
+	DataDir          string // directory of the databases, session keys and photos
+	TemplateDir      string
+	StaticDir        string // directory served under /includes/
+	SessionKeys      string // session keys file, DataDir/session_keys.json when empty
+	ServerSessions   bool
+	Registration     bool          // whether anyone can register
+	SeedDemo         bool          // whether the demo account is added when missing
+	DemoReset        time.Duration // how often the demo account is reset, never when zero
+	TrashRetention   time.Duration // how long deleted pens stay in the trash, forever when zero
+	MaxPhotoSize     int64
+	MaxUploadSize    int64
+	CaptchaQuestions string
+
+	BaseURL      string
+	SMTPAddr     string
+	SMTPUser     string
+	SMTPPassword string
+	MailFrom     string
+	MailDir      string
+	VerifyEmail  bool
+
+	Login LoginLimits
+
+	AdminUsername string
+	AdminEmail    string
+	AdminPassword string
+}
+
+// Default returns the settings used when nothing else is given.
+func Default() Config {
+	return Config{
+		Addr:             ":8000",
+		ReadTimeout:      time.Minute,
+		WriteTimeout:     time.Minute,
+		IdleTimeout:      2 * time.Minute,
+		ShutdownTimeout:  30 * time.Second,
+		MaxHeaderSize:    1 << 20,
+		LogFormat:        "text",
+		LogLevel:         slog.LevelInfo,
+		DataDir:          "database",
+		TemplateDir:      "templates",
+		StaticDir:        "includes",
+		Registration:     true,
+		TrashRetention:   30 * 24 * time.Hour,
+		MaxPhotoSize:     10 << 20,
+		MaxUploadSize:    50 << 20,
+		CaptchaQuestions: "data/captcha_questions.json",
+		BaseURL:          "http://localhost:8000",
+		MailFrom:         "flock@localhost",
+		Login:            DefaultLoginLimits,
+	}
+}
+
+// option is a setting that can be given in the file, as FLOCK_<NAME> in the
+// environment and, unless secret, as -<name> on the command line.
+type option struct {
+	name   string
+	usage  string
+	secret bool // kept off the command line, where other users could see it
+	value  func(c *Config) flag.Value
+}
+
+// options lists every setting. Names use dashes on the command line and
+// underscores in the file and the environment.
+var options = []option{
+	{name: "addr", usage: "address to listen on", value: func(c *Config) flag.Value { return (*stringValue)(&c.Addr) }},
+	{name: "read-timeout", usage: "longest time to read a request with its uploads", value: func(c *Config) flag.Value { return (*durationValue)(&c.ReadTimeout) }},
+	{name: "write-timeout", usage: "longest time to write a response", value: func(c *Config) flag.Value { return (*durationValue)(&c.WriteTimeout) }},
+	{name: "idle-timeout", usage: "how long an idle keep-alive connection stays open", value: func(c *Config) flag.Value { return (*durationValue)(&c.IdleTimeout) }},
+	{name: "shutdown-timeout", usage: "how long requests in flight get to finish when stopping", value: func(c *Config) flag.Value { return (*durationValue)(&c.ShutdownTimeout) }},
+	{name: "max-header-size", usage: "largest request headers, such as 1MB", value: func(c *Config) flag.Value { return (*sizeValue)(&c.MaxHeaderSize) }},
+	{name: "log-format", usage: "format of the log, text or json", value: func(c *Config) flag.Value { return (*stringValue)(&c.LogFormat) }},
+	{name: "log-level", usage: "least important messages logged: debug, info, warn or error", value: func(c *Config) flag.Value { return (*levelValue)(&c.LogLevel) }},
+	{name: "tls-cert", usage: "certificate file to serve HTTPS with, along with -tls-key", value: func(c *Config) flag.Value { return (*stringValue)(&c.TLSCert) }},
+	{name: "tls-key", usage: "private key file of the certificate", value: func(c *Config) flag.Value { return (*stringValue)(&c.TLSKey) }},
+	{name: "tls-self-signed", usage: "serve HTTPS with a self-signed certificate kept in the data directory, for use on a LAN", value: func(c *Config) flag.Value { return (*boolValue)(&c.TLSSelfSigned) }},
+	{name: "data-dir", usage: "directory holding the databases, session keys and photos", value: func(c *Config) flag.Value { return (*stringValue)(&c.DataDir) }},
+	{name: "template-dir", usage: "directory holding the HTML templates", value: func(c *Config) flag.Value { return (*stringValue)(&c.TemplateDir) }},
+	{name: "static-dir", usage: "directory of the stylesheets and scripts served under /includes/", value: func(c *Config) flag.Value { return (*stringValue)(&c.StaticDir) }},
+	{name: "session-keys", usage: "file holding the session keys, session_keys.json in the data directory when empty", value: func(c *Config) flag.Value { return (*stringValue)(&c.SessionKeys) }},
+	{name: "server-sessions", usage: "keep sessions in the database so that they can be listed and revoked", value: func(c *Config) flag.Value { return (*boolValue)(&c.ServerSessions) }},
+	{name: "registration", usage: "let anyone register an account", value: func(c *Config) flag.Value { return (*boolValue)(&c.Registration) }},
+	{name: "seed-demo", usage: "add the demo account from its fixture when it is missing", value: func(c *Config) flag.Value { return (*boolValue)(&c.SeedDemo) }},
+	{name: "demo-reset-interval", usage: "how often the demo account is reset to its fixture, such as 24h, never when 0", value: func(c *Config) flag.Value { return (*durationValue)(&c.DemoReset) }},
+	{name: "trash-retention", usage: "how long deleted pens stay in the trash before they are purged, such as 720h, forever when 0", value: func(c *Config) flag.Value { return (*durationValue)(&c.TrashRetention) }},
+	{name: "max-photo-size", usage: "largest photo that can be uploaded, such as 10MB", value: func(c *Config) flag.Value { return (*sizeValue)(&c.MaxPhotoSize) }},
+	{name: "max-upload-size", usage: "largest pen form with its photos, such as 50MB", value: func(c *Config) flag.Value { return (*sizeValue)(&c.MaxUploadSize) }},
+	{name: "captcha-questions", usage: "JSON file holding the registration CAPTCHA questions", value: func(c *Config) flag.Value { return (*stringValue)(&c.CaptchaQuestions) }},
+	{name: "base-url", usage: "URL of Flock used in links sent by email", value: func(c *Config) flag.Value { return (*stringValue)(&c.BaseURL) }},
+	{name: "smtp-addr", usage: "host:port of the SMTP server sending email, email is only logged without it", value: func(c *Config) flag.Value { return (*stringValue)(&c.SMTPAddr) }},
+	{name: "smtp-user", usage: "SMTP username", value: func(c *Config) flag.Value { return (*stringValue)(&c.SMTPUser) }},
+	{name: "smtp-password", usage: "SMTP password", secret: true, value: func(c *Config) flag.Value { return (*stringValue)(&c.SMTPPassword) }},
+	{name: "mail-from", usage: "sender address of email", value: func(c *Config) flag.Value { return (*stringValue)(&c.MailFrom) }},
+	{name: "mail-dir", usage: "directory to write email to instead of sending it", value: func(c *Config) flag.Value { return (*stringValue)(&c.MailDir) }},
+	{name: "verify-email", usage: "require new accounts to verify their email address before importing or exporting", value: func(c *Config) flag.Value { return (*boolValue)(&c.VerifyEmail) }},
+	{name: "login-free-attempts", usage: "failed logins of a username before further attempts are delayed", value: func(c *Config) flag.Value { return (*intValue)(&c.Login.FreeAttempts) }},
+	{name: "login-base-delay", usage: "wait after the first failed login past the free attempts, doubling with each further failure, such as 1s", value: func(c *Config) flag.Value { return (*durationValue)(&c.Login.BaseDelay) }},
+	{name: "login-max-delay", usage: "longest wait between failed logins before a lockout, such as 5m", value: func(c *Config) flag.Value { return (*durationValue)(&c.Login.MaxDelay) }},
+	{name: "login-lockout-after", usage: "failed logins that lock a username out", value: func(c *Config) flag.Value { return (*intValue)(&c.Login.LockoutAfter) }},
+	{name: "login-ip-free-attempts", usage: "failed logins from a client address before further attempts are delayed", value: func(c *Config) flag.Value { return (*intValue)(&c.Login.IPFreeAttempts) }},
+	{name: "login-ip-lockout-after", usage: "failed logins that lock a client address out", value: func(c *Config) flag.Value { return (*intValue)(&c.Login.IPLockoutAfter) }},
+	{name: "login-lockout", usage: "how long a lockout lasts, such as 15m", value: func(c *Config) flag.Value { return (*durationValue)(&c.Login.LockoutDuration) }},
+	{name: "admin-username", usage: "user made an administrator on start, created if missing", value: func(c *Config) flag.Value { return (*stringValue)(&c.AdminUsername) }},
+	{name: "admin-email", usage: "email address of the administrator when it is created", value: func(c *Config) flag.Value { return (*stringValue)(&c.AdminEmail) }},
+	{name: "admin-password", usage: "password of the administrator, to create it or to make an existing user one", secret: true, value: func(c *Config) flag.Value { return (*stringValue)(&c.AdminPassword) }},
+}
+
+// key is the name of the option in the file.
+func (o option) key() string {
+	return strings.ReplaceAll(o.name, "-", "_")
+}
+
+// env is the name of the option's environment variable.
+func (o option) env() string {
+	return envPrefix + strings.ToUpper(o.key())
+}
+
+// flagValue records a command line flag, so that it can be applied after the
+// file and the environment however the flags are ordered.
+type flagValue struct {
+	isBool bool
+	value  *string
+}
+
+func (f flagValue) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return *f.value
+}
+
+func (f flagValue) Set(s string) error {
+	*f.value = s
+	return nil
+}
+
+func (f flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// Load reads the settings. The file is given with -config or FLOCK_CONFIG.
+// The flags of the options are added to fs, which parses args.
+func Load(fs *flag.FlagSet, args []string) (Config, error) {
+	defaults := Default()
+	configFile := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "JSON file holding the settings, overridden by FLOCK_<SETTING> variables and flags")
+
+	given := make(map[string]*string)
+	for _, o := range options {
+		if o.secret {
+			continue
+		}
+		raw := new(string)
+		given[o.name] = raw
+		_, isBool := o.value(&defaults).(*boolValue)
+
+		usage := fmt.Sprintf("%s (%s)", o.usage, o.env())
+		if def := o.value(&defaults).String(); def != "" && def != "false" {
+			usage = fmt.Sprintf("%s (%s, default %s)", o.usage, o.env(), def)
+		}
+		fs.Var(flagValue{isBool: isBool, value: raw}, o.name, usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	c := Default()
+	if *configFile != "" {
+		if err := c.loadFile(*configFile); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, o := range options {
+		if v, ok := os.LookupEnv(o.env()); ok {
+			if err := o.value(&c).Set(v); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", o.env(), err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		raw, ok := given[f.Name]
+		if !ok || err != nil {
+			return
+		}
+		for _, o := range options {
+			if o.name == f.Name {
+				if setErr := o.value(&c).Set(*raw); setErr != nil {
+					err = fmt.Errorf("-%s: %w", o.name, setErr)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return Config{}, err
+	}
+
+	if c.SessionKeys == "" {
+		c.SessionKeys = filepath.Join(c.DataDir, "session_keys.json")
+	}
+	return c, c.Validate()
+}
+
+// loadFile applies the settings of a JSON object whose keys are option names
+// with underscores, such as {"addr": ":8080", "max_photo_size": "20MB"}.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	var settings map[string]json.RawMessage
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	known := make(map[string]option, len(options))
+	for _, o := range options {
+		known[o.key()] = o
+	}
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		o, ok := known[key]
+		if !ok {
+			return fmt.Errorf("config file %s: unknown setting %q", path, key)
+		}
+
+		// Strings are taken as they are, numbers and booleans as written
+		raw := settings[key]
+		var text string
+		if err := json.Unmarshal(raw, &text); err != nil {
+			text = string(bytes.TrimSpace(raw))
+		}
+		if err := o.value(c).Set(text); err != nil {
+			return fmt.Errorf("config file %s: %s: %w", path, key, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the settings, so that mistakes show at start rather than
+// on the first request that needs them.
+func (c Config) Validate() error {
+	var errs []error
+
+	if _, port, err := net.SplitHostPort(c.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("addr %q should be host:port or :port", c.Addr))
+	} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		errs = append(errs, fmt.Errorf("addr %q has an invalid port", c.Addr))
+	}
+
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"read_timeout", c.ReadTimeout},
+		{"write_timeout", c.WriteTimeout},
+		{"idle_timeout", c.IdleTimeout},
+		{"shutdown_timeout", c.ShutdownTimeout},
+	} {
+		if timeout.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s should be positive", timeout.name))
+		}
+	}
+	if c.MaxHeaderSize < 4<<10 || c.MaxHeaderSize > 64<<20 {
+		errs = append(errs, errors.New("max_header_size should be between 4KB and 64MB"))
+	}
+
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("log_format %q should be text or json", c.LogFormat))
+	}
+
+	switch {
+	case (c.TLSCert == "") != (c.TLSKey == ""):
+		errs = append(errs, errors.New("tls_cert and tls_key should be given together"))
+	case c.TLSCert != "" && c.TLSSelfSigned:
+		errs = append(errs, errors.New("tls_self_signed can't be used with tls_cert"))
+	case c.TLSCert != "":
+		if _, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey); err != nil {
+			errs = append(errs, fmt.Errorf("tls_cert and tls_key: %w", err))
+		}
+	}
+
+	if c.DataDir == "" {
+		errs = append(errs, errors.New("data_dir can't be empty"))
+	}
+	if err := checkDir(c.TemplateDir); err != nil {
+		errs = append(errs, fmt.Errorf("template_dir: %w", err))
+	} else if _, err := os.Stat(filepath.Join(c.TemplateDir, "login.html")); err != nil {
+		errs = append(errs, fmt.Errorf("template_dir %s doesn't hold the Flock templates", c.TemplateDir))
+	}
+	if err := checkDir(c.StaticDir); err != nil {
+		errs = append(errs, fmt.Errorf("static_dir: %w", err))
+	}
+	if c.Registration {
+		if _, err := os.Stat(c.CaptchaQuestions); err != nil {
+			errs = append(errs, fmt.Errorf("captcha_questions, needed while registration is open: %w", err))
+		}
+	}
+
+	if c.DemoReset < 0 || (c.DemoReset > 0 && c.DemoReset < time.Minute) {
+		errs = append(errs, errors.New("demo_reset_interval should be 0 or at least a minute"))
+	}
+	if c.DemoReset > 0 && !c.SeedDemo {
+		errs = append(errs, errors.New("demo_reset_interval needs seed_demo"))
+	}
+
+	if c.TrashRetention < 0 {
+		errs = append(errs, errors.New("trash_retention should be 0 or positive"))
+	}
+
+	if c.MaxPhotoSize <= 0 {
+		errs = append(errs, errors.New("max_photo_size should be positive"))
+	}
+	if c.MaxUploadSize < c.MaxPhotoSize {
+		errs = append(errs, errors.New("max_upload_size should be at least max_photo_size"))
+	}
+
+	if u, err := url.Parse(c.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("base_url %q should be an http or https URL", c.BaseURL))
+	}
+	if c.SMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(c.SMTPAddr); err != nil {
+			errs = append(errs, fmt.Errorf("smtp_addr %q should be host:port", c.SMTPAddr))
+		}
+	}
+	if err := c.Login.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("login limits: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// TLS reports whether the server is served over HTTPS.
+func (c Config) TLS() bool {
+	return c.TLSCert != "" || c.TLSSelfSigned
+}
+
+// checkDir returns an error unless path is a directory.
+func checkDir(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	return nil
+}
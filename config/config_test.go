@@ -0,0 +1,217 @@
+// config/config_test.go
+
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupDirs points the settings checked on disk at a temporary directory
+// through the environment, the lowest precedence after the file.
+func setupDirs(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, sub := range []string{"templates", "includes"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"templates/login.html", "captcha.json"} {
+		if err := os.WriteFile(filepath.Join(dir, file), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("FLOCK_DATA_DIR", filepath.Join(dir, "database"))
+	t.Setenv("FLOCK_TEMPLATE_DIR", filepath.Join(dir, "templates"))
+	t.Setenv("FLOCK_STATIC_DIR", filepath.Join(dir, "includes"))
+	t.Setenv("FLOCK_CAPTCHA_QUESTIONS", filepath.Join(dir, "captcha.json"))
+	return dir
+}
+
+// writeConfigFile writes the settings as the JSON config file and returns its path.
+func writeConfigFile(t *testing.T, dir string, settings map[string]interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "flock.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// load runs Load on a fresh flag set, as main does with the command line.
+func load(args []string) (Config, error) {
+	fs := flag.NewFlagSet("flock", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return Load(fs, args)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		file map[string]interface{} // nil for no config file
+		env  map[string]string
+		args []string      // "CONFIG" is replaced by the path of the file
+		want func(*Config) // changes from the settings loaded with nothing given
+	}{
+		{
+			name: "defaults",
+			want: func(*Config) {},
+		},
+		{
+			name: "file over defaults",
+			file: map[string]interface{}{"addr": ":8080"},
+			args: []string{"-config", "CONFIG"},
+			want: func(c *Config) { c.Addr = ":8080" },
+		},
+		{
+			name: "environment over file",
+			file: map[string]interface{}{"addr": ":8080"},
+			env:  map[string]string{"FLOCK_ADDR": ":8081"},
+			args: []string{"-config", "CONFIG"},
+			want: func(c *Config) { c.Addr = ":8081" },
+		},
+		{
+			name: "flag over environment and file",
+			file: map[string]interface{}{"addr": ":8080"},
+			env:  map[string]string{"FLOCK_ADDR": ":8081"},
+			args: []string{"-config", "CONFIG", "-addr", ":8082"},
+			want: func(c *Config) { c.Addr = ":8082" },
+		},
+		{
+			name: "flag before the file still wins",
+			file: map[string]interface{}{"addr": ":8080"},
+			args: []string{"-addr", ":8082", "-config", "CONFIG"},
+			want: func(c *Config) { c.Addr = ":8082" },
+		},
+		{
+			name: "file given in the environment",
+			file: map[string]interface{}{"addr": ":8080"},
+			env:  map[string]string{"FLOCK_CONFIG": "CONFIG"},
+			want: func(c *Config) { c.Addr = ":8080" },
+		},
+		{
+			name: "numbers and booleans in the file",
+			file: map[string]interface{}{"login_free_attempts": 5, "registration": false, "max_photo_size": "20MB"},
+			args: []string{"-config", "CONFIG"},
+			want: func(c *Config) { c.Login.FreeAttempts, c.Registration, c.MaxPhotoSize = 5, false, 20<<20 },
+		},
+		{
+			name: "boolean flag without a value",
+			env:  map[string]string{"FLOCK_VERIFY_EMAIL": "false"},
+			args: []string{"-verify-email"},
+			want: func(c *Config) { c.VerifyEmail = true },
+		},
+		{
+			name: "duration and level from the environment",
+			env:  map[string]string{"FLOCK_TRASH_RETENTION": "48h", "FLOCK_LOG_LEVEL": "debug"},
+			want: func(c *Config) { c.TrashRetention, c.LogLevel = 48*time.Hour, slog.LevelDebug },
+		},
+		{
+			name: "secret from the environment over the file",
+			file: map[string]interface{}{"smtp_password": "from file"},
+			env:  map[string]string{"FLOCK_SMTP_PASSWORD": "from environment"},
+			args: []string{"-config", "CONFIG"},
+			want: func(c *Config) { c.SMTPPassword = "from environment" },
+		},
+		{
+			name: "session keys follow the data directory",
+			args: []string{"-data-dir", "elsewhere"},
+			want: func(c *Config) {
+				c.DataDir, c.SessionKeys = "elsewhere", filepath.Join("elsewhere", "session_keys.json")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupDirs(t)
+			want, err := load(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.want(&want)
+
+			path := ""
+			if tt.file != nil {
+				path = writeConfigFile(t, dir, tt.file)
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, strings.ReplaceAll(value, "CONFIG", path))
+			}
+			args := make([]string, len(tt.args))
+			for i, arg := range tt.args {
+				args[i] = strings.ReplaceAll(arg, "CONFIG", path)
+			}
+
+			got, err := load(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsMalformedValues(t *testing.T) {
+	tests := []struct {
+		name string
+		file string // raw content of the config file, none when empty
+		env  map[string]string
+		args []string
+		want string // part of the error
+	}{
+		{name: "file that isn't JSON", file: `addr = ":8080"`, want: "config file"},
+		{name: "unknown setting in the file", file: `{"adress": ":8080"}`, want: `unknown setting "adress"`},
+		{name: "duration in the file", file: `{"read_timeout": "soon"}`, want: "read_timeout"},
+		{name: "size in the file", file: `{"max_photo_size": "big"}`, want: "max_photo_size"},
+		{name: "negative size in the file", file: `{"max_upload_size": -1}`, want: "max_upload_size"},
+		{name: "boolean in the environment", env: map[string]string{"FLOCK_REGISTRATION": "maybe"}, want: "FLOCK_REGISTRATION"},
+		{name: "number in the environment", env: map[string]string{"FLOCK_LOGIN_FREE_ATTEMPTS": "three"}, want: "FLOCK_LOGIN_FREE_ATTEMPTS"},
+		{name: "log level flag", args: []string{"-log-level", "loud"}, want: "-log-level"},
+		{name: "secret as a flag", args: []string{"-smtp-password", "hunter2"}, want: "smtp-password"},
+		{name: "unknown flag", args: []string{"-adress", ":8080"}, want: "adress"},
+		{name: "missing config file", args: []string{"-config", "missing.json"}, want: "config file"},
+		{name: "address without a port", args: []string{"-addr", "localhost"}, want: "addr"},
+		{name: "uploads smaller than a photo", args: []string{"-max-upload-size", "1MB"}, want: "max_upload_size"},
+		{name: "demo resets without seeding", args: []string{"-demo-reset-interval", "24h"}, want: "demo_reset_interval needs seed_demo"},
+		{name: "login limits", env: map[string]string{"FLOCK_LOGIN_BASE_DELAY": "0s"}, want: "login"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setupDirs(t)
+			args := tt.args
+			if tt.file != "" {
+				path := filepath.Join(dir, "flock.json")
+				if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				args = append([]string{"-config", path}, args...)
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			_, err := load(args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load err = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
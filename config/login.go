@@ -0,0 +1,46 @@
+// config/login.go
+
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// LoginLimits sets how failed logins slow down further attempts. Past the
+// free attempts every failure doubles the wait before the next attempt, and
+// once the lockout threshold is reached attempts are refused for LockoutDuration.
+type LoginLimits struct {
+	FreeAttempts    int           // failures of a username before attempts are delayed
+	LockoutAfter    int           // failures of a username that lock it out
+	IPFreeAttempts  int           // failures from a client address before attempts are delayed
+	IPLockoutAfter  int           // failures from a client address that lock it out
+	BaseDelay       time.Duration // delay after the first failure past the free attempts
+	MaxDelay        time.Duration // longest delay before a lockout
+	LockoutDuration time.Duration // how long a lockout lasts, and how long failures count
+}
+
+// DefaultLoginLimits are the limits used unless others are configured.
+var DefaultLoginLimits = LoginLimits{
+	FreeAttempts:    3,
+	LockoutAfter:    10,
+	IPFreeAttempts:  10,
+	IPLockoutAfter:  50,
+	BaseDelay:       time.Second,
+	MaxDelay:        5 * time.Minute,
+	LockoutDuration: 15 * time.Minute,
+}
+
+// Validate checks that the limits can work together.
+func (l LoginLimits) Validate() error {
+	if l.FreeAttempts < 0 || l.IPFreeAttempts < 0 {
+		return errors.New("free login attempts can't be negative")
+	}
+	if l.LockoutAfter <= l.FreeAttempts || l.IPLockoutAfter <= l.IPFreeAttempts {
+		return errors.New("logins must lock out after more failures than the free attempts")
+	}
+	if l.BaseDelay <= 0 || l.MaxDelay < l.BaseDelay || l.LockoutDuration <= 0 {
+		return errors.New("login delays must be positive, with the maximum at least the base delay")
+	}
+	return nil
+}
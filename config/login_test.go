@@ -0,0 +1,30 @@
+// config/login_test.go
+
+package config
+
+import "testing"
+
+func TestLoginLimitsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*LoginLimits)
+		ok     bool
+	}{
+		{"defaults", func(*LoginLimits) {}, true},
+		{"no free attempts", func(l *LoginLimits) { l.FreeAttempts, l.IPFreeAttempts = 0, 0 }, true},
+		{"negative free attempts", func(l *LoginLimits) { l.FreeAttempts = -1 }, false},
+		{"lockout within free attempts", func(l *LoginLimits) { l.LockoutAfter = l.FreeAttempts }, false},
+		{"address lockout within free attempts", func(l *LoginLimits) { l.IPLockoutAfter = l.IPFreeAttempts }, false},
+		{"no base delay", func(l *LoginLimits) { l.BaseDelay = 0 }, false},
+		{"max below base delay", func(l *LoginLimits) { l.MaxDelay = l.BaseDelay / 2 }, false},
+		{"no lockout duration", func(l *LoginLimits) { l.LockoutDuration = 0 }, false},
+	}
+
+	for _, tt := range tests {
+		limits := DefaultLoginLimits
+		tt.change(&limits)
+		if err := limits.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
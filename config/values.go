@@ -0,0 +1,108 @@
+// config/values.go
+
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The flag.Value types setting the fields of a Config.
+type (
+	stringValue   string
+	boolValue     bool
+	intValue      int
+	durationValue time.Duration
+	sizeValue     int64 // bytes, written with an optional KB, MB or GB suffix
+	levelValue    slog.Level
+)
+
+func (v *stringValue) String() string { return string(*v) }
+
+func (v *stringValue) Set(s string) error {
+	*v = stringValue(s)
+	return nil
+}
+
+func (v *boolValue) String() string { return strconv.FormatBool(bool(*v)) }
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("%q is not true or false", s)
+	}
+	*v = boolValue(b)
+	return nil
+}
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a whole number", s)
+	}
+	*v = intValue(n)
+	return nil
+}
+
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration such as 90s or 15m", s)
+	}
+	*v = durationValue(d)
+	return nil
+}
+
+// sizeUnits are the suffixes accepted by sizeValue, longest first.
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+func (v *sizeValue) String() string {
+	for _, u := range sizeUnits {
+		if int64(*v) >= u.bytes && int64(*v)%u.bytes == 0 {
+			return fmt.Sprintf("%d%s", int64(*v)/u.bytes, u.suffix)
+		}
+	}
+	return strconv.FormatInt(int64(*v), 10)
+}
+
+func (v *sizeValue) Set(s string) error {
+	text, unit := strings.ToUpper(strings.TrimSpace(s)), int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(text, u.suffix) {
+			text, unit = strings.TrimSpace(strings.TrimSuffix(text, u.suffix)), u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("%q is not a size such as 512KB or 10MB", s)
+	}
+	*v = sizeValue(n * unit)
+	return nil
+}
+
+func (v *levelValue) String() string { return slog.Level(*v).String() }
+
+func (v *levelValue) Set(s string) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return fmt.Errorf("%q is not debug, info, warn or error", s)
+	}
+	*v = levelValue(level)
+	return nil
+}
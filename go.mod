@@ -3,8 +3,9 @@ module flock
 go 1.21.0
 
 require (
-	github.com/gorilla/securecookie v1.1.2 // indirect
-	github.com/gorilla/sessions v1.2.2 // indirect
-	github.com/mattn/go-sqlite3 v1.14.17 // indirect
-	golang.org/x/crypto v0.20.0 // indirect
+	github.com/gorilla/sessions v1.2.2
+	github.com/mattn/go-sqlite3 v1.14.17
+	golang.org/x/crypto v0.20.0
 )
+
+require github.com/gorilla/securecookie v1.1.2
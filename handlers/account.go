@@ -0,0 +1,194 @@
+// handlers/account.go
+
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// accountLoginAttempts is the number of recent login attempts listed on the account page.
+const accountLoginAttempts = 20
+
+// accountData is what the account page shows.
+type accountData struct {
+	User             User
+	Tokens           []APIToken
+	NewToken         string // shown once, right after the token is created
+	ServerSessions   bool   // whether sessions are kept on the server and can be listed
+	Sessions         []UserSession
+	CurrentSessionID string
+	TwoFactor        TwoFactor
+	TOTPSecret       string       // set while an authenticator app is being enrolled
+	TOTPURI          template.URL // provisioning URI of TOTPSecret
+	TOTPQRCode       template.URL // QR code of TOTPURI as a PNG data URI
+	RecoveryCodes    []string     // shown once, right after they are generated
+	LoginAttempts    []LoginAttempt
+	Error            string
+	RedirectURL      string
+}
+
+// renderAccount renders the account page for the user.
+func renderAccount(w http.ResponseWriter, r *http.Request, userID int64, data accountData) {
+	u, err := userStore.GetUserByID(userID)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Unable to get your account, please try later")
+		return
+	}
+	data.User = u
+
+	tokens, err := tokenStore.ListAPITokens(userID)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Unable to get your account, please try later")
+		return
+	}
+	data.Tokens = tokens
+
+	if sessionStore != nil {
+		sessions, err := sessionStore.ListSessions(userID)
+		if err != nil {
+			RedirectWithError(w, r, "/dashboard", "Unable to get your account, please try later")
+			return
+		}
+		data.ServerSessions = true
+		data.Sessions = sessions
+		data.CurrentSessionID = currentSessionID(r)
+	}
+
+	attempts, err := loginAttemptStore.ListLoginAttempts(userID, accountLoginAttempts)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Unable to get your account, please try later")
+		return
+	}
+	data.LoginAttempts = attempts
+
+	tf, err := twoFactorStore.GetTwoFactor(userID)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Unable to get your account, please try later")
+		return
+	}
+	data.TwoFactor = tf
+	if secret := enrollmentSecret(r); secret != "" && !tf.Enabled() {
+		data.TOTPSecret = secret
+		data.TOTPURI = template.URL(totpURI(u.Username, secret))
+		if qr, err := qrDataURI(totpURI(u.Username, secret)); err == nil {
+			data.TOTPQRCode = template.URL(qr)
+		} else {
+			requestLogger(r).Error("Error drawing the QR code", "error", err)
+		}
+	}
+
+	tmpl := template.Must(template.ParseFiles(templatePath("account.html")))
+	tmpl.Execute(w, data)
+}
+
+// Account shows the user's account page.
+func Account(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	renderAccount(w, r, userID, accountData{Error: r.URL.Query().Get("error")})
+}
+
+// CreateToken creates a personal API token. The token is shown on the page
+// that answers the form, since only its hash is kept.
+func CreateToken(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+	if !requireVerifiedEmail(w, r, userID) {
+		return
+	}
+
+	token := APIToken{
+		UserID:    userID,
+		Name:      strings.TrimSpace(r.FormValue("name")),
+		Scope:     r.FormValue("scope"),
+		CreatedAt: time.Now(),
+	}
+	if err := token.Validate(); err != nil {
+		RedirectWithError(w, r, "/account", err.Error())
+		return
+	}
+
+	plain, hash, err := NewAPIToken()
+	if err != nil {
+		RedirectWithError(w, r, "/account", "Unable to create the token, please try again")
+		return
+	}
+
+	if _, err := tokenStore.CreateAPIToken(token, hash); err != nil {
+		requestLogger(r).Error("Error creating API token", "error", err)
+		RedirectWithError(w, r, "/account", "Unable to create the token, please try again")
+		return
+	}
+
+	// Keep the token out of caches and browser history
+	w.Header().Set("Cache-Control", "no-store")
+	renderAccount(w, r, userID, accountData{NewToken: plain})
+}
+
+// RevokeToken deletes a personal API token.
+func RevokeToken(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	// Get the token ID from the URL parameter
+	tokenID, err := pathID(r)
+	if err != nil {
+		RedirectWithError(w, r, "/account", "Invalid token ID")
+		return
+	}
+
+	err = tokenStore.RevokeAPIToken(userID, tokenID)
+	if err == ErrAPITokenNotFound {
+		RedirectWithError(w, r, "/account", err.Error())
+		return
+	}
+	if err != nil {
+		RedirectWithError(w, r, "/account", "Unable to revoke the token, please try again")
+		return
+	}
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// LogoutOtherSessions logs the user out everywhere except in this browser.
+func LogoutOtherSessions(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	if sessionStore == nil {
+		RedirectWithError(w, r, "/account", "Sessions are not kept on the server, other devices cannot be logged out")
+		return
+	}
+
+	if err := sessionStore.DeleteUserSessions(userID, currentSessionID(r)); err != nil {
+		RedirectWithError(w, r, "/account", "Unable to log out your other devices, please try again")
+		return
+	}
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// RevokeSession logs out a single session of the user.
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	if sessionStore == nil {
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+
+	// Only sessions of the user can be revoked
+	id := PathValue(r, "id")
+	session, err := sessionStore.GetSession(id)
+	if err != nil || session.UserID != userID {
+		RedirectWithError(w, r, "/account", ErrSessionNotFound.Error())
+		return
+	}
+
+	if err := sessionStore.DeleteSession(id); err != nil && err != ErrSessionNotFound {
+		RedirectWithError(w, r, "/account", "Unable to log out the session, please try again")
+		return
+	}
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
@@ -0,0 +1,155 @@
+// handlers/account_settings.go
+
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UpdateProfile saves the names, email address and bio of the user. A new
+// email address has to be verified again when verification is on.
+func UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	u, err := userStore.GetUserByID(userID)
+	if err != nil {
+		RedirectWithError(w, r, "/account", "Unable to get your account, please try later")
+		return
+	}
+
+	previousEmail := u.Email
+	u.FirstName = strings.TrimSpace(r.FormValue("firstName"))
+	u.MiddleName = strings.TrimSpace(r.FormValue("middleName"))
+	u.LastName = strings.TrimSpace(r.FormValue("lastName"))
+	u.Email = strings.TrimSpace(r.FormValue("email"))
+	u.Bio = strings.TrimSpace(r.FormValue("bio"))
+	if err := u.Validate(); err != nil {
+		RedirectWithError(w, r, "/account", err.Error())
+		return
+	}
+
+	emailChanged := !strings.EqualFold(u.Email, previousEmail)
+	if emailChanged && emailVerification {
+		u.EmailVerifiedAt = time.Time{}
+	}
+
+	if err := userStore.UpdateProfile(u); err != nil {
+		requestLogger(r).Error("Error updating the profile", "error", err)
+		RedirectWithError(w, r, "/account", "Unable to save your profile, please try again")
+		return
+	}
+
+	if emailChanged && emailVerification {
+		if err := sendVerificationEmail(u); err != nil {
+			requestLogger(r).Error("Error sending verification email", "error", err)
+		}
+		RedirectWithError(w, r, "/verify", "Your profile is saved, please verify your new email address")
+		return
+	}
+
+	RedirectWithError(w, r, "/account", "Your profile is saved")
+}
+
+// ChangePassword replaces the password of the user after checking the current
+// one, and logs out the user's other server-side sessions.
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	if !checkPassword(userID, r.FormValue("currentPassword")) {
+		RedirectWithError(w, r, "/account", "Incorrect current password")
+		return
+	}
+
+	password := r.FormValue("password")
+	if password != r.FormValue("confirmPassword") {
+		RedirectWithError(w, r, "/account", "The new passwords don't match")
+		return
+	}
+	if err := validatePassword(password); err != nil {
+		RedirectWithError(w, r, "/account", err.Error())
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		RedirectWithError(w, r, "/account", "Error hashing password")
+		return
+	}
+	if err := userStore.UpdatePassword(userID, hashedPassword); err != nil {
+		requestLogger(r).Error("Error changing the password", "error", err)
+		RedirectWithError(w, r, "/account", "Unable to change your password, please try again")
+		return
+	}
+
+	// Whoever knew the old password shouldn't stay logged in elsewhere
+	if sessionStore != nil {
+		if err := sessionStore.DeleteUserSessions(userID, currentSessionID(r)); err != nil {
+			requestLogger(r).Error("Error logging out the other sessions", "error", err)
+		}
+	}
+
+	RedirectWithError(w, r, "/account", "Your password is changed")
+}
+
+// ChangeUsername renames the user after checking the password. Usernames are unique.
+func ChangeUsername(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	if !checkPassword(userID, r.FormValue("password")) {
+		RedirectWithError(w, r, "/account", "Incorrect password")
+		return
+	}
+
+	username := r.FormValue("username")
+	if err := validateUsername(username); err != nil {
+		RedirectWithError(w, r, "/account", err.Error())
+		return
+	}
+
+	err := userStore.UpdateUsername(userID, username)
+	if err == ErrUsernameTaken {
+		RedirectWithError(w, r, "/account", "The username "+username+" is already taken")
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error changing the username", "error", err)
+		RedirectWithError(w, r, "/account", "Unable to change your username, please try again")
+		return
+	}
+
+	RedirectWithError(w, r, "/account", "Your username is now "+username)
+}
+
+// DeleteAccount deletes the user with their pens, inks, photos and every
+// other record, once the password and the typed username confirm it.
+func DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	u, err := userStore.GetUserByID(userID)
+	if err != nil {
+		RedirectWithError(w, r, "/account", "Unable to get your account, please try later")
+		return
+	}
+	if r.FormValue("confirmUsername") != u.Username {
+		RedirectWithError(w, r, "/account", "Please type your username to confirm")
+		return
+	}
+	if !checkPassword(userID, r.FormValue("password")) {
+		RedirectWithError(w, r, "/account", "Incorrect password")
+		return
+	}
+
+	if err := userStore.DeleteUser(userID); err != nil {
+		requestLogger(r).Error("Error deleting the account", "error", err)
+		RedirectWithError(w, r, "/account", "Unable to delete your account, please try again")
+		return
+	}
+	requestLogger(r).Info("Deleted the account")
+
+	clearSession(w, r)
+	RedirectWithError(w, r, "/login", "Your account and everything in it are deleted")
+}
@@ -0,0 +1,277 @@
+// handlers/activity.go
+
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The changes recorded in the activity of a collection.
+const (
+	ActivityInsert  = "insert"
+	ActivityUpdate  = "update"
+	ActivityDelete  = "delete"
+	ActivityImport  = "import"
+	ActivityRestore = "restore" // taken out of the trash
+	ActivityPurge   = "purge"   // deleted for good from the trash
+)
+
+// The kinds of items whose changes are recorded.
+const (
+	ItemPen         = "pen"
+	ItemInk         = "ink"
+	ItemInking      = "inking"
+	ItemMaintenance = "maintenance"
+	ItemPhoto       = "photo"
+)
+
+const (
+	// activityFeedItems is the number of changes shown on the dashboard.
+	activityFeedItems = 20
+	// activityFeedWindow is the number of recent changes read for the feed,
+	// more than it shows since the rows of an import are shown together.
+	activityFeedWindow = 500
+)
+
+// Activity is a change to a user's collection, recorded with the values of
+// the item before and after it. Values are keyed by column as in CSV files.
+type Activity struct {
+	ID        int64
+	Action    string // insert, update, delete, import, restore or purge
+	ItemType  string // pen, ink, inking, maintenance or photo
+	ItemID    int64
+	ItemName  string
+	Before    map[string]string // nil for inserts and imports
+	After     map[string]string // nil for deletes
+	RequestID string            // ID of the request in the server log
+	CreatedAt time.Time
+}
+
+// FieldChange is a value changed by an activity.
+type FieldChange struct {
+	Field  string
+	Before string
+	After  string
+}
+
+// Changes lists the values that differ before and after the activity, by
+// field name. Inserts list their values and deletes the values removed.
+func (a Activity) Changes() []FieldChange {
+	fields := make(map[string]bool)
+	for field := range a.Before {
+		fields[field] = true
+	}
+	for field := range a.After {
+		fields[field] = true
+	}
+
+	var changes []FieldChange
+	for field := range fields {
+		before, after := a.Before[field], a.After[field]
+		if before != after {
+			changes = append(changes, FieldChange{Field: field, Before: before, After: after})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// newActivity describes a change to an item of the collection made by the
+// request, to be recorded by the store method making it.
+func newActivity(r *http.Request, action, itemType string, itemID int64, name string, before, after map[string]string) Activity {
+	return Activity{Action: action, ItemType: itemType, ItemID: itemID, ItemName: name, Before: before, After: after,
+		RequestID: RequestID(r.Context()), CreatedAt: time.Now()}
+}
+
+// recordedActivities leaves out the updates that changed nothing, which
+// aren't recorded.
+func recordedActivities(activities []Activity) []Activity {
+	var changed []Activity
+	for _, a := range activities {
+		if a.Action != ActivityUpdate || len(a.Changes()) > 0 {
+			changed = append(changed, a)
+		}
+	}
+	return changed
+}
+
+// setActivityItemID gives an inserted item's ID to the activities recording it.
+func setActivityItemID(activities []Activity, id int64) {
+	for i := range activities {
+		activities[i].ItemID = id
+	}
+}
+
+// penValues returns the values of a pen for its activity.
+func penValues(p Pen) map[string]string {
+	values := make(map[string]string, len(PenColumns))
+	for _, col := range PenColumns {
+		values[col] = p.Field(col)
+	}
+	return values
+}
+
+// inkValues returns the values of an ink for its activity.
+func inkValues(i Ink) map[string]string {
+	values := make(map[string]string, len(InkColumns))
+	for _, col := range InkColumns {
+		values[col] = i.Field(col)
+	}
+	return values
+}
+
+// inkingValues returns the values of an inking for its activity.
+func inkingValues(i Inking) map[string]string {
+	values := map[string]string{
+		"pen":      i.PenName,
+		"ink":      i.InkName,
+		"inked_at": i.InkedAt.Format("2006-01-02"),
+		"notes":    i.Notes,
+	}
+	if !i.CleanedAt.IsZero() {
+		values["cleaned_at"] = i.CleanedAt.Format("2006-01-02")
+	}
+	return values
+}
+
+// maintenanceValues returns the values of a servicing for its activity.
+func maintenanceValues(e MaintenanceEvent, penName string) map[string]string {
+	return map[string]string{
+		"pen":          penName,
+		"event_type":   e.Label(),
+		"performed_at": e.PerformedAt.Format("2006-01-02"),
+		"cost":         e.Cost.String(),
+		"notes":        e.Notes,
+	}
+}
+
+// photoValues returns the values of a photo for its activity.
+func photoValues(p Photo, penName string) map[string]string {
+	return map[string]string{
+		"pen":          penName,
+		"file":         p.OriginalName,
+		"content_type": p.ContentType,
+		"size":         formatBytes(p.Size),
+	}
+}
+
+// inkingName names an inking in the activity, such as "Safari with Blue".
+func inkingName(i Inking) string {
+	return fmt.Sprintf("%s with %s", i.PenName, i.InkName)
+}
+
+// penName returns the name of a pen for the activity of its inkings,
+// maintenance and photos, or "" if it can't be found.
+func penName(userID, penID int64) string {
+	pen, err := penStore.GetPenByID(userID, penID)
+	if err != nil {
+		return ""
+	}
+	return pen.Name
+}
+
+// activityVerbs describe the actions in the activity feed.
+var activityVerbs = map[string]string{
+	ActivityInsert:  "Added",
+	ActivityUpdate:  "Updated",
+	ActivityDelete:  "Deleted",
+	ActivityImport:  "Imported",
+	ActivityRestore: "Restored",
+	ActivityPurge:   "Permanently deleted",
+}
+
+// activityFeedItem is a line of the activity feed on the dashboard.
+type activityFeedItem struct {
+	When    time.Time
+	Summary string
+	Changes []FieldChange // only shown for updates
+}
+
+// activityFeed summarizes the most recent activity, newest first. The items
+// imported, restored or deleted by a single request are shown as one line.
+func activityFeed(activities []Activity, limit int) []activityFeedItem {
+	var feed []activityFeedItem
+	for i := 0; i < len(activities) && len(feed) < limit; i++ {
+		a := activities[i]
+		item := activityFeedItem{When: a.CreatedAt}
+
+		if a.Action == ActivityUpdate {
+			item.Summary = fmt.Sprintf("Updated %s %s", a.ItemType, a.ItemName)
+			item.Changes = a.Changes()
+			feed = append(feed, item)
+			continue
+		}
+
+		n := 1
+		for i+1 < len(activities) && activities[i+1].Action == a.Action &&
+			activities[i+1].ItemType == a.ItemType && activities[i+1].RequestID == a.RequestID {
+			n++
+			i++
+		}
+		if n > 1 || a.Action == ActivityImport {
+			item.Summary = fmt.Sprintf("%s %d %s", activityVerbs[a.Action], n, plural(n, a.ItemType))
+		} else {
+			item.Summary = fmt.Sprintf("%s %s %s", activityVerbs[a.Action], a.ItemType, a.ItemName)
+		}
+		feed = append(feed, item)
+	}
+	return feed
+}
+
+// plural returns the item type for a count, such as "pens" for 2.
+func plural(n int, itemType string) string {
+	if n == 1 || itemType == ItemMaintenance {
+		return itemType
+	}
+	return itemType + "s"
+}
+
+// activityCSVHeader is the header row of the activity export.
+var activityCSVHeader = []string{"time", "request_id", "action", "item_type", "item_id", "item_name", "field", "before", "after"}
+
+// ExportActivity sends the user's whole activity as a CSV file, with a row
+// for every value each change set or removed, newest first.
+func ExportActivity(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	if !requireVerifiedEmail(w, r, userID) {
+		return
+	}
+
+	activities, err := activityStore.ListActivity(userID, 0)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Unable to get your activity, please try later")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	filename := fmt.Sprintf("flock_%s_activity.csv", time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+
+	writer := csv.NewWriter(w)
+	writer.Write(activityCSVHeader)
+	for _, a := range activities {
+		entry := []string{a.CreatedAt.UTC().Format(time.RFC3339), a.RequestID, a.Action, a.ItemType, strconv.FormatInt(a.ItemID, 10), a.ItemName}
+
+		changes := a.Changes()
+		if len(changes) == 0 {
+			writer.Write(append(entry, "", "", ""))
+			continue
+		}
+		for _, c := range changes {
+			writer.Write(append(entry[:len(entry):len(entry)], c.Field, c.Before, c.After))
+		}
+	}
+	writer.Flush()
+
+	if err := writer.Error(); err != nil {
+		requestLogger(r).Error("Error writing the activity export", "error", err)
+	}
+}
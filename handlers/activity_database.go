@@ -0,0 +1,130 @@
+// handlers/activity_database.go
+
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// changeWithActivity makes a change in a transaction of the user's database
+// and records its activities in the same transaction, so that a change and
+// its activity are stored together or not at all.
+func (s *SQLiteStore) changeWithActivity(userID int64, activities []Activity, change func(tx *sql.Tx) error) error {
+	userDB, err := s.openUserDB(userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := userDB.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := change(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := insertActivity(tx, activities); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertActivity records changes to the user's collection, leaving out the
+// updates that changed nothing.
+func insertActivity(tx *sql.Tx, activities []Activity) error {
+	for _, a := range recordedActivities(activities) {
+		before, err := activityValuesJSON(a.Before)
+		if err != nil {
+			return err
+		}
+		after, err := activityValuesJSON(a.After)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO activity (action, item_type, item_id, item_name, before_values, after_values, request_id, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, a.Action, a.ItemType, a.ItemID, a.ItemName, before, after, a.RequestID, a.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListActivity returns the user's most recent changes, newest first, or
+// every change when limit is 0.
+func (s *SQLiteStore) ListActivity(userID int64, limit int) ([]Activity, error) {
+	userDB, err := s.openUserDB(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = -1 // no limit for SQLite
+	}
+	return queryActivity(userDB, `SELECT `+activitySelectColumns+`
+		FROM activity ORDER BY created_at DESC, id DESC LIMIT ?`, limit)
+}
+
+// PenRevisions returns the activity of every modification of a pen, newest
+// first.
+func (s *SQLiteStore) PenRevisions(userID, penID int64) ([]Activity, error) {
+	userDB, err := s.openUserDB(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return queryActivity(userDB, `SELECT `+activitySelectColumns+`
+		FROM activity WHERE item_type = ? AND item_id = ? AND action = ?
+		ORDER BY created_at DESC, id DESC`, ItemPen, penID, ActivityUpdate)
+}
+
+// activitySelectColumns are the columns read by queryActivity, in order.
+const activitySelectColumns = "id, action, item_type, item_id, item_name, before_values, after_values, request_id, created_at"
+
+// queryActivity runs a query selecting activitySelectColumns and returns
+// the activities it found.
+func queryActivity(userDB *sql.DB, query string, args ...interface{}) ([]Activity, error) {
+	rows, err := userDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var activities []Activity
+	for rows.Next() {
+		var a Activity
+		var before, after sql.NullString
+		if err := rows.Scan(&a.ID, &a.Action, &a.ItemType, &a.ItemID, &a.ItemName, &before, &after, &a.RequestID, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		if before.Valid {
+			if err := json.Unmarshal([]byte(before.String), &a.Before); err != nil {
+				return nil, err
+			}
+		}
+		if after.Valid {
+			if err := json.Unmarshal([]byte(after.String), &a.After); err != nil {
+				return nil, err
+			}
+		}
+		activities = append(activities, a)
+	}
+	return activities, rows.Err()
+}
+
+// activityValuesJSON encodes the values of an item, or NULL without any.
+func activityValuesJSON(values map[string]string) (sql.NullString, error) {
+	if values == nil {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
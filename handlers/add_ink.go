@@ -0,0 +1,53 @@
+// handlers/add_ink.go
+
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// AddInk handles the addition of a new ink to the database.
+// For GET requests, it renders the add ink form. For POST requests, it builds
+// an Ink from the form values, validates it, and inserts it using the ink store.
+func AddInk(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+
+		// Build the ink from the form values
+		ink, err := InkFromForm(r)
+		if err != nil {
+			RedirectWithError(w, r, "/inks/add", err.Error())
+			return
+		}
+
+		// Insert the ink using the ink store
+		_, err = inkStore.InsertInk(userID, ink, newActivity(r, ActivityInsert, ItemInk, 0, ink.DisplayName(), nil, inkValues(ink)))
+		if err != nil {
+			requestLogger(r).Error("Error adding ink", "error", err)
+			RedirectWithError(w, r, "/inks", "Unable to add your ink, please try again")
+			return
+		}
+
+		// Redirect to the ink list after successful insertion
+		http.Redirect(w, r, "/inks", http.StatusSeeOther)
+		return
+	}
+
+	// For GET requests, render the form
+	data := struct {
+		Ink         Ink
+		CurrentYear int
+		Error       string
+		RedirectURL string
+	}{
+		CurrentYear: time.Now().Year(),
+		Error:       r.URL.Query().Get("error"),
+	}
+
+	tmpl := template.Must(template.ParseFiles(templatePath("add_ink.html")))
+	tmpl.Execute(w, data)
+}
@@ -3,48 +3,47 @@
 package handlers
 
 import (
+	"fmt"
 	"html/template"
-	"log"
 	"net/http"
-	"strings"
 	"time"
 )
 
 // AddPen handles the addition of a new pen to the database.
 // It processes both GET and POST requests. For GET requests, it renders
 // the add pen form with dynamic column names and the current year.
-// For POST requests, it extracts form values, prepares column values,
-// and inserts the pen into the database using the InsertPen function.
+// For POST requests, it builds a Pen from the form values, validates it,
+// and inserts the pen into the database using the pen store.
 func AddPen(w http.ResponseWriter, r *http.Request) {
-	var err error // Declare err here so that it's accessible in the entire function
-
 	// Get the user ID from the session
-	userID := GetUserIDFromSession(r)
-	if userID == 0 {
-		// Redirect to the login page with an error message if the user is not logged in
-		RedirectWithError(w, r, "/login", "Please login")
-		return
-	}
+	userID := currentUserID(r)
 
 	if r.Method == http.MethodPost {
-		r.ParseForm()
-
-		// Fetch dynamic column names based on user and table
-		columns := GetColumnNames(userID, "pens")
-
-		// Remove "id" from column names and values
-		columns = columns[1:]
-		columnValues := make([]interface{}, len(columns))
+		// The form may carry photos of the pen
+		if err := parseUploadForm(w, r); err != nil {
+			RedirectWithError(w, r, "/add", "The upload is too large, please add fewer or smaller photos")
+			return
+		}
 
-		// Extract form values and trim spaces
-		for i, col := range columns {
-			columnValues[i] = strings.TrimSpace(r.FormValue(col))
+		// Build the pen from the form values
+		pen, err := PenFromForm(r)
+		if err != nil {
+			RedirectWithError(w, r, "/add", err.Error())
+			return
 		}
 
-		// Insert the pen using the InsertPen function from handlers
-		err := InsertPen(userID, convertInterfaceToStringSlice(columnValues))
+		// Insert the pen using the pen store
+		penID, err := penStore.InsertPen(userID, pen, newActivity(r, ActivityInsert, ItemPen, 0, pen.Name, nil, penValues(pen)))
 		if err != nil {
-			log.Fatal(err)
+			requestLogger(r).Error("Error adding pen", "error", err)
+			RedirectWithError(w, r, "/dashboard", "Unable to add your pen, please try again")
+			return
+		}
+
+		// The pen is added, photos that fail can be uploaded again from its page
+		if err := savePhotos(r, userID, penID); err != nil {
+			RedirectWithError(w, r, fmt.Sprintf("/modify/%d", penID), err.Error())
+			return
 		}
 
 		// Redirect to the dashboard after successful insertion
@@ -53,26 +52,21 @@ func AddPen(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// For GET requests, render the form
-	// Fetch dynamic column names based on user and table
-	columns := GetColumnNames(userID, "pens")
-
 	// Prepare data for template rendering
 	data := struct {
 		Columns     []string
 		CurrentYear int
 		Title       func(string) string // Function to capitalize and replace underscores
+		Error       string
+		RedirectURL string
 	}{
-		Columns:     columns, // Include all columns, excluding "id"
+		Columns:     PenColumns,
 		CurrentYear: time.Now().Year(),
 		Title:       Title, // Pass the Title function to the template
+		Error:       r.URL.Query().Get("error"),
 	}
 
-	// log.Printf("Data for adding pen is %=v", data)
-
 	// Parse and execute the template
-	tmpl := template.Must(template.New("add.html").Funcs(template.FuncMap{"Title": Title}).ParseFiles("templates/add.html"))
-	if err != nil {
-		log.Fatal("Error parsing add.html template:", err)
-	}
+	tmpl := template.Must(template.New("add.html").Funcs(template.FuncMap{"Title": Title}).ParseFiles(templatePath("add.html")))
 	tmpl.Execute(w, data)
 }
@@ -0,0 +1,62 @@
+// handlers/add_pen_test.go
+
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAddPen(t *testing.T) {
+	tests := []struct {
+		name     string
+		form     url.Values
+		redirect string
+		pens     int
+	}{
+		{"adds the pen", url.Values{"name": {"Safari"}, "maker": {"Lamy"}, "price": {"25"}, "currency": {"EUR"}}, "/dashboard", 1},
+		{"needs a name", url.Values{"maker": {"Lamy"}}, "/add?error=a+pen+needs+a+name", 0},
+		{"refuses a bad price", url.Values{"name": {"Safari"}, "price": {"cheap"}}, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			w := c.do("/add", AddPen, http.MethodPost, "/add", tt.form)
+
+			if w.Code != http.StatusSeeOther {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusSeeOther)
+			}
+			location := w.Header().Get("Location")
+			if tt.redirect != "" && location != tt.redirect {
+				t.Errorf("redirect = %q, want %q", location, tt.redirect)
+			}
+			if tt.pens == 0 && !strings.HasPrefix(location, "/add?error=") {
+				t.Errorf("redirect = %q, want back to the form with an error", location)
+			}
+
+			pens, _ := c.store.SelectPens(c.user.ID)
+			if len(pens) != tt.pens {
+				t.Fatalf("%d pens added, want %d", len(pens), tt.pens)
+			}
+			if tt.pens == 1 && (pens[0].Maker != "Lamy" || pens[0].Price.String() != "25.00 EUR") {
+				t.Errorf("added %+v, want the values of the form", pens[0])
+			}
+		})
+	}
+}
+
+func TestAddPenRecordsActivity(t *testing.T) {
+	c := newTestClient(t)
+	c.do("/add", AddPen, http.MethodPost, "/add", url.Values{"name": {"Safari"}})
+
+	activity, err := c.store.ListActivity(c.user.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activity) != 1 || activity[0].Action != ActivityInsert || activity[0].ItemName != "Safari" {
+		t.Fatalf("activity = %+v, want the insert of Safari", activity)
+	}
+}
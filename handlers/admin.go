@@ -0,0 +1,321 @@
+// handlers/admin.go
+
+package handlers
+
+import (
+	"crypto/rand"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// adminAuditEntries is the number of recent administrator actions listed on the admin page.
+const adminAuditEntries = 50
+
+// impersonatorKey is the session key holding the administrator who is
+// impersonating the logged in user.
+const impersonatorKey = "impersonatorID"
+
+// UserStorage is what a user stores, as shown to administrators.
+type UserStorage struct {
+	Pens            int
+	DatabaseSize    int64 // bytes of the pens database
+	AttachmentsSize int64 // bytes of photos and thumbnails
+}
+
+// AdminAuditEntry records an action an administrator took on a user.
+type AdminAuditEntry struct {
+	ID             int64
+	AdminID        int64 // 0 for the configuration, or once the administrator is deleted
+	AdminUsername  string
+	Action         string
+	TargetID       int64
+	TargetUsername string
+	Details        string
+	CreatedAt      time.Time
+}
+
+// adminUserRow is a user as listed on the admin page.
+type adminUserRow struct {
+	User
+	Storage UserStorage
+}
+
+// formatBytes describes a size in B, KB or MB.
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// BootstrapAdmin makes sure the configured administrator exists. An existing
+// user is only made an administrator when the password is theirs, so that
+// registering the username first doesn't grant administration; otherwise the
+// user is created with the password. The password is required either way.
+func BootstrapAdmin(s Store, username, email, password string) error {
+	userID, err := s.GetUserIDByUsername(username)
+	if err != nil && err != ErrUserNotFound {
+		return err
+	}
+
+	details := "made an administrator"
+	if err == ErrUserNotFound {
+		if password == "" {
+			return fmt.Errorf("administrator %q doesn't exist, give its password to create it", username)
+		}
+		if err := validateUsername(username); err != nil {
+			return err
+		}
+		if err := validatePassword(password); err != nil {
+			return err
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		userID, err = s.InsertUser(username, username, "", "", email, hashedPassword, "")
+		if err != nil {
+			return err
+		}
+		if err := s.MarkEmailVerified(userID, time.Now()); err != nil {
+			return err
+		}
+		if err := s.CreateUserDB(userID); err != nil {
+			return err
+		}
+		details = "created as an administrator"
+	} else {
+		u, err := s.GetUserByID(userID)
+		if err != nil {
+			return err
+		}
+		if u.IsAdmin {
+			return nil
+		}
+
+		// Only the owner of the account, who knows its password, may promote it
+		hashedPassword, err := s.GetPasswordByUsername(username)
+		if err != nil {
+			return err
+		}
+		if password == "" || bcrypt.CompareHashAndPassword(hashedPassword, []byte(password)) != nil {
+			return fmt.Errorf("user %q exists and isn't an administrator, give its password to make it one", username)
+		}
+	}
+
+	if err := s.SetAdmin(userID, true); err != nil {
+		return err
+	}
+	log.Printf("Administrator %s %s", username, details)
+	return s.AddAdminAudit(AdminAuditEntry{
+		AdminUsername:  "configuration",
+		Action:         "bootstrap",
+		TargetID:       userID,
+		TargetUsername: username,
+		Details:        details,
+		CreatedAt:      time.Now(),
+	})
+}
+
+// activeUser reports whether a user still exists and isn't disabled.
+func activeUser(userID int64) bool {
+	u, err := userStore.GetUserByID(userID)
+	return err == nil && !u.Disabled()
+}
+
+// impersonator returns the administrator impersonating the logged in user, or 0.
+func impersonator(r *http.Request) int64 {
+	session, _ := store.Get(r, sessionName)
+	adminID, _ := session.Values[impersonatorKey].(int64)
+	return adminID
+}
+
+// auditAdmin records an administrator's action on a user, logging failures to do so.
+func auditAdmin(admin User, action string, target User, details string) {
+	entry := AdminAuditEntry{
+		AdminID:        admin.ID,
+		AdminUsername:  admin.Username,
+		Action:         action,
+		TargetID:       target.ID,
+		TargetUsername: target.Username,
+		Details:        details,
+		CreatedAt:      time.Now(),
+	}
+	if err := adminStore.AddAdminAudit(entry); err != nil {
+		log.Printf("Error recording %s of user %d by %s: %s", action, target.ID, admin.Username, err)
+	}
+}
+
+// Admin lists the users with what they store, and the recent administrator actions.
+func Admin(w http.ResponseWriter, r *http.Request) {
+	admin, _ := UserFromContext(r.Context())
+
+	users, err := adminStore.ListUsers()
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Unable to list the users, please try later")
+		return
+	}
+
+	rows := make([]adminUserRow, len(users))
+	for i, u := range users {
+		storage, err := adminStore.UserStorage(u.ID)
+		if err != nil {
+			requestLogger(r).Error("Error getting the storage of a user", "target_id", u.ID, "error", err)
+		}
+		rows[i] = adminUserRow{User: u, Storage: storage}
+	}
+
+	audit, err := adminStore.ListAdminAudit(adminAuditEntries)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Unable to get the admin audit, please try later")
+		return
+	}
+
+	tmpl := template.Must(template.New("admin.html").Funcs(template.FuncMap{"Bytes": formatBytes}).ParseFiles(templatePath("admin.html")))
+	tmpl.Execute(w, map[string]interface{}{
+		"Admin":       admin,
+		"Users":       rows,
+		"Audit":       audit,
+		"Error":       r.URL.Query().Get("error"),
+		"RedirectURL": "",
+	})
+}
+
+// AdminUser applies an action to a user, posted to /admin/users/{action}/{id}.
+// Administrators can't act on themselves or on other administrators.
+func AdminUser(w http.ResponseWriter, r *http.Request) {
+	admin, _ := UserFromContext(r.Context())
+
+	action := PathValue(r, "action")
+	userID, err := pathID(r)
+	if err != nil {
+		RedirectWithError(w, r, "/admin", "Invalid user ID")
+		return
+	}
+
+	target, err := userStore.GetUserByID(userID)
+	if err == ErrUserNotFound {
+		RedirectWithError(w, r, "/admin", "User not found")
+		return
+	}
+	if err != nil {
+		RedirectWithError(w, r, "/admin", "Unable to get the user, please try later")
+		return
+	}
+	if target.ID == admin.ID || target.IsAdmin {
+		RedirectWithError(w, r, "/admin", "Administrators can't be changed from here")
+		return
+	}
+
+	switch action {
+	case "disable":
+		err = disableUser(target)
+	case "enable":
+		err = adminStore.SetUserDisabled(target.ID, time.Time{})
+	case "reset":
+		err = forcePasswordReset(target)
+	case "verify":
+		err = userStore.MarkEmailVerified(target.ID, time.Now())
+	case "delete":
+		err = userStore.DeleteUser(target.ID)
+	case "impersonate":
+		auditAdmin(admin, action, target, "")
+		session, _ := store.Get(r, sessionName)
+		session.Values[impersonatorKey] = admin.ID
+		SetUserIDInSession(w, r, target.ID)
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	default:
+		RedirectWithError(w, r, "/admin", "Unknown action")
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error applying an admin action", "action", action, "target_id", target.ID, "error", err)
+		RedirectWithError(w, r, "/admin", "Unable to "+action+" "+target.Username+", please try again")
+		return
+	}
+
+	auditAdmin(admin, action, target, "")
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// disableUser disables a user and logs out their server-side sessions. Cookie
+// sessions stop working too, since every request checks the user is active.
+func disableUser(u User) error {
+	if err := adminStore.SetUserDisabled(u.ID, time.Now()); err != nil {
+		return err
+	}
+	if sessionStore != nil {
+		return sessionStore.DeleteUserSessions(u.ID, "")
+	}
+	return nil
+}
+
+// forcePasswordReset replaces a user's password with a random one nobody
+// knows, logs them out and mails them a link to choose a new password.
+func forcePasswordReset(u User) error {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword(random, bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	if err := userStore.UpdatePassword(u.ID, hashedPassword); err != nil {
+		return err
+	}
+	if sessionStore != nil {
+		if err := sessionStore.DeleteUserSessions(u.ID, ""); err != nil {
+			return err
+		}
+	}
+
+	link, err := newResetLink(u)
+	if err != nil {
+		return err
+	}
+	return mailer.Send(Message{
+		To:      u.Email,
+		Subject: "Choose a new Flock password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"An administrator reset the password of your Flock account %q.\n"+
+			"To choose a new password, open this link within the next hour:\n\n%s\n\n"+
+			"If the link has expired, ask for a new one with \"Forgot your password?\" on the login page.\n",
+			u.FirstName, u.Username, link),
+	})
+}
+
+// StopImpersonating logs the administrator back in as themselves.
+func StopImpersonating(w http.ResponseWriter, r *http.Request) {
+	adminID := impersonator(r)
+	if adminID == 0 {
+		RedirectWithError(w, r, "/dashboard", "You aren't impersonating anyone")
+		return
+	}
+
+	admin, err := userStore.GetUserByID(adminID)
+	if err != nil || !admin.IsAdmin || admin.Disabled() {
+		clearSession(w, r)
+		RedirectWithError(w, r, "/login", "Please login again")
+		return
+	}
+
+	if target, err := userStore.GetUserByID(GetUserIDFromSession(r)); err == nil {
+		auditAdmin(admin, "stop-impersonating", target, "")
+	}
+
+	session, _ := store.Get(r, sessionName)
+	delete(session.Values, impersonatorKey)
+	SetUserIDInSession(w, r, admin.ID)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
@@ -0,0 +1,140 @@
+// handlers/admin_database.go
+
+package handlers
+
+import (
+	"database/sql"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ListUsers returns every user ordered by ID.
+func (s *SQLiteStore) ListUsers() ([]User, error) {
+	rows, err := s.db.Query("SELECT " + userSelectColumns + " FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// UserStorage returns the number of pens of a user and the size of their
+// pens database and photos. Users without a pens database aren't given one.
+func (s *SQLiteStore) UserStorage(userID int64) (UserStorage, error) {
+	var storage UserStorage
+
+	info, err := os.Stat(s.UserDBPath(userID))
+	if os.IsNotExist(err) {
+		return storage, nil
+	}
+	if err != nil {
+		return storage, err
+	}
+	storage.DatabaseSize = info.Size()
+
+	userDB, err := s.openUserDB(userID)
+	if err != nil {
+		return storage, err
+	}
+	if err := userDB.QueryRow("SELECT COUNT(*) FROM pens").Scan(&storage.Pens); err != nil {
+		return storage, err
+	}
+
+	err = filepath.WalkDir(s.AttachmentDir(userID), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			storage.AttachmentsSize += info.Size()
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return storage, err
+	}
+	return storage, nil
+}
+
+// SetAdmin grants or removes a user's administrator role.
+func (s *SQLiteStore) SetAdmin(userID int64, admin bool) error {
+	result, err := s.db.Exec("UPDATE users SET is_admin = ? WHERE id = ?", admin, userID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetUserDisabled disables a user from the given time, or enables them for a zero time.
+func (s *SQLiteStore) SetUserDisabled(userID int64, disabledAt time.Time) error {
+	var at interface{}
+	if !disabledAt.IsZero() {
+		at = disabledAt
+	}
+
+	result, err := s.db.Exec("UPDATE users SET disabled_at = ? WHERE id = ?", at, userID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// AddAdminAudit records an administrator's action.
+func (s *SQLiteStore) AddAdminAudit(entry AdminAuditEntry) error {
+	var adminID, targetID interface{}
+	if entry.AdminID != 0 {
+		adminID = entry.AdminID
+	}
+	if entry.TargetID != 0 {
+		targetID = entry.TargetID
+	}
+
+	_, err := s.db.Exec(`INSERT INTO admin_audit (admin_id, admin_username, action, target_id, target_username, details, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		adminID, entry.AdminUsername, entry.Action, targetID, entry.TargetUsername, entry.Details, entry.CreatedAt)
+	return err
+}
+
+// ListAdminAudit returns the most recent administrator actions, newest first.
+func (s *SQLiteStore) ListAdminAudit(limit int) ([]AdminAuditEntry, error) {
+	rows, err := s.db.Query(`SELECT id, admin_id, admin_username, action, target_id, target_username, details, created_at
+		FROM admin_audit ORDER BY id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AdminAuditEntry
+	for rows.Next() {
+		var e AdminAuditEntry
+		var adminID, targetID sql.NullInt64
+		if err := rows.Scan(&e.ID, &adminID, &e.AdminUsername, &e.Action, &targetID, &e.TargetUsername, &e.Details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.AdminID, e.TargetID = adminID.Int64, targetID.Int64
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
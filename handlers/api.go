@@ -0,0 +1,139 @@
+// handlers/api.go
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxAPIBodySize is the largest JSON body accepted by the API.
+const maxAPIBodySize = 1 << 20
+
+// Error codes returned in API error objects.
+const (
+	apiErrBadRequest       = "bad_request"
+	apiErrUnauthorized     = "unauthorized"
+	apiErrForbidden        = "forbidden"
+	apiErrNotFound         = "not_found"
+	apiErrMethodNotAllowed = "method_not_allowed"
+	apiErrInvalid          = "invalid"
+	apiErrInternal         = "internal"
+)
+
+// APIError is the error object returned by the API, wrapped as {"error": {...}}.
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeJSON sends v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing API response: %s", err)
+	}
+}
+
+// writeAPIError sends an API error object with the given status.
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, struct {
+		Error APIError `json:"error"`
+	}{APIError{Code: code, Message: message}})
+}
+
+// APIMethodNotAllowed answers requests with a method the resource does not
+// support, listing the methods of the Allow header set by the router.
+func APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusMethodNotAllowed, apiErrMethodNotAllowed, "allowed methods are "+w.Header().Get("Allow"))
+}
+
+// APINotFound answers API paths that match no resource.
+func APINotFound(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusNotFound, apiErrNotFound, "no such resource")
+}
+
+// apiUserID returns the user making an API request, or 0 after answering
+// with an error. Requests are authenticated with an "Authorization: Bearer"
+// API token, or else with the browser session. Read-only tokens may only be
+// used with GET and HEAD.
+func apiUserID(w http.ResponseWriter, r *http.Request) int64 {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		userID := GetUserIDFromSession(r)
+		if userID == 0 {
+			writeAPIError(w, http.StatusUnauthorized, apiErrUnauthorized, "please login or use an API token")
+		}
+		return userID
+	}
+
+	scheme, token, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="flock"`)
+		writeAPIError(w, http.StatusUnauthorized, apiErrUnauthorized, "the Authorization header should be Bearer <token>")
+		return 0
+	}
+
+	t, err := tokenStore.UseAPIToken(hashToken(strings.TrimSpace(token)), time.Now())
+	if err == ErrAPITokenNotFound {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="flock", error="invalid_token"`)
+		writeAPIError(w, http.StatusUnauthorized, apiErrUnauthorized, "the API token is invalid or was revoked")
+		return 0
+	}
+	if err != nil {
+		requestLogger(r).Error("Error checking API token", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "unable to check the API token")
+		return 0
+	}
+
+	if !activeUser(t.UserID) {
+		writeAPIError(w, http.StatusForbidden, apiErrForbidden, "the account is disabled")
+		return 0
+	}
+
+	if !t.CanWrite() && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeAPIError(w, http.StatusForbidden, apiErrForbidden, "the API token is read-only")
+		return 0
+	}
+	return t.UserID
+}
+
+// readJSONFields decodes a JSON object body into its fields as text, so that
+// they can be parsed like form values. Numbers and booleans are accepted in
+// place of strings and null clears a field.
+func readJSONFields(w http.ResponseWriter, r *http.Request) (map[string]string, error) {
+	var raw map[string]interface{}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodySize))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		if err == io.EOF {
+			return nil, errors.New("the request body is empty")
+		}
+		return nil, fmt.Errorf("the request body is not a JSON object: %w", err)
+	}
+
+	fields := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch v := value.(type) {
+		case nil:
+			fields[key] = ""
+		case string:
+			fields[key] = v
+		case json.Number:
+			fields[key] = v.String()
+		case bool:
+			fields[key] = strconv.FormatBool(v)
+		default:
+			return nil, fmt.Errorf("%s should be a string or a number", key)
+		}
+	}
+	return fields, nil
+}
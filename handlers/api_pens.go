@@ -0,0 +1,192 @@
+// handlers/api_pens.go
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// apiPen is the JSON form of a pen. Prices are strings so that they stay exact.
+type apiPen struct {
+	ID            int64  `json:"id"`
+	Name          string `json:"name"`
+	Maker         string `json:"maker"`
+	Color         string `json:"color"`
+	Material      string `json:"material"`
+	NibSize       string `json:"nib_size"`
+	NibColor      string `json:"nib_color"`
+	FillingSystem string `json:"filling_system"`
+	Trims         string `json:"trims"`
+	Year          string `json:"year"`
+	Price         string `json:"price"`
+	Currency      string `json:"currency"`
+	Misc          string `json:"misc"`
+}
+
+// newAPIPen converts a pen to its JSON form.
+func newAPIPen(p Pen) apiPen {
+	return apiPen{
+		ID:            p.ID,
+		Name:          p.Name,
+		Maker:         p.Maker,
+		Color:         p.Color,
+		Material:      p.Material,
+		NibSize:       p.NibSize,
+		NibColor:      p.NibColor,
+		FillingSystem: p.FillingSystem,
+		Trims:         p.Trims,
+		Year:          p.Year.String(),
+		Price:         p.Price.AmountString(),
+		Currency:      p.Price.Currency,
+		Misc:          p.Misc,
+	}
+}
+
+// penFromJSON applies the fields of a JSON body to pen. PUT and POST start
+// from an empty pen, PATCH from the stored one.
+func penFromJSON(w http.ResponseWriter, r *http.Request, pen Pen) (Pen, error) {
+	fields, err := readJSONFields(w, r)
+	if err != nil {
+		return Pen{}, err
+	}
+
+	for col, value := range fields {
+		if err := pen.setField(col, value); err != nil {
+			return Pen{}, fmt.Errorf("%s: %w", col, err)
+		}
+	}
+	return pen, nil
+}
+
+// APIListPens lists the user's pens.
+func APIListPens(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	pens, err := penStore.SelectPens(userID)
+	if err != nil {
+		requestLogger(r).Error("Error listing pens", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "unable to list your pens")
+		return
+	}
+
+	result := make([]apiPen, 0, len(pens))
+	for _, p := range pens {
+		result = append(result, newAPIPen(p))
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Pens []apiPen `json:"pens"`
+	}{result})
+}
+
+// APIAddPen adds a pen.
+func APIAddPen(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	pen, err := penFromJSON(w, r, Pen{})
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrBadRequest, err.Error())
+		return
+	}
+	if err := pen.Validate(); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, apiErrInvalid, err.Error())
+		return
+	}
+
+	pen.ID, err = penStore.InsertPen(userID, pen, newActivity(r, ActivityInsert, ItemPen, 0, pen.Name, nil, penValues(pen)))
+	if err != nil {
+		requestLogger(r).Error("Error adding pen", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "unable to add your pen")
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/pens/%d", pen.ID))
+	writeJSON(w, http.StatusCreated, newAPIPen(pen))
+}
+
+// apiPathPen returns the user's pen with the ID of the path, or false after
+// answering with an error.
+func apiPathPen(w http.ResponseWriter, r *http.Request) (Pen, bool) {
+	userID := currentUserID(r)
+
+	// Get the pen ID from the URL parameter
+	penID, err := pathID(r)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, apiErrNotFound, "no such resource")
+		return Pen{}, false
+	}
+
+	pen, err := penStore.GetPenByID(userID, penID)
+	if err == ErrPenNotFound {
+		writeAPIError(w, http.StatusNotFound, apiErrNotFound, err.Error())
+		return Pen{}, false
+	}
+	if err != nil {
+		requestLogger(r).Error("Error fetching pen", "pen_id", penID, "error", err)
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "unable to get your pen")
+		return Pen{}, false
+	}
+	return pen, true
+}
+
+// APIGetPen returns a single pen.
+func APIGetPen(w http.ResponseWriter, r *http.Request) {
+	pen, ok := apiPathPen(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIPen(pen))
+}
+
+// APIUpdatePen changes a pen. PUT replaces every field of the pen while
+// PATCH only changes the given ones.
+func APIUpdatePen(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+	pen, ok := apiPathPen(w, r)
+	if !ok {
+		return
+	}
+
+	base := Pen{}
+	if r.Method == http.MethodPatch {
+		base = pen
+	}
+	updated, err := penFromJSON(w, r, base)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrBadRequest, err.Error())
+		return
+	}
+	updated.ID = pen.ID
+	if err := updated.Validate(); err != nil {
+		writeAPIError(w, http.StatusUnprocessableEntity, apiErrInvalid, err.Error())
+		return
+	}
+
+	err = penStore.UpdatePen(userID, updated, newActivity(r, ActivityUpdate, ItemPen, pen.ID, updated.Name, penValues(pen), penValues(updated)))
+	if err == ErrPenNotFound {
+		writeAPIError(w, http.StatusNotFound, apiErrNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("Error updating pen", "pen_id", pen.ID, "error", err)
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "unable to update your pen")
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIPen(updated))
+}
+
+// APIDeletePen deletes a pen.
+func APIDeletePen(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+	pen, ok := apiPathPen(w, r)
+	if !ok {
+		return
+	}
+
+	if err := penStore.DeletePenByID(userID, pen.ID, newActivity(r, ActivityDelete, ItemPen, pen.ID, pen.Name, penValues(pen), nil)); err != nil {
+		requestLogger(r).Error("Error deleting pen", "pen_id", pen.ID, "error", err)
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "unable to delete your pen")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
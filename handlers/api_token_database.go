@@ -0,0 +1,85 @@
+// handlers/api_token_database.go
+
+package handlers
+
+import (
+	"database/sql"
+	"time"
+)
+
+// apiTokenSelectColumns is the column list scanned by scanAPIToken.
+const apiTokenSelectColumns = "id, user_id, name, scope, created_at, last_used_at"
+
+// scanAPIToken scans a row selected with apiTokenSelectColumns.
+func scanAPIToken(row rowScanner) (APIToken, error) {
+	var t APIToken
+	var lastUsed sql.NullTime
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &t.CreatedAt, &lastUsed); err != nil {
+		return APIToken{}, err
+	}
+	t.LastUsedAt = lastUsed.Time
+	return t, nil
+}
+
+// CreateAPIToken stores the hash of a new API token.
+func (s *SQLiteStore) CreateAPIToken(token APIToken, tokenHash string) (int64, error) {
+	if err := token.Validate(); err != nil {
+		return 0, err
+	}
+
+	result, err := s.db.Exec(`INSERT INTO api_tokens (user_id, name, token_hash, scope, created_at)
+		VALUES (?, ?, ?, ?, ?)`, token.UserID, token.Name, tokenHash, token.Scope, token.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// ListAPITokens returns the user's API tokens, newest first.
+func (s *SQLiteStore) ListAPITokens(userID int64) ([]APIToken, error) {
+	rows, err := s.db.Query("SELECT "+apiTokenSelectColumns+" FROM api_tokens WHERE user_id = ? ORDER BY id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken deletes an API token of the user.
+func (s *SQLiteStore) RevokeAPIToken(userID, tokenID int64) error {
+	result, err := s.db.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", tokenID, userID)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+// UseAPIToken looks up an API token by its hash and records when it was used.
+func (s *SQLiteStore) UseAPIToken(tokenHash string, at time.Time) (APIToken, error) {
+	t, err := scanAPIToken(s.db.QueryRow("SELECT "+apiTokenSelectColumns+" FROM api_tokens WHERE token_hash = ?", tokenHash))
+	if err == sql.ErrNoRows {
+		return APIToken{}, ErrAPITokenNotFound
+	}
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	if _, err := s.db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", at, t.ID); err != nil {
+		return APIToken{}, err
+	}
+	t.LastUsedAt = at
+	return t, nil
+}
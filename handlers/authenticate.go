@@ -4,15 +4,22 @@ package handlers
 import (
 	"crypto/rand"
 	"encoding/hex"
-	"github.com/gorilla/sessions"
 	"log"
 	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
 )
 
-// Cookie store to manage session data
-var store *sessions.CookieStore
+// Session store to manage session data, a cookie store unless ConfigureSessions chooses otherwise
+var store sessions.Store
 
-// init initializes the cookie store with a random secret key.
+// sessionName is the name of the session cookie.
+const sessionName = "session-name"
+
+// init initializes the cookie store with a random secret key. Sessions signed
+// with it do not survive a restart, ConfigureSessions replaces it with
+// persisted keys.
 func init() {
 	// Generate a random secret key for the CookieStore
 	secretKey, err := generateRandomKey(32) // Adjust the length based on your requirements
@@ -21,33 +28,94 @@ func init() {
 	}
 
 	// Create a new CookieStore with the generated secret key
-	store = sessions.NewCookieStore([]byte(secretKey))
+	cookieStore := sessions.NewCookieStore([]byte(secretKey))
+	cookieStore.Options = defaultSessionOptions()
+	store = cookieStore
+	csrfCookies = newCSRFCookieStore([]byte(secretKey))
+}
+
+// defaultSessionOptions are the cookie options of every session.
+func defaultSessionOptions() *sessions.Options {
+	return &sessions.Options{
+		Path:     "/",
+		MaxAge:   86400 * 30,
+		HttpOnly: true,
+		Secure:   secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// secureCookies is whether session cookies are only sent over HTTPS.
+var secureCookies bool
+
+// SetSecureCookies makes session cookies HTTPS only, for servers using TLS.
+// It must be called before ConfigureSessions.
+func SetSecureCookies(secure bool) {
+	secureCookies = secure
+}
+
+// ConfigureSessions protects session cookies with the given keys. The first
+// key pair encodes new cookies and every pair decodes existing ones. When
+// backend is not nil the sessions are kept on the server, so that they can be
+// listed and revoked from the account page; otherwise they live in the cookie.
+func ConfigureSessions(keys []SessionKeyPair, backend SessionStore) error {
+	csrfCookies = newCSRFCookieStore(sessionKeyPairs(keys)...)
+	if backend == nil {
+		cookieStore := sessions.NewCookieStore(sessionKeyPairs(keys)...)
+		cookieStore.Options = defaultSessionOptions()
+		cookieStore.MaxAge(cookieStore.Options.MaxAge)
+		store = cookieStore
+		sessionStore = nil
+		return nil
+	}
+
+	// Forget the sessions that expired while the server was down
+	if err := backend.DeleteExpiredSessions(time.Now()); err != nil {
+		return err
+	}
+	store = NewDBSessionStore(backend, sessionKeyPairs(keys)...)
+	sessionStore = backend
+	return nil
+}
+
+// currentSessionID returns the ID of the server-side session of the request,
+// or "" when sessions live in cookies.
+func currentSessionID(r *http.Request) string {
+	session, _ := store.Get(r, sessionName)
+	return session.ID
 }
 
 // SetUserIDInSession sets the user ID in the session.
 func SetUserIDInSession(w http.ResponseWriter, r *http.Request, userID int64) {
-    // Retrieve the session using the store
-    session, _ := store.Get(r, "session-name")
+	// Retrieve the session using the store
+	session, _ := store.Get(r, sessionName)
 
-    // Set the user ID in the session
-    session.Values["userID"] = userID
+	// Start a new server-side session on login, so that an ID known before login is never reused
+	if sessionStore != nil && session.ID != "" {
+		sessionStore.DeleteSession(session.ID)
+		session.ID = ""
+	}
 
-    // Save the session to persist changes
-    session.Save(r, w)
+	// Set the user ID in the session, with a new CSRF token issued on the next page
+	session.Values["userID"] = userID
+	delete(session.Values, csrfTokenKey)
+
+	// Save the session to persist changes
+	session.Save(r, w)
 }
 
 // GetUserIDFromSession retrieves the user ID from the session.
 func GetUserIDFromSession(r *http.Request) int64 {
-    // Retrieve the session using the store
-    session, _ := store.Get(r, "session-name")
+	// Retrieve the session using the store
+	session, _ := store.Get(r, sessionName)
 
-    // Check if the user ID is stored in the session
-    if userID, ok := session.Values["userID"].(int64); ok {
-        return userID
-    }
+	// Check if the user ID is stored in the session, for a user who wasn't deleted or disabled since
+	if userID, ok := session.Values["userID"].(int64); ok && activeUser(userID) {
+		return userID
+	}
 
-    // Return 0 if user ID is not found
-    return 0
+	// Return 0 if user ID is not found
+	return 0
 }
 
 // generateRandomKey generates a random key with the specified length.
@@ -0,0 +1,291 @@
+// handlers/captcha.go
+
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// CAPTCHA errors shown on the registration page.
+var (
+	ErrCaptchaWrong    = errors.New("incorrect CAPTCHA answer, please try the new question")
+	ErrCaptchaExpired  = errors.New("the CAPTCHA has expired, please try the new question")
+	ErrCaptchaTooMany  = errors.New("too many wrong CAPTCHA answers, please try again later")
+	errNoCaptchaBank   = errors.New("no CAPTCHA questions are loaded")
+	errCaptchaBankSize = errors.New("the CAPTCHA question bank is empty")
+)
+
+const (
+	// captchaLifetime is how long an issued challenge can be answered.
+	captchaLifetime = 10 * time.Minute
+	// captchaMaxFailures is the number of wrong answers allowed per client within captchaFailureWindow.
+	captchaMaxFailures = 5
+	// captchaFailureWindow is how long wrong answers count against a client.
+	captchaFailureWindow = 15 * time.Minute
+	// captchaMaxChallenges is the number of unanswered challenges kept, the
+	// oldest being dropped past it so that reloading the page can't fill memory.
+	captchaMaxChallenges = 10000
+)
+
+// CaptchaQuestion is a fountain pen trivia question with every accepted answer.
+type CaptchaQuestion struct {
+	Question string   `json:"question"`
+	Answers  []string `json:"answers"`
+}
+
+// QuestionBank supplies the questions asked on the registration page.
+type QuestionBank interface {
+	// RandomQuestion returns one of the questions of the bank.
+	RandomQuestion() (CaptchaQuestion, error)
+}
+
+// FileQuestionBank is a question bank read from a JSON file holding a list of
+// {"question": "...", "answers": ["...", "..."]} objects.
+type FileQuestionBank struct {
+	questions []CaptchaQuestion
+}
+
+// LoadQuestionBank reads a question bank from a JSON file.
+func LoadQuestionBank(path string) (*FileQuestionBank, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var questions []CaptchaQuestion
+	if err := json.Unmarshal(data, &questions); err != nil {
+		return nil, fmt.Errorf("CAPTCHA questions %s: %w", path, err)
+	}
+	if len(questions) == 0 {
+		return nil, fmt.Errorf("CAPTCHA questions %s: %w", path, errCaptchaBankSize)
+	}
+	for i, q := range questions {
+		if strings.TrimSpace(q.Question) == "" || len(q.Answers) == 0 {
+			return nil, fmt.Errorf("CAPTCHA questions %s: question %d needs a question and at least one answer", path, i+1)
+		}
+	}
+	return &FileQuestionBank{questions: questions}, nil
+}
+
+// RandomQuestion returns a question picked with crypto/rand.
+func (b *FileQuestionBank) RandomQuestion() (CaptchaQuestion, error) {
+	if len(b.questions) == 0 {
+		return CaptchaQuestion{}, errCaptchaBankSize
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(b.questions))))
+	if err != nil {
+		return CaptchaQuestion{}, err
+	}
+	return b.questions[n.Int64()], nil
+}
+
+// normalizeAnswer makes answers comparable regardless of case, spacing,
+// punctuation and a leading article, so "The Eye-Dropper!" matches "eye dropper".
+func normalizeAnswer(answer string) string {
+	answer = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			return unicode.ToLower(r)
+		case unicode.IsSpace(r) || r == '-' || r == '_':
+			return ' '
+		}
+		return -1
+	}, answer)
+
+	words := strings.Fields(answer)
+	if len(words) > 1 && (words[0] == "a" || words[0] == "an" || words[0] == "the") {
+		words = words[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// captchaChallenge is a question issued to a visitor, waiting for an answer.
+type captchaChallenge struct {
+	answers   []string // normalized
+	expiresAt time.Time
+}
+
+// Captcha issues challenges and checks answers. Challenges are kept on the
+// server and referred to by a signed token, so the answer never reaches the
+// browser and a token can only be used once.
+type Captcha struct {
+	bank       QuestionBank
+	key        []byte
+	mu         sync.Mutex
+	challenges map[string]captchaChallenge
+	order      []string               // challenge IDs as issued, oldest first
+	max        int                    // challenges kept at most
+	failures   map[string][]time.Time // wrong answers by client address
+}
+
+// NewCaptcha returns a Captcha asking questions from bank.
+func NewCaptcha(bank QuestionBank) (*Captcha, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &Captcha{
+		bank:       bank,
+		key:        key,
+		challenges: make(map[string]captchaChallenge),
+		max:        captchaMaxChallenges,
+		failures:   make(map[string][]time.Time),
+	}, nil
+}
+
+// sign returns the token for a challenge ID.
+func (c *Captcha) sign(id string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyToken returns the challenge ID of a token with a valid signature.
+func (c *Captcha) verifyToken(token string) (string, bool) {
+	id, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", false
+	}
+	return id, hmac.Equal([]byte(c.sign(id)), []byte(token))
+}
+
+// prune drops expired challenges and old failures. The caller holds c.mu.
+func (c *Captcha) prune(now time.Time) {
+	for id, ch := range c.challenges {
+		if now.After(ch.expiresAt) {
+			delete(c.challenges, id)
+		}
+	}
+	c.trimOrder()
+	for client, times := range c.failures {
+		recent := times[:0]
+		for _, t := range times {
+			if now.Sub(t) < captchaFailureWindow {
+				recent = append(recent, t)
+			}
+		}
+		if len(recent) == 0 {
+			delete(c.failures, client)
+		} else {
+			c.failures[client] = recent
+		}
+	}
+}
+
+// trimOrder forgets the oldest IDs of challenges already answered or
+// expired. The caller holds c.mu.
+func (c *Captcha) trimOrder() {
+	for len(c.order) > 0 {
+		if _, ok := c.challenges[c.order[0]]; ok {
+			return
+		}
+		c.order = c.order[1:]
+	}
+}
+
+// Issue picks a question and returns it with the token identifying the challenge.
+func (c *Captcha) Issue() (question, token string, err error) {
+	q, err := c.bank.RandomQuestion()
+	if err != nil {
+		return "", "", err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	id := hex.EncodeToString(b)
+
+	answers := make([]string, len(q.Answers))
+	for i, a := range q.Answers {
+		answers[i] = normalizeAnswer(a)
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune(now)
+	for len(c.challenges) >= c.max {
+		delete(c.challenges, c.order[0])
+		c.order = c.order[1:]
+		c.trimOrder()
+	}
+	c.challenges[id] = captchaChallenge{answers: answers, expiresAt: now.Add(captchaLifetime)}
+	c.order = append(c.order, id)
+
+	return q.Question, c.sign(id), nil
+}
+
+// Verify checks the answer to the challenge of token for the client. Each
+// challenge can be answered once, right or wrong. Clients giving too many
+// wrong answers are refused until their failures age out.
+func (c *Captcha) Verify(client, token, answer string) error {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prune(now)
+
+	if len(c.failures[client]) >= captchaMaxFailures {
+		return ErrCaptchaTooMany
+	}
+
+	id, ok := c.verifyToken(token)
+	if !ok {
+		c.failures[client] = append(c.failures[client], now)
+		return ErrCaptchaExpired
+	}
+
+	challenge, ok := c.challenges[id]
+	if !ok {
+		return ErrCaptchaExpired
+	}
+	delete(c.challenges, id)
+	c.trimOrder()
+
+	given := normalizeAnswer(answer)
+	for _, a := range challenge.answers {
+		if given != "" && given == a {
+			return nil
+		}
+	}
+
+	c.failures[client] = append(c.failures[client], now)
+	return ErrCaptchaWrong
+}
+
+// captcha is the challenge issuer used by Register, set by InitCaptcha.
+var captcha *Captcha
+
+// InitCaptcha sets the question bank asked on the registration page.
+func InitCaptcha(bank QuestionBank) error {
+	c, err := NewCaptcha(bank)
+	if err != nil {
+		return err
+	}
+	captcha = c
+	return nil
+}
+
+// clientAddress returns the address used to rate limit a client.
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
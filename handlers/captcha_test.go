@@ -0,0 +1,119 @@
+// handlers/captcha_test.go
+
+package handlers
+
+import (
+	"errors"
+	"testing"
+)
+
+// fixedBank always asks the same question.
+type fixedBank struct{}
+
+func (fixedBank) RandomQuestion() (CaptchaQuestion, error) {
+	return CaptchaQuestion{Question: "What fills a piston filler?", Answers: []string{"Ink", "The Bottle"}}, nil
+}
+
+func newTestCaptcha(t *testing.T) *Captcha {
+	t.Helper()
+	c, err := NewCaptcha(fixedBank{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestNormalizeAnswer(t *testing.T) {
+	tests := []struct {
+		answer, want string
+	}{
+		{"The Eye-Dropper!", "eye dropper"},
+		{"  eye   dropper ", "eye dropper"},
+		{"A", "a"},
+		{"an ink", "ink"},
+		{"Théâtre", "théâtre"},
+		{"?!", ""},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeAnswer(tt.answer); got != tt.want {
+			t.Errorf("normalizeAnswer(%q) = %q, want %q", tt.answer, got, tt.want)
+		}
+	}
+}
+
+func TestCaptchaVerify(t *testing.T) {
+	c := newTestCaptcha(t)
+
+	_, token, err := c.Issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Verify("client", token, "bottle"); err != nil {
+		t.Errorf("right answer: %v", err)
+	}
+	if err := c.Verify("client", token, "bottle"); !errors.Is(err, ErrCaptchaExpired) {
+		t.Errorf("answering twice: err = %v, want ErrCaptchaExpired", err)
+	}
+
+	_, token, _ = c.Issue()
+	if err := c.Verify("client", token+"x", "ink"); !errors.Is(err, ErrCaptchaExpired) {
+		t.Errorf("forged token: err = %v, want ErrCaptchaExpired", err)
+	}
+	if err := c.Verify("client", token, "water"); !errors.Is(err, ErrCaptchaWrong) {
+		t.Errorf("wrong answer: err = %v, want ErrCaptchaWrong", err)
+	}
+}
+
+func TestCaptchaLimitsWrongAnswers(t *testing.T) {
+	c := newTestCaptcha(t)
+	for i := 0; i < captchaMaxFailures; i++ {
+		_, token, _ := c.Issue()
+		c.Verify("client", token, "water")
+	}
+
+	_, token, _ := c.Issue()
+	if err := c.Verify("client", token, "ink"); !errors.Is(err, ErrCaptchaTooMany) {
+		t.Errorf("after %d wrong answers: err = %v, want ErrCaptchaTooMany", captchaMaxFailures, err)
+	}
+	if err := c.Verify("other client", token, "ink"); err != nil {
+		t.Errorf("another client: %v", err)
+	}
+}
+
+func TestCaptchaDropsOldestChallenges(t *testing.T) {
+	c := newTestCaptcha(t)
+	c.max = 3
+
+	var tokens []string
+	for i := 0; i < 5; i++ {
+		_, token, err := c.Issue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+	if len(c.challenges) != c.max {
+		t.Errorf("%d challenges kept, want %d", len(c.challenges), c.max)
+	}
+
+	for i, token := range tokens {
+		err := c.Verify("client", token, "ink")
+		if kept := i >= 2; kept != (err == nil) {
+			t.Errorf("challenge %d: err = %v, want it kept %v", i, err, kept)
+		}
+	}
+
+	// Answered challenges leave room without dropping newer ones
+	_, first, _ := c.Issue()
+	_, second, _ := c.Issue()
+	if err := c.Verify("client", first, "ink"); err != nil {
+		t.Errorf("first challenge after answers: %v", err)
+	}
+	if err := c.Verify("client", second, "ink"); err != nil {
+		t.Errorf("second challenge after answers: %v", err)
+	}
+	if len(c.order) != 0 {
+		t.Errorf("%d IDs still ordered after every challenge was answered", len(c.order))
+	}
+}
@@ -0,0 +1,234 @@
+// handlers/csrf.go
+
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+const (
+	// csrfTokenKey is the session key holding the CSRF token of the session.
+	csrfTokenKey = "csrfToken"
+	// csrfField is the name of the form field carrying the token.
+	csrfField = "csrf_token"
+	// csrfHeader carries the token of requests made by scripts.
+	csrfHeader = "X-CSRF-Token"
+	// csrfCookieName is the signed cookie holding the token of visitors who
+	// aren't logged in.
+	csrfCookieName = "csrf-token"
+)
+
+// csrfCookies keeps the tokens of visitors who aren't logged in in a signed
+// cookie, compared with the token their forms send back. Saving their token
+// in the session would store a server-side session for every visitor.
+// It is signed with the session keys.
+var csrfCookies *sessions.CookieStore
+
+// newCSRFCookieStore returns the cookie store of visitors' tokens, with key
+// pairs as for sessions.
+func newCSRFCookieStore(keyPairs ...[]byte) *sessions.CookieStore {
+	cookies := sessions.NewCookieStore(keyPairs...)
+	cookies.Options = defaultSessionOptions()
+	cookies.MaxAge(cookies.Options.MaxAge)
+	return cookies
+}
+
+// csrfSession returns where the token of the request is kept: the session of
+// a logged in user, or else the signed cookie.
+func csrfSession(r *http.Request) *sessions.Session {
+	session, _ := store.Get(r, sessionName)
+	if _, loggedIn := session.Values["userID"].(int64); loggedIn {
+		return session
+	}
+	cookie, _ := csrfCookies.Get(r, csrfCookieName)
+	return cookie
+}
+
+// formTag matches the opening tag of an HTML form.
+var formTag = regexp.MustCompile(`(?i)<form\b[^>]*>`)
+
+// postMethod matches the method attribute of a form that posts.
+var postMethod = regexp.MustCompile(`(?i)\bmethod\s*=\s*["']?post\b`)
+
+// CSRF protects the handlers of next against cross-site request forgery.
+// Requests changing state must carry the token of their session, either in
+// the csrf_token form field or in the X-CSRF-Token header. The token is added
+// to every form posting from the HTML pages served. API requests carrying a
+// bearer token don't use the session and need no CSRF token.
+func CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		default:
+			if !bearerAPIRequest(r) && !validCSRFToken(w, r) {
+				return
+			}
+		}
+
+		cw := &csrfWriter{ResponseWriter: w, r: r}
+		next.ServeHTTP(cw, r)
+		cw.finish()
+	})
+}
+
+// bearerAPIRequest reports whether the request is made to the API with a
+// bearer token, which browsers don't send on their own.
+func bearerAPIRequest(r *http.Request) bool {
+	scheme, _, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	return strings.HasPrefix(r.URL.Path, "/api/") && strings.EqualFold(scheme, "Bearer")
+}
+
+// validCSRFToken checks the token sent with a request. When it is missing or
+// wrong an error has been written to w.
+func validCSRFToken(w http.ResponseWriter, r *http.Request) bool {
+	sent := r.Header.Get(csrfHeader)
+	if sent == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data" {
+			// Parse with the upload limits, the handler then finds the form parsed
+			if err := parseUploadForm(w, r); err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					RedirectWithError(w, r, r.URL.Path, "The upload is too large, please add fewer or smaller files")
+					return false
+				}
+				http.Error(w, "Unable to read the form", http.StatusBadRequest)
+				return false
+			}
+			sent = r.PostFormValue(csrfField)
+		}
+	}
+
+	expected, _ := csrfSession(r).Values[csrfTokenKey].(string)
+	if sent == "" || expected == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(expected)) != 1 {
+		http.Error(w, "This form has expired or didn't come from Flock. Please go back, reload the page and try again.", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// csrfToken returns the CSRF token of the request's session, or of the
+// visitor's cookie, creating and saving it on first use. It must be called
+// before the headers are written.
+func csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	session := csrfSession(r)
+	if token, ok := session.Values[csrfTokenKey].(string); ok && token != "" {
+		return token, nil
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	session.Values[csrfTokenKey] = token
+	return token, session.Save(r, w)
+}
+
+// csrfWriter holds back HTML responses, so that the CSRF token can be added
+// to their forms. Other responses are passed through as they are written.
+type csrfWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	status  int
+	decided bool // whether the response is known to be HTML or not
+	html    bool
+	body    bytes.Buffer
+}
+
+// WriteHeader holds the status back until the body shows what is served.
+func (cw *csrfWriter) WriteHeader(status int) {
+	if cw.decided && !cw.html {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+// Write buffers HTML and passes anything else through.
+func (cw *csrfWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		cw.decided = true
+		contentType := cw.Header().Get("Content-Type")
+		if contentType == "" {
+			contentType = http.DetectContentType(p)
+			cw.Header().Set("Content-Type", contentType)
+		}
+		cw.html = strings.HasPrefix(contentType, "text/html")
+		if !cw.html && cw.status != 0 {
+			cw.ResponseWriter.WriteHeader(cw.status)
+		}
+	}
+	if cw.html {
+		return cw.body.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *csrfWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// finish writes what was held back, with the token added to posting forms.
+func (cw *csrfWriter) finish() {
+	if cw.decided && !cw.html {
+		return
+	}
+	if !cw.decided {
+		// Nothing was written, such as for a redirect
+		if cw.status != 0 {
+			cw.ResponseWriter.WriteHeader(cw.status)
+		}
+		return
+	}
+
+	body := cw.body.Bytes()
+	if hasPostingForm(body) {
+		token, err := csrfToken(cw.ResponseWriter, cw.r)
+		if err != nil {
+			requestLogger(cw.r).Error("Error creating a CSRF token", "error", err)
+		} else {
+			body = addCSRFField(body, token)
+		}
+	}
+
+	cw.Header().Del("Content-Length")
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+	cw.ResponseWriter.Write(body)
+}
+
+// hasPostingForm reports whether the page has a form that posts.
+func hasPostingForm(page []byte) bool {
+	for _, tag := range formTag.FindAll(page, -1) {
+		if postMethod.Match(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// addCSRFField adds a hidden field with the token to every form that posts.
+func addCSRFField(page []byte, token string) []byte {
+	field := []byte(`<input type="hidden" name="` + csrfField + `" value="` + token + `">`)
+	return formTag.ReplaceAllFunc(page, func(tag []byte) []byte {
+		if !postMethod.Match(tag) {
+			return tag
+		}
+		return append(append([]byte{}, tag...), field...)
+	})
+}
@@ -0,0 +1,244 @@
+// handlers/csrf_test.go
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestHasPostingForm(t *testing.T) {
+	tests := []struct {
+		page string
+		want bool
+	}{
+		{`<form method="POST" action="/add">`, true},
+		{`<form action="/add" method=post>`, true},
+		{`<FORM METHOD='Post'>`, true},
+		{`<form method="GET" action="/search">`, false},
+		{`<form action="/search">`, false},
+		{`<p>method="post"</p>`, false},
+		{`<p>No form</p>`, false},
+	}
+
+	for _, tt := range tests {
+		if got := hasPostingForm([]byte(tt.page)); got != tt.want {
+			t.Errorf("hasPostingForm(%s) = %v, want %v", tt.page, got, tt.want)
+		}
+	}
+}
+
+func TestAddCSRFField(t *testing.T) {
+	field := `<input type="hidden" name="csrf_token" value="tok">`
+	tests := []struct {
+		page, want string
+	}{
+		{
+			`<form method="POST" action="/add"><input name="name"></form>`,
+			`<form method="POST" action="/add">` + field + `<input name="name"></form>`,
+		},
+		{
+			`<form method="GET"></form><form method="post"></form>`,
+			`<form method="GET"></form><form method="post">` + field + `</form>`,
+		},
+		{
+			`<form method="post" a></form><form method="post" b></form>`,
+			`<form method="post" a>` + field + `</form><form method="post" b>` + field + `</form>`,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := string(addCSRFField([]byte(tt.page), "tok")); got != tt.want {
+			t.Errorf("addCSRFField(%s) = %s, want %s", tt.page, got, tt.want)
+		}
+	}
+}
+
+// csrfTestPage is served by the handler behind the CSRF middleware in the tests.
+const csrfTestPage = `<html><body><form method="POST" action="/add"><input name="name"></form></body></html>`
+
+// serveCSRF serves a request through the CSRF middleware with the client's
+// cookies, to a handler showing a page with a form on GET and answering
+// "done" otherwise.
+func serveCSRF(c *testClient, r *http.Request) *httptest.ResponseRecorder {
+	for _, cookie := range c.cookies {
+		r.AddCookie(cookie)
+	}
+	h := CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fmt.Fprint(w, csrfTestPage)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "done")
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	for _, cookie := range w.Result().Cookies() {
+		c.cookies[cookie.Name] = cookie
+	}
+	return w
+}
+
+// csrfTokenField finds the value of the token field added to a page.
+var csrfTokenField = regexp.MustCompile(`<input type="hidden" name="csrf_token" value="([^"]+)">`)
+
+func TestCSRFAddsTokenToForms(t *testing.T) {
+	c := newTestClient(t)
+
+	w := serveCSRF(c, httptest.NewRequest(http.MethodGet, "/add", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	m := csrfTokenField.FindStringSubmatch(w.Body.String())
+	if m == nil {
+		t.Fatalf("page has no token field: %s", w.Body)
+	}
+
+	// The session keeps its token
+	w = serveCSRF(c, httptest.NewRequest(http.MethodGet, "/add", nil))
+	if again := csrfTokenField.FindStringSubmatch(w.Body.String()); again == nil || again[1] != m[1] {
+		t.Errorf("second page has token %v, want %s", again, m[1])
+	}
+}
+
+func TestCSRFChecksToken(t *testing.T) {
+	c := newTestClient(t)
+	w := serveCSRF(c, httptest.NewRequest(http.MethodGet, "/add", nil))
+	token := csrfTokenField.FindStringSubmatch(w.Body.String())[1]
+
+	tests := []struct {
+		name          string
+		path          string
+		field         string // token sent in the form
+		header        string // token sent in X-CSRF-Token
+		authorization string
+		want          int
+	}{
+		{"token in the form", "/add", token, "", "", http.StatusOK},
+		{"token in the header", "/add", "", token, "", http.StatusOK},
+		{"no token", "/add", "", "", "", http.StatusForbidden},
+		{"wrong token", "/add", "not" + token, "", "", http.StatusForbidden},
+		{"wrong header overriding the form", "/add", token, "wrong", "", http.StatusForbidden},
+		{"bearer token to the API", "/api/v1/pens", "", "", "Bearer secret", http.StatusOK},
+		{"bearer token outside the API", "/add", "", "", "Bearer secret", http.StatusForbidden},
+		{"other scheme to the API", "/api/v1/pens", "", "", "Basic c2VjcmV0", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"name": {"Pen"}}
+			if tt.field != "" {
+				form.Set(csrfField, tt.field)
+			}
+			r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.header != "" {
+				r.Header.Set(csrfHeader, tt.header)
+			}
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+
+			w := serveCSRF(c, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRFRefusesTokenOfAnotherSession(t *testing.T) {
+	c := newTestClient(t)
+	other := newTestClient(t)
+	w := serveCSRF(other, httptest.NewRequest(http.MethodGet, "/add", nil))
+	token := csrfTokenField.FindStringSubmatch(w.Body.String())[1]
+
+	r := httptest.NewRequest(http.MethodPost, "/add", nil)
+	r.Header.Set(csrfHeader, token)
+	if w := serveCSRF(c, r); w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestCSRFKeepsVisitorTokensOutOfServerSessions(t *testing.T) {
+	c := newTestClient(t)
+	keys := []SessionKeyPair{{HashKey: []byte(strings.Repeat("h", 32)), BlockKey: []byte(strings.Repeat("b", 32))}}
+	if err := ConfigureSessions(keys, c.store); err != nil {
+		t.Fatal(err)
+	}
+	defer ConfigureSessions(keys, nil)
+
+	w := serveCSRF(c, httptest.NewRequest(http.MethodGet, "/login", nil))
+	m := csrfTokenField.FindStringSubmatch(w.Body.String())
+	if m == nil {
+		t.Fatalf("page has no token field: %s", w.Body)
+	}
+	if _, ok := c.cookies[sessionName]; ok {
+		t.Error("a session was saved for a visitor who isn't logged in")
+	}
+	if _, ok := c.cookies[csrfCookieName]; !ok {
+		t.Fatal("no token cookie was set")
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/login", nil)
+	r.Header.Set(csrfHeader, m[1])
+	if w := serveCSRF(c, r); w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestCSRFUsesSessionOnceLoggedIn(t *testing.T) {
+	c := newTestClient(t)
+	w := serveCSRF(c, httptest.NewRequest(http.MethodGet, "/login", nil))
+	visitorToken := csrfTokenField.FindStringSubmatch(w.Body.String())[1]
+
+	c.do("/login", func(w http.ResponseWriter, r *http.Request) {
+		SetUserIDInSession(w, r, c.user.ID)
+	}, http.MethodPost, "/login", url.Values{})
+
+	w = serveCSRF(c, httptest.NewRequest(http.MethodGet, "/add", nil))
+	token := csrfTokenField.FindStringSubmatch(w.Body.String())[1]
+	if token == visitorToken {
+		t.Fatal("the token from before the login is kept")
+	}
+
+	// The cookie of a visitor no longer stands in for the session
+	r := httptest.NewRequest(http.MethodPost, "/add", nil)
+	r.Header.Set(csrfHeader, visitorToken)
+	if w := serveCSRF(c, r); w.Code != http.StatusForbidden {
+		t.Errorf("visitor token: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/add", nil)
+	r.Header.Set(csrfHeader, token)
+	if w := serveCSRF(c, r); w.Code != http.StatusOK {
+		t.Errorf("session token: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestCSRFPassesOtherResponsesThrough(t *testing.T) {
+	h := CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, `<form method="post">`)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export", nil))
+	if w.Code != http.StatusAccepted || w.Body.String() != `<form method="post">` {
+		t.Errorf("CSV response = %d %q, want it untouched", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/redirect", nil))
+	wantRedirect(t, w, "/dashboard")
+}
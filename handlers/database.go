@@ -4,550 +4,708 @@ package handlers
 
 import (
 	"database/sql"
-	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
-	"reflect"
 	"strings"
-
-	"golang.org/x/crypto/bcrypt"
+	"sync"
+	"time"
 )
 
 // dbName is the name of the SQLite database file.
 const dbName = "database.db"
 
-var db *sql.DB
+// Ensure SQLiteStore satisfies Store.
+var _ Store = (*SQLiteStore)(nil)
 
-// InitDB initializes the database connection for handlers.
-func InitDB(database *sql.DB) {
-	db = database
+// SQLiteStore implements Store on top of SQLite files: one main database
+// holding the users and one pens database per user.
+type SQLiteStore struct {
+	dir    string
+	db     *sql.DB
+	mu     sync.Mutex
+	userDB map[int64]*sql.DB
 }
 
-// CreateDatabaseIfNotExists checks if the database exists and creates it if not.
-// If the database already exists, it simply opens it.
-func CreateDatabaseIfNotExists() (*sql.DB, error) {
-	// Get the current working directory
-	currentDir, err := os.Getwd()
-	if err != nil {
-		return nil, err
-	}
+// openSQLite opens a SQLite database file, waiting on locks held by other connections.
+func openSQLite(path string) (*sql.DB, error) {
+	return sql.Open("sqlite3", path+"?_busy_timeout=5000&_foreign_keys=on")
+}
 
+// NewSQLiteStore opens (or creates) the main database in dir.
+// The returned bool reports whether the main database was newly created.
+func NewSQLiteStore(dir string) (*SQLiteStore, bool, error) {
 	// Construct the path to the main database file
-	dbPath := filepath.Join(currentDir, "database", dbName)
-
-	// Check if the main database file already exists
-	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
-		// If the main database file doesn't exist, create it
-		db, err := sql.Open("sqlite3", dbPath)
-		if err != nil {
-			return nil, err
-		}
+	dbPath := filepath.Join(dir, dbName)
 
-		// Create the users table
-		_, err = db.Exec(`CREATE TABLE IF NOT EXISTS users (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			username TEXT UNIQUE NOT NULL,
-			first_name TEXT NOT NULL,
-			middle_name TEXT,
-			last_name TEXT NOT NULL,
-			email TEXT NOT NULL,
-			password TEXT NOT NULL,
-			bio TEXT
-		)`)
-		if err != nil {
-			db.Close()
-			return nil, err
-		}
+	// Remember whether the main database file is being created
+	_, statErr := os.Stat(dbPath)
+	isNew := os.IsNotExist(statErr)
 
-		// Insert demo user
-		demoUsername := "demo"
-		demoPassword := "demo123"
-		demoFirstName := "Demo"
-		demoLastName := "User"
-		demoEmail := "demo@example.com"
-		demoBio := "This is a demo user."
-
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(demoPassword), bcrypt.DefaultCost)
-		if err != nil {
-			db.Close()
-			return nil, err
-		}
-
-		_, err = db.Exec(`INSERT INTO users (username, first_name, last_name, email, password, bio)
-			VALUES (?, ?, ?, ?, ?, ?)`, demoUsername, demoFirstName, demoLastName, demoEmail, hashedPassword, demoBio)
-		if err != nil {
-			db.Close()
-			return nil, err
-		}
-
-		// Close the database connection after creating the demo user
-		db.Close()
+	// Open the main database file, creating it if needed
+	db, err := openSQLite(dbPath)
+	if err != nil {
+		return nil, false, err
 	}
 
-	// Open the main database file
-	db, err := sql.Open("sqlite3", dbPath)
+	return &SQLiteStore{dir: dir, db: db, userDB: make(map[int64]*sql.DB)}, isNew, nil
+}
+
+// CreateDatabaseIfNotExists checks if the database in dir exists and creates
+// it if not. If the database already exists, it simply opens it. All databases
+// are migrated to the latest schema and, when seedDemo is set, the demo
+// account is added from its fixture if it is missing.
+func CreateDatabaseIfNotExists(dir string, seedDemo bool) (*SQLiteStore, MigrationReport, error) {
+	dir, err := filepath.Abs(dir)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, nil, err
 	}
 
-	// If the demo user was just created, also create the pens table for the demo user
-	demoUserID, err := GetUserIDByUsername("demo")
+	s, _, err := NewSQLiteStore(dir)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	_, err = CreateOrUpdateUserDB(demoUserID)
+	// Upgrade the main database and every user's pens database
+	report, err := s.MigrateAll()
 	if err != nil {
-		db.Close()
-		return nil, err
+		s.Close()
+		return nil, report, err
 	}
 
-	// Insert demo pens
-	demoPens := [][]string{
-		{"LAMY Safari", "LAMY", "Charcoal", "Plastic", "M", "Black", "Converter", "Silver", "2001-01-11", "30.00", "Smooth writer"},
-		{"Pilot Metropolitan", "Pilot", "Silver", "Metal", "F", "Silver", "Cartridge", "Black", "2002-05-23", "18.00", "Classic design"},
-		{"Pelikan Souverän M800", "Pelikan", "Green", "Resin", "F", "Gold", "Piston", "Gold", "2005-08-17", "600.00", "Timeless design"},
-		{"Sailor Pro Gear", "Sailor", "Black", "Resin", "M", "Gold", "Converter", "Gold", "2008-11-30", "250.00", "Japanese craftsmanship"},
-		{"Parker Duofold Centennial", "Parker", "Black", "Resin", "F", "Gold", "Converter", "Gold", "2010-03-02", "500.00", "Classic elegance"},
-		{"Faber-Castell E-Motion", "Faber-Castell", "Pearwood", "Wood", "M", "Steel", "Converter", "Chrome", "2012-07-14", "150.00", "Unique wooden design"},
-		{"Platinum 3776 Century", "Platinum", "Bourgogne", "Resin", "M", "Gold", "Converter", "Gold", "2014-10-05", "200.00", "Japanese precision"},
-		{"Sheaffer Prelude", "Sheaffer", "Gunmetal", "Metal", "F", "Steel", "Converter", "Chrome", "2016-02-18", "80.00", "Sleek and modern"},
-		{"Kaweco Sport", "Kaweco", "Classic Sport", "Plastic", "F", "Steel", "Cartridge", "Gold", "2018-04-21", "25.00", "Compact pocket pen"},
-		{"Ranga Model 4", "Ranga", "Ebonite", "Ebonite", "B", "Steel", "Eyedropper", "Gold", "2020-09-10", "50.00", "Handmade Indian pen"},
-		{"Deccan Advocate", "Deccan", "Red", "Acrylic", "M", "Steel", "Converter", "Chrome", "2021-12-03", "70.00", "Indian craftsmanship"},
-		{"Guider Acrylic", "Guider", "Blue", "Acrylic", "F", "Steel", "Eyedropper", "Silver", "2022-06-14", "60.00", "Handmade Indian pen"},
-		{"Ratnam Supreme", "Ratnam", "Green", "Ebonite", "UEF", "Gold", "Eyedropper", "Gold", "2003-09-27", "120.00", "Vintage Indian pen"},
-		{"Bhramam Mystique", "Bhramam", "Purple", "Acrylic", "BBB", "Steel", "Converter", "Chrome", "2007-12-19", "90.00", "Artisan Indian pen"},
-		{"Nakaya Piccolo Cigar", "Nakaya", "Kuro-Tamenuri", "Urushi", "EF", "Gold", "Converter", "Gold", "2011-04-07", "800.00", "Japanese Urushi masterpiece"},
-		{"Hakase Fountain Pen", "Hakase", "Brown", "Ebonite", "Music", "Gold", "Piston", "Gold", "2015-08-29", "2000.00", "Custom handmade Japanese pen"},
-		{"Conid Bulkfiller Regular", "Conid", "Black", "Resin", "Architect", "Gold", "Bulkfiller", "Gold", "2019-11-12", "700.00", "Innovative filling mechanism"},
-		{"BCHR Waterman Ideal", "Waterman", "Black", "Hard Rubber", "Italic", "Gold", "Eyedropper", "Gold", "2023-01-15", "250.00", "Vintage BCHR pen"},
-		{"Fosfor Islander", "Fosfor", "Blue", "Ebonite", "F", "Gold", "Vacuum", "Gold", "2004-06-09", "250.00", "Custom handmade pen with Vacuum system"},
-	}
-
-	for _, pen := range demoPens {
-		err := insertDemoPen(demoUserID, pen)
-		if err != nil {
-			db.Close()
-			return nil, err
+	if seedDemo {
+		if err := SeedDemo(s); err != nil {
+			s.Close()
+			return nil, report, err
 		}
 	}
 
-	return db, nil
+	return s, report, nil
 }
 
-// insertDemoPen inserts a demo pen record into the user's database.
-func insertDemoPen(userID int64, values []string) error {
-	db, err := CreateOrUpdateUserDB(userID)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
+// Close closes the main database and every open user database.
+func (s *SQLiteStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	// Insert demo pen into the "pens" table
-	err = InsertPen(userID, values)
-	if err != nil {
-		return err
+	for userID, userDB := range s.userDB {
+		userDB.Close()
+		delete(s.userDB, userID)
 	}
 
-	return nil
+	return s.db.Close()
 }
 
-// GetUserDBPath returns the path to the user's pens database file.
-func GetUserDBPath(userID int64) string {
-	// Get the current working directory
-	currentDir, err := os.Getwd()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Construct the path to the user's pens database file
-	userDBPath := filepath.Join(currentDir, "database", fmt.Sprintf("%d_pens.db", userID))
+// Dir returns the directory holding the database files.
+func (s *SQLiteStore) Dir() string {
+	return s.dir
+}
 
-	return userDBPath
+// UserDBPath returns the path to the user's pens database file.
+func (s *SQLiteStore) UserDBPath(userID int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%d_pens.db", userID))
 }
 
-// CreateOrUpdateUserDB creates or updates the user's pens database.
-func CreateOrUpdateUserDB(userID int64) (*sql.DB, error) {
+// openUserDB returns the user's pens database, creating and migrating it on first open.
+// Handles are cached for the lifetime of the store and closed by Close.
+func (s *SQLiteStore) openUserDB(userID int64) (*sql.DB, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if userDB, ok := s.userDB[userID]; ok {
+		return userDB, nil
+	}
+
 	// Get the path to the user's pens database file
-	userDBPath := GetUserDBPath(userID)
+	userDBPath := s.UserDBPath(userID)
 
-	// Check if the user's pens database file already exists
+	// Only existing users get a new file, a deleted user's requests can't bring theirs back
 	if _, err := os.Stat(userDBPath); os.IsNotExist(err) {
-		// If the user's pens database file doesn't exist, create it
-		log.Printf("Creating user's pens database for user with ID %d...\n", userID)
-
-		userDB, err := sql.Open("sqlite3", userDBPath)
-		if err != nil {
+		var exists bool
+		if err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE id = ?)", userID).Scan(&exists); err != nil {
 			return nil, err
 		}
-
-		// Create the fountainpens table
-		_, err = userDB.Exec(`CREATE TABLE IF NOT EXISTS pens (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT,
-			maker TEXT,
-			color TEXT,
-			material TEXT,
-			nib_size TEXT,
-			nib_color TEXT,
-			filling_system TEXT,
-			trims TEXT,
-			year INTEGER,
-			price REAL,
-			misc TEXT
-		)`)
-		if err != nil {
-			userDB.Close()
-			return nil, err
+		if !exists {
+			return nil, ErrUserNotFound
 		}
-
-		return userDB, nil
+		log.Printf("Creating user's pens database for user with ID %d...\n", userID)
 	}
 
-	// If the user's pens database file already exists, simply open it
-	// log.Printf("Opening existing user's pens database for user with ID %d...\n", userID)
-	userDB, err := sql.Open("sqlite3", userDBPath)
+	// Open the user's pens database, creating the file if it doesn't exist
+	userDB, err := openSQLite(userDBPath)
 	if err != nil {
 		return nil, err
 	}
 
+	// Apply any pending migrations
+	if err := migrateOnce(userDB, userDBPath, userMigrations); err != nil {
+		userDB.Close()
+		return nil, err
+	}
+
+	s.userDB[userID] = userDB
 	return userDB, nil
 }
 
-// fetchDataFromDB fetches data from the database based on the provided query.
-func fetchDataFromDB(db *sql.DB, query string) ([]string, []map[string]interface{}, error) {
-	rows, err := db.Query(query)
+// CreateUserDB creates or updates the user's pens database.
+func (s *SQLiteStore) CreateUserDB(userID int64) error {
+	_, err := s.openUserDB(userID)
+	return err
+}
+
+// SelectPens fetches all pens from the user's pens database, leaving out
+// those in the trash.
+func (s *SQLiteStore) SelectPens(userID int64) ([]Pen, error) {
+	// Open the user's pens database
+	userDB, err := s.openUserDB(userID)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	defer rows.Close()
 
-	columns, _ := rows.Columns()
-	var pens []map[string]interface{}
+	rows, err := userDB.Query("SELECT " + penSelectColumns + " FROM pens WHERE deleted_at IS NULL ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
+	var pens []Pen
 	for rows.Next() {
-		values := make([]interface{}, len(columns))
-		scanArgs := make([]interface{}, len(columns))
-		for i := range values {
-			var v interface{}
-			scanArgs[i] = &v
-			values[i] = &v
-		}
-		if err := rows.Scan(scanArgs...); err != nil {
-			return nil, nil, err
+		pen, err := scanPen(rows)
+		if err != nil {
+			return nil, err
 		}
+		pens = append(pens, pen)
+	}
 
-		retrievedPen := make(map[string]interface{})
-		for i, colName := range columns {
-			if colName == "id" {
-				retrievedPen[colName] = reflect.ValueOf(values[i]).Elem().Interface().(int64) // Cast to int64
-			} else {
-				retrievedPen[colName] = reflect.ValueOf(values[i]).Elem().Interface()
-			}
-		}
+	return pens, rows.Err()
+}
+
+// penInsertQuery is the INSERT statement for the pens table.
+var penInsertQuery = fmt.Sprintf("INSERT INTO pens (%s) VALUES (?%s)",
+	strings.Join(PenColumns, ", "), strings.Repeat(", ?", len(PenColumns)-1))
+
+// penUpdateQuery is the UPDATE statement for the pens table.
+var penUpdateQuery = fmt.Sprintf("UPDATE pens SET %s = ? WHERE id = ? AND deleted_at IS NULL", strings.Join(PenColumns, " = ?, "))
 
-		pens = append(pens, retrievedPen)
+// InsertPen inserts a new pen record into the database.
+func (s *SQLiteStore) InsertPen(userID int64, pen Pen, activities ...Activity) (int64, error) {
+	if err := pen.Validate(); err != nil {
+		return 0, err
 	}
 
-	return columns, pens, nil
+	var id int64
+	err := s.changeWithActivity(userID, activities, func(tx *sql.Tx) error {
+		result, err := tx.Exec(penInsertQuery, pen.args()...)
+		if err != nil {
+			return err
+		}
+		id, err = result.LastInsertId()
+		setActivityItemID(activities, id)
+		return err
+	})
+	return id, err
 }
 
-// GetColumnNames retrieves the column names of the specified table in the user's database.
-func GetColumnNames(userID int64, tableName string) []string {
-	db, err := sql.Open("sqlite3", GetUserDBPath(userID))
-	if err != nil {
-		log.Fatal(err)
+// InsertPens inserts several pen records in a single transaction and
+// returns their new IDs.
+func (s *SQLiteStore) InsertPens(userID int64, pens []Pen, activities ...Activity) ([]int64, error) {
+	// Check every pen before touching the database
+	for _, pen := range pens {
+		if err := pen.Validate(); err != nil {
+			return nil, fmt.Errorf("pen %q: %w", pen.Name, err)
+		}
 	}
-	defer db.Close()
 
-	rows, err := db.Query("PRAGMA table_xinfo(" + tableName + ")")
+	ids := make([]int64, len(pens))
+	err := s.changeWithActivity(userID, activities, func(tx *sql.Tx) error {
+		for i, pen := range pens {
+			result, err := tx.Exec(penInsertQuery, pen.args()...)
+			if err != nil {
+				return fmt.Errorf("pen %q: %w", pen.Name, err)
+			}
+			if ids[i], err = result.LastInsertId(); err != nil {
+				return err
+			}
+			if i < len(activities) {
+				activities[i].ItemID = ids[i]
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	defer rows.Close()
+	return ids, nil
+}
 
-	var columns []string
-	for rows.Next() {
-		var cid int
-		var name string
-		// Other unused columns can be scanned into placeholders
-		err := rows.Scan(&cid, &name, new(interface{}), new(interface{}), new(interface{}), new(interface{}), new(interface{},))
+// UpdatePen updates a pen record in the database.
+func (s *SQLiteStore) UpdatePen(userID int64, pen Pen, activities ...Activity) error {
+	if err := pen.Validate(); err != nil {
+		return err
+	}
+
+	return s.changeWithActivity(userID, activities, func(tx *sql.Tx) error {
+		// Add the ID to the end of the values
+		result, err := tx.Exec(penUpdateQuery, append(pen.args(), pen.ID)...)
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
-		columns = append(columns, name)
-	}
 
-	return columns
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return ErrPenNotFound
+		}
+		return nil
+	})
 }
 
-// SelectPens fetches all pens from the user's pens database.
-func SelectPens(userID int64) ([]map[string]interface{}, []string, error) {
-	// Get the path to the user's pens database file
-	userDBPath := GetUserDBPath(userID)
-
+// GetPenByID retrieves a pen's data by its ID for a specific user, unless
+// the pen is in the trash.
+func (s *SQLiteStore) GetPenByID(userID int64, penID int64) (Pen, error) {
 	// Open the user's pens database
-	userDB, err := sql.Open("sqlite3", userDBPath)
+	userDB, err := s.openUserDB(userID)
 	if err != nil {
-		return nil, nil, err
+		return Pen{}, err
 	}
-	defer userDB.Close()
 
-	// Construct the query to select all pens from the "pens" table
-	query := "SELECT * FROM pens"
+	pen, err := scanPen(userDB.QueryRow("SELECT "+penSelectColumns+" FROM pens WHERE id = ? AND deleted_at IS NULL", penID))
+	if err == sql.ErrNoRows {
+		return Pen{}, ErrPenNotFound
+	}
+	return pen, err
+}
 
-	// Fetch data from the user's pens database
-	columns, pens, err := fetchDataFromDB(userDB, query)
+// PenExists checks if a pen with the given ID exists in the database for the
+// given user, and isn't in the trash.
+func (s *SQLiteStore) PenExists(userID, penID int64) bool {
+	// Open the user's pens database
+	userDB, err := s.openUserDB(userID)
 	if err != nil {
-		return nil, nil, err
+		return false
+	}
+
+	// Query to check if the pen with the given ID exists for the user
+	var count int
+	err = userDB.QueryRow("SELECT COUNT(*) FROM pens WHERE id = ? AND deleted_at IS NULL", penID).Scan(&count)
+	if err != nil {
+		return false
 	}
 
-	return pens, columns, nil
+	// If count is greater than 0, the pen exists; otherwise, it doesn't
+	return count > 0
 }
 
-// InsertPen inserts a new pen record into the database.
-func InsertPen(userID int64, values []string) error {
-	// Check if values have the necessary number of elements
-	if len(values) < 1 {
-		return errors.New("insufficient values for InsertPen")
-	}
+// DeletePenByID moves a pen to the trash by its ID. Its history and photos
+// are kept until it is purged.
+func (s *SQLiteStore) DeletePenByID(userID int64, id int64, activities ...Activity) error {
+	return s.changeWithActivity(userID, activities, func(tx *sql.Tx) error {
+		// Execute the soft delete query
+		result, err := tx.Exec("UPDATE pens SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", time.Now(), id)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return ErrPenNotFound
+		}
+		return nil
+	})
+}
 
-	// Get column names excluding "id"
-	columns := GetColumnNames(userID, "pens")
-	columns = columns[1:]
+// DeletedPens returns the pens in the user's trash, last deleted first.
+func (s *SQLiteStore) DeletedPens(userID int64) ([]Pen, error) {
+	userDB, err := s.openUserDB(userID)
+	if err != nil {
+		return nil, err
+	}
 
-	// Check if the number of values matches the number of columns
-	if len(columns) != len(values) {
-		return errors.New("mismatched number of values for InsertPen")
+	rows, err := userDB.Query("SELECT " + penSelectColumns + " FROM pens WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC")
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	valuePlaceholders := make([]string, len(columns))
-	for i := range columns {
-		valuePlaceholders[i] = "?"
+	var pens []Pen
+	for rows.Next() {
+		pen, err := scanPen(rows)
+		if err != nil {
+			return nil, err
+		}
+		pens = append(pens, pen)
 	}
+	return pens, rows.Err()
+}
 
-	insertQuery := fmt.Sprintf("INSERT INTO pens (%s) VALUES (%s)", strings.Join(columns, ", "), strings.Join(valuePlaceholders, ", "))
+// RestorePen takes a pen out of the trash.
+func (s *SQLiteStore) RestorePen(userID, penID int64, activities ...Activity) error {
+	return s.changeWithActivity(userID, activities, func(tx *sql.Tx) error {
+		result, err := tx.Exec("UPDATE pens SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL", penID)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return ErrPenNotFound
+		}
+		return nil
+	})
+}
 
-	// Open the user's pens database
-	userDB, err := CreateOrUpdateUserDB(userID)
+// PurgePen deletes a pen for good, in the trash or not, with its history and
+// photos.
+func (s *SQLiteStore) PurgePen(userID, penID int64, activities ...Activity) error {
+	userDB, err := s.openUserDB(userID)
 	if err != nil {
 		return err
 	}
-	defer userDB.Close()
 
-	_, err = userDB.Exec(insertQuery, convertStringSliceToInterfaceSlice(values)...)
+	// Remember the pen's photos, their rows go with the pen but their files do not
+	photos, err := queryPhotos(userDB, "SELECT "+photoSelectColumns+" FROM photos WHERE pen_id = ?", penID)
 	if err != nil {
 		return err
 	}
+
+	err = s.changeWithActivity(userID, activities, func(tx *sql.Tx) error {
+		result, err := tx.Exec("DELETE FROM pens WHERE id = ?", penID)
+		if err != nil {
+			log.Printf("Error deleting pen with ID %d: %s", penID, err)
+			return err
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return ErrPenNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.removePhotoFiles(userID, photos)
 	return nil
 }
 
-// UpdatePen updates a pen record in the database.
-func UpdatePen(userID int64, id int64, values []string) error {
-	columns := GetColumnNames(userID, "pens")
-	columns = columns[1:] // Exclude "id"
-
-	setStatements := make([]string, len(columns))
-	for i, col := range columns {
-		setStatements[i] = fmt.Sprintf("%s = ?", col)
+// PurgeDeletedPens deletes for good the pens moved to the trash before the
+// given time and returns how many there were. Users without a pens database
+// aren't given one.
+func (s *SQLiteStore) PurgeDeletedPens(userID int64, before time.Time) (int, error) {
+	if _, err := os.Stat(s.UserDBPath(userID)); os.IsNotExist(err) {
+		return 0, nil
 	}
 
-	updateQuery := fmt.Sprintf("UPDATE pens SET %s WHERE id = ?", strings.Join(setStatements, ", "))
-
-	// Open the user's pens database
-	userDB, err := CreateOrUpdateUserDB(userID)
+	userDB, err := s.openUserDB(userID)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer userDB.Close()
 
-	values = append(values, fmt.Sprintf("%d", id)) // Add the ID to the end of the values
-	_, err = userDB.Exec(updateQuery, convertStringSliceToInterfaceSlice(values)...)
+	rows, err := userDB.Query("SELECT id FROM pens WHERE deleted_at IS NOT NULL AND deleted_at < ?", before)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for i, id := range ids {
+		if err := s.PurgePen(userID, id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
 }
 
-// GetPenByID retrieves a pen's data by its ID for a specific user.
-func GetPenByID(userID int64, penID int64) (map[string]interface{}, error) {
-	// Get the path to the user's pens database file
-	userDBPath := GetUserDBPath(userID)
+// InsertUser inserts a new user record into the database.
+func (s *SQLiteStore) InsertUser(username, firstName, middleName, lastName, email string, hashedPassword []byte, bio string) (int64, error) {
+	// Construct the INSERT query for users
+	insertUserQuery := `INSERT INTO users (username, first_name, middle_name, last_name, email, password, bio)
+                        VALUES (?, ?, ?, ?, ?, ?, ?)`
 
-	// Open the user's pens database
-	userDB, err := sql.Open("sqlite3", userDBPath)
+	// Execute the INSERT query
+	result, err := s.db.Exec(insertUserQuery, username, firstName, middleName, lastName, email, hashedPassword, bio)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	defer userDB.Close()
 
-	query := fmt.Sprintf("SELECT * FROM pens WHERE id = %d", penID)
-	_, pens, err := fetchDataFromDB(userDB, query)
+	// Get the last inserted ID
+	return result.LastInsertId()
+}
+
+// GetPasswordByUsername retrieves the hashed password from the database based on the username.
+func (s *SQLiteStore) GetPasswordByUsername(username string) ([]byte, error) {
+	// Scan the hashed password from the query result
+	var hashedPassword []byte
+	err := s.db.QueryRow(`SELECT password FROM users WHERE username = ?`, username).Scan(&hashedPassword)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
-	if len(pens) == 0 {
-		return nil, fmt.Errorf("pen not found")
-	}
-	return pens[0], nil
+
+	return hashedPassword, nil
 }
 
-// interfaceSlice converts []string to []interface{}.
-func interfaceSlice(slice []string) []interface{} {
-	interfaceSlice := make([]interface{}, len(slice))
-	for i, v := range slice {
-		interfaceSlice[i] = v
+// GetUserIDByUsername retrieves the user ID from the database based on the username.
+func (s *SQLiteStore) GetUserIDByUsername(username string) (int64, error) {
+	// Query the database to get the user ID based on the username
+	var userID int64
+	err := s.db.QueryRow("SELECT id FROM users WHERE username = ?", username).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrUserNotFound
+	}
+	if err != nil {
+		return 0, err
 	}
-	return interfaceSlice
+
+	return userID, nil
 }
 
-// interfaceSliceToString converts []interface{} to []string.
-func interfaceSliceToString(slice []interface{}) []string {
-	stringSlice := make([]string, len(slice))
-	for i, v := range slice {
-		stringSlice[i] = fmt.Sprintf("%v", v)
+// userSelectColumns is the column list scanned by scanUser.
+const userSelectColumns = "id, username, first_name, middle_name, last_name, email, bio, email_verified_at, verification_sent_at, is_admin, disabled_at"
+
+// scanUser scans a row selected with userSelectColumns.
+func scanUser(row rowScanner) (User, error) {
+	var u User
+	var middleName, bio nullString
+	var verifiedAt, sentAt, disabledAt sql.NullTime
+	if err := row.Scan(&u.ID, &u.Username, &u.FirstName, &middleName, &u.LastName, &u.Email, &bio, &verifiedAt, &sentAt, &u.IsAdmin, &disabledAt); err != nil {
+		return User{}, err
 	}
-	return stringSlice
+	u.MiddleName, u.Bio = string(middleName), string(bio)
+	u.EmailVerifiedAt, u.VerificationSentAt, u.DisabledAt = verifiedAt.Time, sentAt.Time, disabledAt.Time
+	return u, nil
 }
 
-// convertStringSliceToInterfaceSlice converts []string to []interface{}.
-func convertStringSliceToInterfaceSlice(slice []string) []interface{} {
-	interfaceSlice := make([]interface{}, len(slice))
-	for i, v := range slice {
-		interfaceSlice[i] = v
+// GetUserByID retrieves a user by ID.
+func (s *SQLiteStore) GetUserByID(userID int64) (User, error) {
+	u, err := scanUser(s.db.QueryRow("SELECT "+userSelectColumns+" FROM users WHERE id = ?", userID))
+	if err == sql.ErrNoRows {
+		return User{}, ErrUserNotFound
 	}
-	return interfaceSlice
+	return u, err
 }
 
-// PenExists checks if a pen with the given ID exists in the database for the given user.
-func PenExists(userID, penID int64) bool {
-	// Open the user's pens database
-	userDB, err := CreateOrUpdateUserDB(userID)
+// GetUsersByEmail retrieves every user registered with an email address.
+func (s *SQLiteStore) GetUsersByEmail(email string) ([]User, error) {
+	rows, err := s.db.Query("SELECT "+userSelectColumns+" FROM users WHERE email = ? COLLATE NOCASE ORDER BY id", strings.TrimSpace(email))
 	if err != nil {
-		// log.Printf("Error opening user database: %s", err)
-		return false
+		return nil, err
 	}
-	defer userDB.Close()
+	defer rows.Close()
 
-	// Query to check if the pen with the given ID exists for the user
-	query := "SELECT COUNT(*) FROM pens WHERE id = ?"
-	var count int
-	err = userDB.QueryRow(query, penID, userID).Scan(&count)
+	var users []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// UpdatePassword replaces the hashed password of a user.
+func (s *SQLiteStore) UpdatePassword(userID int64, hashedPassword []byte) error {
+	result, err := s.db.Exec("UPDATE users SET password = ? WHERE id = ?", hashedPassword, userID)
 	if err != nil {
-		// log.Printf("Error checking pen existence: %s for user %d", err, userID)
-		return false
+		return err
 	}
 
-	// If count is greater than 0, the pen exists; otherwise, it doesn't
-	return count > 0
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
 }
 
-// DeletePenByID deletes a pen from the database by its ID.
-func DeletePenByID(userID int64, id int64) error {
-	// Open the user's pens database
-	userDB, err := CreateOrUpdateUserDB(userID)
+// UpdateProfile saves a user's names, email address, bio and email verification time.
+func (s *SQLiteStore) UpdateProfile(u User) error {
+	var verifiedAt interface{}
+	if u.Verified() {
+		verifiedAt = u.EmailVerifiedAt
+	}
+
+	result, err := s.db.Exec(`UPDATE users SET first_name = ?, middle_name = ?, last_name = ?, email = ?, bio = ?, email_verified_at = ?
+		WHERE id = ?`, u.FirstName, u.MiddleName, u.LastName, u.Email, u.Bio, verifiedAt, u.ID)
 	if err != nil {
 		return err
 	}
-	defer userDB.Close()
 
-	// Construct the delete query
-	deleteQuery := "DELETE FROM pens WHERE id = ?"
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
 
-	// Execute the delete query
-	_, err = userDB.Exec(deleteQuery, id)
+// UpdateUsername renames a user.
+func (s *SQLiteStore) UpdateUsername(userID int64, username string) error {
+	result, err := s.db.Exec("UPDATE users SET username = ? WHERE id = ?", username, userID)
 	if err != nil {
-		log.Printf("Error deleting pen with ID %d: %s", id, err)
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return ErrUsernameTaken
+		}
 		return err
 	}
 
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
 	return nil
 }
 
-// InsertUser inserts a new user record into the database.
-func InsertUser(username, firstName, middleName, lastName, email string, hashedPassword []byte, bio string) (int64, error) {
-	// Ensure the db variable is not nil
-	if db == nil {
-		return 0, errors.New("database connection not initialized")
+// DeleteUser removes a user from the main database, where the rows of the
+// user in other tables go with it, and deletes the user's pens database and
+// photos. The user is only removed once the files are gone.
+func (s *SQLiteStore) DeleteUser(userID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	// Construct the INSERT query for users
-	insertUserQuery := `INSERT INTO users (username, first_name, middle_name, last_name, email, password, bio)
-                        VALUES (?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.Exec("DELETE FROM users WHERE id = ?", userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 
-	// log.Printf("Insert called with %s", insertUserQuery)
+	// The user is gone, so the files are removed while openUserDB waits
+	// and then refuses to create them again. The cached handle is closed
+	// before removing the file under it.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if userDB, ok := s.userDB[userID]; ok {
+		userDB.Close()
+		delete(s.userDB, userID)
+	}
 
-	// Execute the INSERT query
-	result, err := db.Exec(insertUserQuery, username, firstName, middleName, lastName, email, hashedPassword, bio)
-	if err != nil {
-		return 0, err
+	dbPath := s.UserDBPath(userID)
+	migratedDBs.Delete(dbPath)
+	for _, path := range []string{dbPath, dbPath + "-journal", dbPath + "-wal", dbPath + "-shm"} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing the files of deleted user %d: %w", userID, err)
+		}
+	}
+	if err := os.RemoveAll(s.AttachmentDir(userID)); err != nil {
+		return fmt.Errorf("removing the files of deleted user %d: %w", userID, err)
 	}
+	return nil
+}
 
-	// Get the last inserted ID
-	lastID, err := result.LastInsertId()
+// MarkEmailVerified records that a user verified their email address.
+func (s *SQLiteStore) MarkEmailVerified(userID int64, at time.Time) error {
+	result, err := s.db.Exec("UPDATE users SET email_verified_at = ? WHERE id = ?", at, userID)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	return lastID, nil
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
 }
 
-// GetPasswordByUsername retrieves the hashed password from the database based on the username.
-func GetPasswordByUsername(username string) ([]byte, error) {
-	// Construct the SELECT query for users
-	selectUserQuery := `SELECT password FROM users WHERE username = ?`
+// SetVerificationSent records when a verification email was last sent to a user.
+func (s *SQLiteStore) SetVerificationSent(userID int64, at time.Time) error {
+	_, err := s.db.Exec("UPDATE users SET verification_sent_at = ? WHERE id = ?", at, userID)
+	return err
+}
 
-	// Execute the SELECT query
-	row := db.QueryRow(selectUserQuery, username)
+// MarkDemo flags the user as the demo account.
+func (s *SQLiteStore) MarkDemo(userID int64) error {
+	result, err := s.db.Exec("UPDATE users SET is_demo = 1 WHERE id = ?", userID)
+	if err != nil {
+		return err
+	}
 
-	// Initialize a variable to store the retrieved password
-	var hashedPassword []byte
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
 
-	// Scan the hashed password from the query result
-	err := row.Scan(&hashedPassword)
+// GetDemoUserID returns the ID of the account flagged as the demo account.
+func (s *SQLiteStore) GetDemoUserID() (int64, error) {
+	var userID int64
+	err := s.db.QueryRow("SELECT id FROM users WHERE is_demo = 1 ORDER BY id LIMIT 1").Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrUserNotFound
+	}
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	return hashedPassword, nil
+	return userID, nil
 }
 
-// convertInterfaceToStringSlice converts a slice of interfaces to a slice of strings.
-func convertInterfaceToStringSlice(slice []interface{}) []string {
-	stringSlice := make([]string, len(slice))
-	for i, v := range slice {
-		stringSlice[i] = fmt.Sprintf("%v", v)
-	}
-	return stringSlice
+// CreatePasswordReset stores the hash of a password reset token.
+func (s *SQLiteStore) CreatePasswordReset(userID int64, tokenHash string, createdAt, expiresAt time.Time) error {
+	_, err := s.db.Exec("INSERT INTO password_resets (user_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		userID, tokenHash, createdAt, expiresAt)
+	return err
 }
 
-// GetUserIDByUsername retrieves the user ID from the database based on the username.
-func GetUserIDByUsername(username string) (int64, error) {
-	// Get the current working directory
-	currentDir, err := os.Getwd()
+// UsePasswordReset marks a password reset token as used and returns its user.
+func (s *SQLiteStore) UsePasswordReset(tokenHash string, now time.Time) (int64, error) {
+	tx, err := s.db.Begin()
 	if err != nil {
 		return 0, err
 	}
+	defer tx.Rollback()
 
-	// Construct the path to the main database file
-	dbPath := filepath.Join(currentDir, "database", dbName)
-	// Open the main database
-	db, err := sql.Open("sqlite3", dbPath)
+	var userID int64
+	err = tx.QueryRow("SELECT user_id FROM password_resets WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, now).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrResetTokenInvalid
+	}
 	if err != nil {
 		return 0, err
 	}
-	defer db.Close()
 
-	// Query the database to get the user ID based on the username
-	query := "SELECT id FROM users WHERE username = ?"
-	var userID int64
-	err = db.QueryRow(query, username).Scan(&userID)
-	if err != nil {
+	// Use up every outstanding token of the user, not only this one
+	if _, err := tx.Exec("UPDATE password_resets SET used_at = ? WHERE user_id = ? AND used_at IS NULL", now, userID); err != nil {
 		return 0, err
 	}
+	return userID, tx.Commit()
+}
 
-	return userID, nil
+// RecordResetRequest stores a request for password reset links.
+func (s *SQLiteStore) RecordResetRequest(email, ip string, at time.Time) error {
+	_, err := s.db.Exec("INSERT INTO password_reset_requests (email, ip, requested_at) VALUES (?, ?, ?)", email, ip, at)
+	return err
+}
+
+// ResetRequests counts the recent reset requests for an email address and from a client address.
+func (s *SQLiteStore) ResetRequests(email, ip string, since time.Time) (LoginFailures, error) {
+	var f LoginFailures
+	var err error
+	f.Username, f.LastUsername, err = s.countFailures(`SELECT requested_at FROM password_reset_requests
+		WHERE email = ? AND requested_at > ? ORDER BY requested_at DESC`, email, since)
+	if err != nil {
+		return f, err
+	}
+
+	f.IP, f.LastIP, err = s.countFailures(`SELECT requested_at FROM password_reset_requests
+		WHERE ip = ? AND requested_at > ? ORDER BY requested_at DESC`, ip, since)
+	return f, err
+}
+
+// DeleteResetRequestsBefore removes the reset requests made before the given time.
+func (s *SQLiteStore) DeleteResetRequestsBefore(before time.Time) error {
+	_, err := s.db.Exec("DELETE FROM password_reset_requests WHERE requested_at < ?", before)
+	return err
 }
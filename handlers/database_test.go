@@ -0,0 +1,48 @@
+// handlers/database_test.go
+
+package handlers
+
+import (
+	"os"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestDeleteUserRemovesTheirDatabase(t *testing.T) {
+	s, _, err := CreateDatabaseIfNotExists(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	userID, err := s.InsertUser("leaving", "Leav", "", "Ing", "leaving@example.com", []byte("hash"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.InsertPen(userID, Pen{Name: "Safari"}); err != nil {
+		t.Fatal(err)
+	}
+	path := s.UserDBPath(userID)
+
+	if err := s.DeleteUser(userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("pens database still there: %v", err)
+	}
+	if _, migrated := migratedDBs.Load(path); migrated {
+		t.Error("the deleted database is still marked as migrated")
+	}
+
+	// Later requests of the deleted user don't create an empty database
+	if _, err := s.SelectPens(userID); err != ErrUserNotFound {
+		t.Errorf("SelectPens err = %v, want ErrUserNotFound", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("pens database created again: %v", err)
+	}
+	if err := s.DeleteUser(userID); err != ErrUserNotFound {
+		t.Errorf("second DeleteUser err = %v, want ErrUserNotFound", err)
+	}
+}
@@ -0,0 +1,45 @@
+// handlers/delete_ink.go
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// DeleteInk handles the deletion of an ink from the database.
+func DeleteInk(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	// Get the ink ID from the URL parameter
+	inkID, err := pathID(r)
+	if err != nil {
+		RedirectWithError(w, r, "/inks", "Invalid ink ID")
+		return
+	}
+
+	ink, err := inkStore.GetInkByID(userID, inkID)
+	if err != nil {
+		RedirectWithError(w, r, "/inks", "You can only delete an ink if it exists")
+		return
+	}
+
+	// Ask before deleting, the deletion itself is posted from the confirmation page
+	if r.Method != http.MethodPost {
+		renderDeleteConfirmation(w, r, "Ink", ink.DisplayName(), "This can't be undone.", fmt.Sprintf("/inks/modify/%d", inkID))
+		return
+	}
+
+	// Delete the ink using the ink store
+	err = inkStore.DeleteInkByID(userID, inkID, newActivity(r, ActivityDelete, ItemInk, inkID, ink.DisplayName(), inkValues(ink), nil))
+	if err == ErrInkNotFound {
+		RedirectWithError(w, r, "/inks", "You can only delete an ink if it exists")
+		return
+	}
+	if err != nil {
+		RedirectWithError(w, r, "/inks", "Please try to delete once more")
+		return
+	}
+
+	http.Redirect(w, r, "/inks", http.StatusSeeOther)
+}
@@ -2,38 +2,66 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
-	"strconv"
 	// "log"
 )
 
 // DeletePen handles the deletion of a pen from the database.
 func DeletePen(w http.ResponseWriter, r *http.Request) {
-	userID := GetUserIDFromSession(r)
-	if userID == 0 {
-		RedirectWithError(w, r, "/login", "Please login to delete a pen from your database")
-		return
-	}
+	userID := currentUserID(r)
 
 	// Get the pen ID from the URL parameter
-	penID, err := strconv.ParseInt(r.URL.Path[len("/delete/"):], 10, 64)
+	penID, err := pathID(r)
 	if err != nil {
 		RedirectWithError(w, r, "/dashboard", "Please try to delete a pen and not fight the world")
 		return
 	}
 
 	// Check if the pen exists before attempting to delete
-	if !PenExists(userID, penID) {
+	pen, err := penStore.GetPenByID(userID, penID)
+	if err != nil {
 		RedirectWithError(w, r, "/dashboard", "You can only delete a pen if it exists")
 		return
 	}
 
-	// Delete the pen using the DeletePenByID function from handlers
-	err = DeletePenByID(userID, penID)
+	// Ask before deleting, the deletion itself is posted from the confirmation page
+	if r.Method != http.MethodPost {
+		renderDeleteConfirmation(w, r, "Pen", pen.Name, "It will be moved to the Trash, where it can be restored.", fmt.Sprintf("/modify/%d", penID))
+		return
+	}
+
+	// Move the pen to the trash using the pen store
+	err = penStore.DeletePenByID(userID, penID, newActivity(r, ActivityDelete, ItemPen, penID, pen.Name, penValues(pen), nil))
 	if err != nil {
 		RedirectWithError(w, r, "/dashboard", "Please try to delete once more")
 		return
 	}
+	rememberUndo(w, r, undoDelete, []int64{penID}, fmt.Sprintf("%s was moved to the Trash.", pen.Name))
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+// deleteConfirmation is shown before a pen or an ink is deleted.
+type deleteConfirmation struct {
+	Kind        string // what is deleted, such as Pen
+	Name        string
+	Note        string // what happens to the deleted item
+	Action      string // the URL the deletion is posted to
+	CancelURL   string
+	Error       string
+	RedirectURL string
+}
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+// renderDeleteConfirmation asks to confirm the deletion posted back to the
+// requested URL.
+func renderDeleteConfirmation(w http.ResponseWriter, r *http.Request, kind, name, note, cancelURL string) {
+	renderTemplate(w, "confirm_delete", deleteConfirmation{
+		Kind:      kind,
+		Name:      name,
+		Note:      note,
+		Action:    r.URL.Path,
+		CancelURL: cancelURL,
+		Error:     r.URL.Query().Get("error"),
+	})
 }
@@ -0,0 +1,59 @@
+// handlers/delete_pen_test.go
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDeletePenAsksFirst(t *testing.T) {
+	c := newTestClient(t)
+	penID := c.addPen("Safari")
+
+	w := c.do("/delete/{id}", DeletePen, http.MethodGet, fmt.Sprintf("/delete/%d", penID), nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Are you sure you want to delete <b>Safari</b>?") {
+		t.Fatalf("GET didn't ask for confirmation: status %d", w.Code)
+	}
+	if !c.store.PenExists(c.user.ID, penID) {
+		t.Fatal("GET deleted the pen")
+	}
+}
+
+func TestDeletePenMovesToTrashAndUndoes(t *testing.T) {
+	c := newTestClient(t)
+	penID := c.addPen("Safari")
+
+	w := c.do("/delete/{id}", DeletePen, http.MethodPost, fmt.Sprintf("/delete/%d", penID), nil)
+	wantRedirect(t, w, "/dashboard")
+	if c.store.PenExists(c.user.ID, penID) {
+		t.Fatal("the pen is still in the collection")
+	}
+	if deleted, _ := c.store.DeletedPens(c.user.ID); len(deleted) != 1 {
+		t.Fatalf("%d pens in the trash, want 1", len(deleted))
+	}
+
+	// The dashboard offers the undo once
+	w = c.do("/dashboard", ListPens, http.MethodGet, "/dashboard", nil)
+	if !strings.Contains(w.Body.String(), "Safari was moved to the Trash.") {
+		t.Error("the dashboard doesn't offer to undo the delete")
+	}
+	w = c.do("/dashboard", ListPens, http.MethodGet, "/dashboard", nil)
+	if strings.Contains(w.Body.String(), `action="/undo"`) {
+		t.Error("the dashboard offers the undo a second time")
+	}
+
+	wantRedirect(t, c.do("/undo", Undo, http.MethodPost, "/undo", nil), "/dashboard")
+	if !c.store.PenExists(c.user.ID, penID) {
+		t.Fatal("undo didn't restore the pen")
+	}
+	wantRedirect(t, c.do("/undo", Undo, http.MethodPost, "/undo", nil), "/dashboard?error=There+is+nothing+left+to+undo")
+}
+
+func TestDeleteUnknownPen(t *testing.T) {
+	c := newTestClient(t)
+	w := c.do("/delete/{id}", DeletePen, http.MethodPost, "/delete/7", nil)
+	wantRedirect(t, w, "/dashboard?error=You+can+only+delete+a+pen+if+it+exists")
+}
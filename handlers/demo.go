@@ -0,0 +1,178 @@
+// handlers/demo.go
+
+package handlers
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//go:embed fixtures/demo.json
+var demoFixtureJSON []byte
+
+// demoFixture is the demo account and its pens, read from fixtures/demo.json.
+type demoFixture struct {
+	User struct {
+		Username  string `json:"username"`
+		Password  string `json:"password"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Email     string `json:"email"`
+		Bio       string `json:"bio"`
+	} `json:"user"`
+	// Pens map pen columns, as named in CSV files, to their values
+	Pens []map[string]string `json:"pens"`
+}
+
+// loadDemoFixture parses the embedded fixture and checks its pens.
+func loadDemoFixture() (demoFixture, []Pen, error) {
+	var fixture demoFixture
+	if err := json.Unmarshal(demoFixtureJSON, &fixture); err != nil {
+		return fixture, nil, fmt.Errorf("demo fixture: %w", err)
+	}
+	if fixture.User.Username == "" || fixture.User.Password == "" {
+		return fixture, nil, fmt.Errorf("demo fixture: the user needs a username and a password")
+	}
+
+	pens := make([]Pen, len(fixture.Pens))
+	for i, values := range fixture.Pens {
+		header := make([]string, 0, len(values))
+		for col := range values {
+			header = append(header, col)
+		}
+		sort.Strings(header)
+
+		record := make([]string, len(header))
+		for j, col := range header {
+			record[j] = values[col]
+		}
+
+		pen, err := PenFromRecord(header, record)
+		if err != nil {
+			return fixture, nil, fmt.Errorf("demo fixture: pen %d: %w", i+1, err)
+		}
+		pens[i] = pen
+	}
+	return fixture, pens, nil
+}
+
+// demoSeeded is whether the server runs in demo mode, seeding the demo
+// account, so that the home page offers its login.
+var demoSeeded bool
+
+// SetDemoSeeded tells the home page whether the demo account is seeded.
+func SetDemoSeeded(seeded bool) {
+	demoSeeded = seeded
+}
+
+// SeedDemo adds the demo account with the pens of the fixture, unless an
+// account is already flagged as the demo account. Running it again changes
+// nothing, so it can run on every start. It fails rather than taking over an
+// account that merely has the demo username.
+func SeedDemo(s Store) error {
+	fixture, pens, err := loadDemoFixture()
+	if err != nil {
+		return err
+	}
+
+	_, err = s.GetDemoUserID()
+	if err == nil {
+		return nil
+	}
+	if err != ErrUserNotFound {
+		return err
+	}
+
+	u := fixture.User
+	if _, err := s.GetUserIDByUsername(u.Username); err == nil {
+		return fmt.Errorf("demo fixture: the username %q belongs to an account that isn't the demo account", u.Username)
+	} else if err != ErrUserNotFound {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	demoID, err := s.InsertUser(u.Username, u.FirstName, "", u.LastName, u.Email, hashedPassword, u.Bio)
+	if err != nil {
+		return err
+	}
+	if err := s.MarkDemo(demoID); err != nil {
+		return err
+	}
+
+	// The demo address can't receive mail, so it is verified from the start
+	if err := s.MarkEmailVerified(demoID, time.Now()); err != nil {
+		return err
+	}
+
+	if err := s.CreateUserDB(demoID); err != nil {
+		return err
+	}
+	_, err = s.InsertPens(demoID, pens)
+	return err
+}
+
+// RemoveDemo deletes the demo account with everything visitors added to it.
+// It returns false when there was no demo account.
+func RemoveDemo(s Store) (bool, error) {
+	demoID, err := s.GetDemoUserID()
+	if err == ErrUserNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, s.DeleteUser(demoID)
+}
+
+// ResetDemo deletes the demo account with everything visitors added to it,
+// and seeds it again from the fixture. Only the account flagged as the demo
+// account is deleted, whatever its username.
+func ResetDemo(s Store) error {
+	if _, err := RemoveDemo(s); err != nil {
+		return err
+	}
+	return SeedDemo(s)
+}
+
+// ScheduleDemoReset resets the demo account every interval until the
+// returned function is called, which waits for a reset in progress.
+// Failures are logged and retried at the next interval.
+func ScheduleDemoReset(s Store, every time.Duration) (stop func()) {
+	quit := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+
+			if err := ResetDemo(s); err != nil {
+				log.Printf("Error resetting the demo account: %s", err)
+				continue
+			}
+			log.Println("Reset the demo account")
+		}
+	}()
+
+	return func() {
+		close(quit)
+		<-done
+	}
+}
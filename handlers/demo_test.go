@@ -0,0 +1,62 @@
+// handlers/demo_test.go
+
+package handlers
+
+import (
+	"testing"
+)
+
+func TestResetDemoOnlyTouchesTheDemoAccount(t *testing.T) {
+	s := NewMemoryStore()
+	if err := SeedDemo(s); err != nil {
+		t.Fatal(err)
+	}
+	demoID, err := s.GetDemoUserID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.InsertPen(demoID, Pen{Name: "Added by a visitor"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Renaming the demo account keeps it the demo account
+	if err := s.UpdateUsername(demoID, "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ResetDemo(s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetUserByID(demoID); err != ErrUserNotFound {
+		t.Errorf("old demo account: err = %v, want ErrUserNotFound", err)
+	}
+
+	newID, err := s.GetDemoUserID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pens, err := loadDemoFixture()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.SelectPens(newID); err != nil || len(got) != len(pens) {
+		t.Errorf("reset demo account has %d pens (%v), want %d", len(got), err, len(pens))
+	}
+}
+
+func TestSeedDemoKeepsAnAccountNamedDemo(t *testing.T) {
+	s := NewMemoryStore()
+	userID, err := s.InsertUser("demo", "Real", "", "Person", "real@example.com", []byte("hash"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SeedDemo(s); err == nil {
+		t.Error("SeedDemo took over an account named demo")
+	}
+	if removed, err := RemoveDemo(s); err != nil || removed {
+		t.Errorf("RemoveDemo = %v, %v, want false, nil", removed, err)
+	}
+	if _, err := s.GetUserByID(userID); err != nil {
+		t.Errorf("the account named demo is gone: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+// handlers/forgot_password.go
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// forgotPasswordSent is shown whether or not the email address is known, so
+// that the page cannot be used to find out who has an account.
+const forgotPasswordSent = "If an account uses that email address, a link to reset its password is on its way."
+
+// ForgotPassword asks for an email address and mails a password reset link
+// to every account registered with it.
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	data := map[string]interface{}{
+		"Error":       r.URL.Query().Get("error"),
+		"Message":     "",
+		"RedirectURL": "",
+	}
+
+	if r.Method == http.MethodPost {
+		email := strings.TrimSpace(r.FormValue("email"))
+		if email == "" {
+			RedirectWithError(w, r, "/forgot", "Please enter your email address")
+			return
+		}
+
+		// Requests are throttled like failed logins, so that the page can't flood an inbox
+		key, ip := strings.ToLower(email), clientAddress(r)
+		unlock := lockThrottle("reset-email:"+key, "reset-ip:"+ip)
+		defer unlock()
+		now := time.Now()
+		wait, err := resetRetryAfter(key, ip, now)
+		if err != nil {
+			requestLogger(r).Error("Error checking password reset requests", "error", err)
+			RedirectWithError(w, r, "/forgot", "Unable to reset your password, please try later")
+			return
+		}
+		if wait > 0 {
+			RedirectWithError(w, r, "/forgot", "Too many reset requests, please try again in "+formatWait(wait))
+			return
+		}
+		if err := resetStore.RecordResetRequest(key, ip, now); err != nil {
+			requestLogger(r).Error("Error recording password reset request", "error", err)
+		}
+
+		users, err := userStore.GetUsersByEmail(email)
+		if err != nil {
+			requestLogger(r).Error("Error looking up users by email", "error", err)
+			RedirectWithError(w, r, "/forgot", "Unable to reset your password, please try later")
+			return
+		}
+
+		// Sending takes a while, which would tell that an account uses the address
+		logger := requestLogger(r)
+		sendInBackground(func() {
+			for _, u := range users {
+				if err := sendPasswordReset(u); err != nil {
+					logger.Error("Error sending password reset", "target_id", u.ID, "error", err)
+				}
+			}
+		})
+
+		data["Message"] = forgotPasswordSent
+	}
+
+	renderTemplate(w, "forgot", data)
+}
+
+// resetRetryAfter returns how long a client must wait before asking for reset
+// links sent to email, with the limits on failed logins. Requests are counted
+// apart from the login attempts.
+func resetRetryAfter(email, ip string, now time.Time) (time.Duration, error) {
+	requests, err := resetStore.ResetRequests(email, ip, now.Add(-loginLimits.LockoutDuration))
+	if err != nil {
+		return 0, err
+	}
+	return loginRetryDelay(loginLimits, requests, now), nil
+}
+
+// newResetLink issues a reset token for the user and returns its link.
+func newResetLink(u User) (string, error) {
+	token, hash, err := newResetToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if err := resetStore.CreatePasswordReset(u.ID, hash, now, now.Add(resetTokenLifetime)); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/reset?token=%s", baseURL, url.QueryEscape(token)), nil
+}
+
+// sendPasswordReset issues a reset token for the user and mails its link.
+func sendPasswordReset(u User) error {
+	link, err := newResetLink(u)
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(Message{
+		To:      u.Email,
+		Subject: "Reset your Flock password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Someone asked to reset the password of your Flock account %q.\n"+
+			"To choose a new password, open this link within the next hour:\n\n%s\n\n"+
+			"If it wasn't you, ignore this email and your password stays the same.\n",
+			u.FirstName, u.Username, link),
+	})
+}
+
+// ResetPassword sets a new password for the account of a reset link. The
+// link works once and other sessions of the account are logged out.
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+
+	if r.Method == http.MethodPost {
+		resetURL := "/reset?token=" + url.QueryEscape(token)
+
+		password := r.FormValue("password")
+		if password != r.FormValue("confirm") {
+			RedirectWithError(w, r, resetURL, "The passwords don't match")
+			return
+		}
+		if err := validatePassword(password); err != nil {
+			RedirectWithError(w, r, resetURL, err.Error())
+			return
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			RedirectWithError(w, r, resetURL, "Error hashing password")
+			return
+		}
+
+		userID, err := resetStore.UsePasswordReset(hashToken(token), time.Now())
+		if err == ErrResetTokenInvalid {
+			RedirectWithError(w, r, "/forgot", err.Error())
+			return
+		}
+		if err != nil {
+			RedirectWithError(w, r, resetURL, "Unable to reset your password, please try again")
+			return
+		}
+
+		if err := userStore.UpdatePassword(userID, hashedPassword); err != nil {
+			RedirectWithError(w, r, "/forgot", "Unable to reset your password, please ask for a new link")
+			return
+		}
+
+		// Whoever knew the old password is logged out
+		if sessionStore != nil {
+			if err := sessionStore.DeleteUserSessions(userID, ""); err != nil {
+				requestLogger(r).Error("Error logging out sessions", "target_id", userID, "error", err)
+			}
+		}
+
+		RedirectWithError(w, r, "/login", "Your password was reset, please login")
+		return
+	}
+
+	if token == "" {
+		http.Redirect(w, r, "/forgot", http.StatusSeeOther)
+		return
+	}
+
+	// Keep the token out of caches and out of Referer headers
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	renderTemplate(w, "reset", map[string]interface{}{
+		"Token":       token,
+		"Error":       r.URL.Query().Get("error"),
+		"RedirectURL": "",
+	})
+}
@@ -0,0 +1,93 @@
+// handlers/forgot_password_test.go
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// outbox keeps the email sent in tests.
+type outbox struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+func (o *outbox) Send(msg Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.sent = append(o.sent, msg)
+	return nil
+}
+
+// forgotPassword posts the email address to ForgotPassword from the client
+// address, and returns where it redirects, or "sent" when the page is shown.
+// It waits for the email sent in the background.
+func forgotPassword(email, ip string) string {
+	r := httptest.NewRequest(http.MethodPost, "/forgot", strings.NewReader(url.Values{"email": {email}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = ip + ":1234"
+
+	w := httptest.NewRecorder()
+	ForgotPassword(w, r)
+	WaitForMail()
+	if w.Code == http.StatusOK {
+		return "sent"
+	}
+	return w.Header().Get("Location")
+}
+
+func TestForgotPasswordIsThrottled(t *testing.T) {
+	c := newTestClient(t)
+	box := &outbox{}
+	defer func(m Mailer, url string) { InitMailer(m, url) }(mailer, baseURL)
+	InitMailer(box, "http://flock.test")
+
+	for i := 0; i <= loginLimits.FreeAttempts; i++ {
+		if got := forgotPassword("Tester@example.com", "192.0.2.1"); got != "sent" {
+			t.Fatalf("request %d: %s, want the page", i+1, got)
+		}
+	}
+	if len(box.sent) != loginLimits.FreeAttempts+1 {
+		t.Errorf("%d email sent, want %d", len(box.sent), loginLimits.FreeAttempts+1)
+	}
+
+	// The address is throttled whatever its case and wherever the request comes from
+	for _, email := range []string{"tester@example.com", "TESTER@example.com"} {
+		if got := forgotPassword(email, "198.51.100.1"); !strings.HasPrefix(got, "/forgot?error=Too+many+reset+requests") {
+			t.Errorf("%s: %s, want too many requests", email, got)
+		}
+	}
+	if len(box.sent) != loginLimits.FreeAttempts+1 {
+		t.Errorf("%d email sent once throttled, want %d", len(box.sent), loginLimits.FreeAttempts+1)
+	}
+
+	if got := forgotPassword("other@example.com", "198.51.100.1"); got != "sent" {
+		t.Errorf("another address: %s, want the page", got)
+	}
+
+	// Reset requests and logins are counted apart, from the same address too
+	wait, _ := loginRetryAfter(c.user.Username, "192.0.2.1", time.Now())
+	if wait != 0 {
+		t.Errorf("login wait = %v, want 0", wait)
+	}
+}
+
+func TestFailedLoginsDontThrottleResets(t *testing.T) {
+	newTestClient(t)
+	r := httptest.NewRequest(http.MethodPost, "/login", nil)
+	r.RemoteAddr = "198.51.100.7:1234"
+	for i := 0; i < loginLimits.LockoutAfter; i++ {
+		recordLogin(r, "tester@example.com", 0, false)
+		recordLogin(r, " reset tester@example.com", 0, false)
+	}
+
+	if got := forgotPassword("tester@example.com", "192.0.2.1"); got != "sent" {
+		t.Errorf("%s, want the page", got)
+	}
+}
@@ -3,22 +3,35 @@
 package handlers
 
 import (
+	"fmt"
+	"html/template"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"path/filepath"
 	"strings"
-	"html/template"
-	"fmt"
-	// "path/filepath"
-	// "log"
 )
 
 var templates = make(map[string]*template.Template)
 
+// templateDir is the directory the HTML templates are read from.
+var templateDir = "templates"
+
+// SetTemplateDir sets the directory the HTML templates are read from.
+func SetTemplateDir(dir string) {
+	templateDir = dir
+}
+
+// templatePath returns the path of the template file with the given name.
+func templatePath(name string) string {
+	return filepath.Join(templateDir, name)
+}
+
 // Add function adds a number to the input
 func Add(input, numberToAdd int) int {
 	return input + numberToAdd
 }
 
-
 // Title capitalizes text and replaces underscores with space.
 func Title(text string) string {
 	text = strings.ReplaceAll(text, "_", " ")
@@ -46,11 +59,11 @@ func GetTemplate(name string) (*template.Template, bool) {
 // renderTemplate renders an HTML template.
 func renderTemplate(w http.ResponseWriter, templateName string, data interface{}) {
 
-	// Log the data
-	// log.Printf("Rendering template %s with data: %+v", templateName, data)
+	// Log the template, with -log-level debug
+	slog.Debug("Rendering template", "template", templateName)
 
 	// Parse the template files
-	tmplFiles := fmt.Sprintf("templates/%s.html", templateName)
+	tmplFiles := templatePath(templateName + ".html")
 	tmpl, err := template.ParseFiles(tmplFiles)
 	if err != nil {
 		http.Error(w, "Error parsing template file: "+err.Error(), http.StatusInternalServerError)
@@ -67,7 +80,11 @@ func renderTemplate(w http.ResponseWriter, templateName string, data interface{}
 
 // RedirectWithError redirects to the specified URL with an error message.
 func RedirectWithError(w http.ResponseWriter, r *http.Request, targetURL, errorMessage string) {
-	redirectURL := fmt.Sprintf("%s?error=%s", targetURL, errorMessage)
-	// log.Printf("errorMessage is %s", errorMessage)
+	separator := "?"
+	if strings.Contains(targetURL, "?") {
+		separator = "&"
+	}
+	redirectURL := fmt.Sprintf("%s%serror=%s", targetURL, separator, url.QueryEscape(errorMessage))
+	requestLogger(r).Debug("Redirecting with an error", "url", targetURL, "error", errorMessage)
 	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
 }
@@ -4,24 +4,25 @@ package handlers
 import (
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
 	"html/template"
+	"net/http"
 	"time"
 )
 
 // ExportCSV exports the data from the "pens" table in CSV format.
-// It retrieves the data using SelectPens, generates a CSV file with the data,
+// It retrieves the data using the pen store, generates a CSV file with the data,
 // and sends the file as a response with proper headers.
 func ExportCSV(w http.ResponseWriter, r *http.Request) {
 	// Get the user ID from the session (you need to implement this part)
-	userID := GetUserIDFromSession(r)
-	if userID == 0 {
-		RedirectWithError(w, r, "/login", "Please login to export your data")
+	userID := currentUserID(r)
+
+	if !requireVerifiedEmail(w, r, userID) {
 		return
 	}
 
-	pens, columns, err := SelectPens(userID) // Pass the userID parameter here
+	pens, err := penStore.SelectPens(userID)
 	if err != nil {
 		RedirectWithError(w, r, "/dashboard", "Some issue with getting your pens, ply try later")
 		return
@@ -38,18 +39,14 @@ func ExportCSV(w http.ResponseWriter, r *http.Request) {
 	defer csvWriter.Flush()
 
 	// Write CSV header
-	if err := csvWriter.Write(columns); err != nil {
+	if err := csvWriter.Write(PenCSVHeader()); err != nil {
 		RedirectWithError(w, r, "/dashboard", "Unable to create a CSV, please try again later")
 		return
 	}
 
 	// Write CSV rows
 	for _, pen := range pens {
-		row := make([]string, len(columns))
-		for i, col := range columns {
-			row[i] = fmt.Sprintf("%v", pen[col])
-		}
-		if err := csvWriter.Write(row); err != nil {
+		if err := csvWriter.Write(pen.Record()); err != nil {
 			RedirectWithError(w, r, "/dashboard", "Unable to create a CSV, please try again later")
 			return
 		}
@@ -67,109 +64,157 @@ func ExportCSV(w http.ResponseWriter, r *http.Request) {
 // It supports both GET and POST requests. For GET requests, it renders the import form.
 // For POST requests, it processes the uploaded CSV file, extracts data, and renders a preview.
 func ImportCSV(w http.ResponseWriter, r *http.Request) {
-	userID := GetUserIDFromSession(r)
-	if userID == 0 {
-		RedirectWithError(w, r, "/login", "Please login to attempt to import pens")
+	userID := currentUserID(r)
+
+	if !requireVerifiedEmail(w, r, userID) {
 		return
 	}
 
 	if r.Method == http.MethodPost {
-		r.ParseMultipartForm(10 << 20) // Max memory usage for uploaded files
-
-		file, _, err := r.FormFile("csvfile")
-		if err != nil {
-			RedirectWithError(w, r, "/dashboard", "Unable to parse CSV file, please check format")
-			return
-		}
-		defer file.Close()
-
-		reader := csv.NewReader(file)
-		rows, err := reader.ReadAll()
+		columns, rows, err := readCSVUpload(r)
 		if err != nil {
-			RedirectWithError(w, r, "/dashboard", "Unable to identify columns, please check format")
+			RedirectWithError(w, r, "/import/csv", err.Error())
 			return
 		}
 
-		columns := rows[0] // Assume the first row contains column headers
-		rows = rows[1:]    // Exclude the header row
-
-		data := struct {
-			Columns []string
-			Rows    [][]string
-		}{
-			Columns: columns,
-			Rows:    rows,
-		}
-
-		csvDataJSON, err := json.Marshal(data.Rows)
-		if err != nil {
-			RedirectWithError(w, r, "/dashboard", "Please check the data in your CSV and format it as needed for this application")
+		// Check every row up front so that problems show up before the preview
+		if _, err := pensFromRecords(columns, rows); err != nil {
+			RedirectWithError(w, r, "/import/csv", err.Error())
 			return
 		}
 
-		columnsJSON, err := json.Marshal(data.Columns)
-		if err != nil {
-			RedirectWithError(w, r, "/dashboard", "Some data rows seem to be corrupt")
-			return
-		}
-
-		tmpl := template.Must(template.ParseFiles("templates/import_preview.html"))
-		tmpl.Execute(w, struct {
-			CsvData template.JS
-			Columns template.JS
-		}{
-			CsvData: template.JS(csvDataJSON),
-			Columns: template.JS(columnsJSON),
-		})
+		renderImportPreview(w, r, columns, rows, "/import/csv", "/import/approve")
 		return
 	}
 
-	tmpl := template.Must(template.ParseFiles("templates/import.html"))
-	tmpl.Execute(w, nil)
+	tmpl := template.Must(template.ParseFiles(templatePath("import.html")))
+	tmpl.Execute(w, map[string]interface{}{
+		"Error": r.URL.Query().Get("error"),
+	})
 }
 
 // ImportApprove handles the approval of imported data.
 // It processes the approved data and inserts it into the database.
 // This function is called after the user reviews the imported data and confirms the import.
 func ImportApprove(w http.ResponseWriter, r *http.Request) {
-	userID := GetUserIDFromSession(r)
-	if userID == 0 {
-		RedirectWithError(w, r, "/login", "Please login to approve the import request")
+	userID := currentUserID(r)
+
+	if !requireVerifiedEmail(w, r, userID) {
 		return
 	}
 
 	if r.Method == http.MethodPost {
-		csvData := r.FormValue("csvData")
-		var rows [][]string
-		if err := json.Unmarshal([]byte(csvData), &rows); err != nil {
-			RedirectWithError(w, r, "/dashboard", "There seems to be an issue with the rows")
+		columns, rows, err := readImportApproval(r)
+		if err != nil {
+			RedirectWithError(w, r, "/dashboard", err.Error())
 			return
 		}
 
-		tx, err := db.Begin()
+		// Match values to pen fields by the header, the id column is ignored
+		pens, err := pensFromRecords(columns, rows)
 		if err != nil {
-			RedirectWithError(w, r, "/dashboard", "Unable to get to your database, please try later")
+			RedirectWithError(w, r, "/dashboard", err.Error())
 			return
 		}
 
-		for _, row := range rows {
-			// Start from index 1 to exclude the id column
-			if err := InsertPen(userID, row[1:]); err != nil {
-				tx.Rollback()
-				errorMessage := fmt.Sprintf("Unable to add pen: %v. Error: %v", row, err)
-				RedirectWithError(w, r, "/dashboard", errorMessage)
-				return
-			}
+		imported := make([]Activity, len(pens))
+		for i, pen := range pens {
+			imported[i] = newActivity(r, ActivityImport, ItemPen, 0, pen.Name, nil, penValues(pen))
 		}
 
-
-		err = tx.Commit()
+		// Insert all pens at once so that a bad row doesn't leave a partial import
+		ids, err := penStore.InsertPens(userID, pens, imported...)
 		if err != nil {
-			RedirectWithError(w, r, "/dashboard", "Unable to commit changes to your database")
+			errorMessage := fmt.Sprintf("Unable to add pens. Error: %v", err)
+			RedirectWithError(w, r, "/dashboard", errorMessage)
 			return
 		}
 
+		rememberUndo(w, r, undoImport, ids, fmt.Sprintf("Imported %d %s.", len(ids), plural(len(ids), ItemPen)))
+
 		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
 		return
 	}
 }
+
+// pensFromRecords converts CSV records to pens using the header row,
+// reporting the first invalid row by its line number in the file.
+func pensFromRecords(header []string, records [][]string) ([]Pen, error) {
+	pens := make([]Pen, 0, len(records))
+	for i, record := range records {
+		pen, err := PenFromRecord(header, record)
+		if err != nil {
+			return nil, fmt.Errorf("Row %d: %v", i+2, err)
+		}
+		pens = append(pens, pen)
+	}
+	return pens, nil
+}
+
+// readCSVUpload reads the uploaded "csvfile" and splits it into the header row and the data rows.
+func readCSVUpload(r *http.Request) ([]string, [][]string, error) {
+	r.ParseMultipartForm(10 << 20) // Max memory usage for uploaded files
+
+	file, _, err := r.FormFile("csvfile")
+	if err != nil {
+		return nil, nil, errors.New("Unable to parse CSV file, please check format")
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, errors.New("Unable to identify columns, please check format")
+	}
+
+	if len(rows) == 0 {
+		return nil, nil, errors.New("The CSV file is empty")
+	}
+
+	// The first row contains column headers
+	return rows[0], rows[1:], nil
+}
+
+// renderImportPreview shows the rows of an uploaded CSV file for approval.
+// The approval form posts the rows and columns as JSON to approveURL.
+func renderImportPreview(w http.ResponseWriter, r *http.Request, columns []string, rows [][]string, backURL, approveURL string) {
+	csvDataJSON, err := json.Marshal(rows)
+	if err != nil {
+		RedirectWithError(w, r, backURL, "Please check the data in your CSV and format it as needed for this application")
+		return
+	}
+
+	columnsJSON, err := json.Marshal(columns)
+	if err != nil {
+		RedirectWithError(w, r, backURL, "Some data rows seem to be corrupt")
+		return
+	}
+
+	tmpl := template.Must(template.ParseFiles(templatePath("import_preview.html")))
+	tmpl.Execute(w, struct {
+		CsvData    template.JS
+		Columns    template.JS
+		BackURL    string
+		ApproveURL string
+	}{
+		CsvData:    template.JS(csvDataJSON),
+		Columns:    template.JS(columnsJSON),
+		BackURL:    backURL,
+		ApproveURL: approveURL,
+	})
+}
+
+// readImportApproval decodes the rows and columns posted by the import preview.
+func readImportApproval(r *http.Request) ([]string, [][]string, error) {
+	var rows [][]string
+	if err := json.Unmarshal([]byte(r.FormValue("csvData")), &rows); err != nil {
+		return nil, nil, errors.New("There seems to be an issue with the rows")
+	}
+
+	var columns []string
+	if err := json.Unmarshal([]byte(r.FormValue("columns")), &columns); err != nil {
+		return nil, nil, errors.New("There seems to be an issue with the columns")
+	}
+
+	return columns, rows, nil
+}
@@ -0,0 +1,141 @@
+// handlers/import_export_inks.go
+
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+// ExportInksCSV exports the user's inks in CSV format.
+func ExportInksCSV(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	if !requireVerifiedEmail(w, r, userID) {
+		return
+	}
+
+	inks, err := inkStore.SelectInks(userID)
+	if err != nil {
+		RedirectWithError(w, r, "/inks", "Some issue with getting your inks, ply try later")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+
+	// Generate the filename based on the current date
+	timestamp := time.Now().Format("20060102-150405")
+	filename := fmt.Sprintf("flock_inks_%s_backup.csv", timestamp)
+	w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+
+	csvWriter := csv.NewWriter(w)
+	defer csvWriter.Flush()
+
+	// Write CSV header
+	if err := csvWriter.Write(InkCSVHeader()); err != nil {
+		RedirectWithError(w, r, "/inks", "Unable to create a CSV, please try again later")
+		return
+	}
+
+	// Write CSV rows
+	for _, ink := range inks {
+		if err := csvWriter.Write(ink.Record()); err != nil {
+			RedirectWithError(w, r, "/inks", "Unable to create a CSV, please try again later")
+			return
+		}
+	}
+
+	csvWriter.Flush()
+
+	if err := csvWriter.Error(); err != nil {
+		RedirectWithError(w, r, "/inks", "Unable to create a CSV, please try again later")
+		return
+	}
+}
+
+// ImportInksCSV handles the import of inks from a CSV file.
+// For GET requests, it renders the import form. For POST requests, it processes
+// the uploaded CSV file and renders a preview.
+func ImportInksCSV(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	if !requireVerifiedEmail(w, r, userID) {
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		columns, rows, err := readCSVUpload(r)
+		if err != nil {
+			RedirectWithError(w, r, "/inks/import/csv", err.Error())
+			return
+		}
+
+		// Check every row up front so that problems show up before the preview
+		if _, err := inksFromRecords(columns, rows); err != nil {
+			RedirectWithError(w, r, "/inks/import/csv", err.Error())
+			return
+		}
+
+		renderImportPreview(w, r, columns, rows, "/inks/import/csv", "/inks/import/approve")
+		return
+	}
+
+	tmpl := template.Must(template.ParseFiles(templatePath("import_inks.html")))
+	tmpl.Execute(w, map[string]interface{}{
+		"Error": r.URL.Query().Get("error"),
+	})
+}
+
+// ImportInksApprove inserts the inks approved on the import preview.
+func ImportInksApprove(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	if !requireVerifiedEmail(w, r, userID) {
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		columns, rows, err := readImportApproval(r)
+		if err != nil {
+			RedirectWithError(w, r, "/inks", err.Error())
+			return
+		}
+
+		// Match values to ink fields by the header, the id column is ignored
+		inks, err := inksFromRecords(columns, rows)
+		if err != nil {
+			RedirectWithError(w, r, "/inks", err.Error())
+			return
+		}
+
+		imported := make([]Activity, len(inks))
+		for i, ink := range inks {
+			imported[i] = newActivity(r, ActivityImport, ItemInk, 0, ink.DisplayName(), nil, inkValues(ink))
+		}
+
+		// Insert all inks at once so that a bad row doesn't leave a partial import
+		if err := inkStore.InsertInks(userID, inks, imported...); err != nil {
+			RedirectWithError(w, r, "/inks", fmt.Sprintf("Unable to add inks. Error: %v", err))
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/inks", http.StatusSeeOther)
+}
+
+// inksFromRecords converts CSV records to inks using the header row,
+// reporting the first invalid row by its line number in the file.
+func inksFromRecords(header []string, records [][]string) ([]Ink, error) {
+	inks := make([]Ink, 0, len(records))
+	for i, record := range records {
+		ink, err := InkFromRecord(header, record)
+		if err != nil {
+			return nil, fmt.Errorf("Row %d: %v", i+2, err)
+		}
+		inks = append(inks, ink)
+	}
+	return inks, nil
+}
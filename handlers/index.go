@@ -35,6 +35,12 @@ func Index(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Render the index page for non-authenticated users
-	renderTemplate(w, "index", nil)
+	data := struct {
+		RegistrationOpen bool
+		Demo             bool
+		Error            string
+		RedirectURL      string
+	}{RegistrationOpen: registrationOpen, Demo: demoSeeded, Error: r.URL.Query().Get("error")}
+	renderTemplate(w, "index", data)
 
 }
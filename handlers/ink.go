@@ -0,0 +1,257 @@
+// handlers/ink.go
+
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Ink is a bottle of ink in a user's collection.
+type Ink struct {
+	ID              int64
+	Brand           string
+	Line            string
+	ColorName       string
+	BottleSize      float64 // millilitres
+	VolumeRemaining float64 // millilitres
+	Shimmer         bool
+	Sheen           bool
+	Waterproof      bool
+	Pigment         bool
+	Purchased       PenDate
+	Price           Money
+	Notes           string
+}
+
+// InkColumns lists the editable ink columns in the order used by forms and CSV files.
+var InkColumns = []string{"brand", "line", "color_name", "bottle_size", "volume_remaining", "shimmer", "sheen", "waterproof", "pigment", "purchased", "price", "currency", "notes"}
+
+// inkSelectColumns is the column list scanned by scanInk.
+const inkSelectColumns = "id, brand, line, color_name, bottle_size, volume_remaining, shimmer, sheen, waterproof, pigment, purchased, price, currency, notes"
+
+// DisplayName returns the brand, line and color of the ink as one name.
+func (i Ink) DisplayName() string {
+	var parts []string
+	for _, part := range []string{i.Brand, i.Line, i.ColorName} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Properties lists the special properties of the ink, such as shimmer or sheen.
+func (i Ink) Properties() []string {
+	var properties []string
+	if i.Shimmer {
+		properties = append(properties, "Shimmer")
+	}
+	if i.Sheen {
+		properties = append(properties, "Sheen")
+	}
+	if i.Waterproof {
+		properties = append(properties, "Waterproof")
+	}
+	if i.Pigment {
+		properties = append(properties, "Pigment")
+	}
+	return properties
+}
+
+// formatVolume formats millilitres without trailing zeros.
+func formatVolume(ml float64) string {
+	if ml == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(ml, 'f', -1, 64)
+}
+
+// formatBool formats a property for CSV files.
+func formatBool(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// parseBool accepts the usual spellings of yes and no, and treats empty as no.
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "0", "n", "no", "false", "off":
+		return false, nil
+	case "1", "y", "yes", "true", "on", "x":
+		return true, nil
+	}
+	return false, fmt.Errorf("%q is not yes or no", s)
+}
+
+// parseVolume parses millilitres, treating empty as unknown.
+func parseVolume(s string) (float64, error) {
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "ml"))
+	if s == "" {
+		return 0, nil
+	}
+	ml, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a volume in ml", s)
+	}
+	return ml, nil
+}
+
+// Field returns the value of an ink column formatted as text, for templates and CSV export.
+func (i Ink) Field(column string) string {
+	switch column {
+	case "id":
+		return strconv.FormatInt(i.ID, 10)
+	case "brand":
+		return i.Brand
+	case "line":
+		return i.Line
+	case "color_name":
+		return i.ColorName
+	case "bottle_size":
+		return formatVolume(i.BottleSize)
+	case "volume_remaining":
+		return formatVolume(i.VolumeRemaining)
+	case "shimmer":
+		return formatBool(i.Shimmer)
+	case "sheen":
+		return formatBool(i.Sheen)
+	case "waterproof":
+		return formatBool(i.Waterproof)
+	case "pigment":
+		return formatBool(i.Pigment)
+	case "purchased":
+		return i.Purchased.String()
+	case "price":
+		return i.Price.AmountString()
+	case "currency":
+		return i.Price.Currency
+	case "notes":
+		return i.Notes
+	}
+	return ""
+}
+
+// setField parses text into the ink column of the same name.
+func (i *Ink) setField(column, value string) error {
+	value = strings.TrimSpace(value)
+
+	var err error
+	switch column {
+	case "id":
+		// Identifiers are assigned by the store
+	case "brand":
+		i.Brand = value
+	case "line":
+		i.Line = value
+	case "color_name":
+		i.ColorName = value
+	case "bottle_size":
+		i.BottleSize, err = parseVolume(value)
+	case "volume_remaining":
+		i.VolumeRemaining, err = parseVolume(value)
+	case "shimmer":
+		i.Shimmer, err = parseBool(value)
+	case "sheen":
+		i.Sheen, err = parseBool(value)
+	case "waterproof":
+		i.Waterproof, err = parseBool(value)
+	case "pigment":
+		i.Pigment, err = parseBool(value)
+	case "purchased":
+		i.Purchased, err = ParsePenDate(value)
+	case "price":
+		i.Price.Amount, err = parseMinorUnits(value)
+	case "currency":
+		i.Price.Currency = strings.ToUpper(value)
+	case "notes":
+		i.Notes = value
+	default:
+		err = fmt.Errorf("unknown ink column %q", column)
+	}
+	return err
+}
+
+// Validate checks that the ink can be stored.
+func (i Ink) Validate() error {
+	if i.Brand == "" && i.ColorName == "" {
+		return errors.New("an ink needs a brand or a color name")
+	}
+	if i.BottleSize < 0 || i.VolumeRemaining < 0 {
+		return errors.New("volumes cannot be negative")
+	}
+	if i.BottleSize > 0 && i.VolumeRemaining > i.BottleSize {
+		return errors.New("volume remaining cannot be more than the bottle size")
+	}
+	if err := i.Purchased.Validate(); err != nil {
+		return err
+	}
+	return i.Price.Validate()
+}
+
+// args returns the values of InkColumns for INSERT and UPDATE statements.
+func (i Ink) args() []interface{} {
+	return []interface{}{i.Brand, i.Line, i.ColorName, i.BottleSize, i.VolumeRemaining, i.Shimmer, i.Sheen, i.Waterproof, i.Pigment, i.Purchased, i.Price, i.Price.Currency, i.Notes}
+}
+
+// scanInk scans a row selected with inkSelectColumns.
+func scanInk(row rowScanner) (Ink, error) {
+	var i Ink
+	var brand, line, colorName, currency, notes nullString
+	err := row.Scan(&i.ID, &brand, &line, &colorName, &i.BottleSize, &i.VolumeRemaining,
+		&i.Shimmer, &i.Sheen, &i.Waterproof, &i.Pigment, &i.Purchased, &i.Price, &currency, &notes)
+	if err != nil {
+		return Ink{}, err
+	}
+
+	i.Brand, i.Line, i.ColorName = string(brand), string(line), string(colorName)
+	i.Price.Currency, i.Notes = string(currency), string(notes)
+	return i, nil
+}
+
+// InkFromForm builds an ink from the add and modify forms.
+func InkFromForm(r *http.Request) (Ink, error) {
+	var i Ink
+	for _, col := range InkColumns {
+		if err := i.setField(col, r.FormValue(col)); err != nil {
+			return Ink{}, fmt.Errorf("%s: %w", Title(col), err)
+		}
+	}
+	return i, i.Validate()
+}
+
+// InkFromRecord builds an ink from a CSV record, matching values to columns by the header.
+func InkFromRecord(header, record []string) (Ink, error) {
+	if len(header) != len(record) {
+		return Ink{}, fmt.Errorf("expected %d values, found %d", len(header), len(record))
+	}
+
+	var i Ink
+	for n, col := range header {
+		col = strings.ToLower(strings.TrimSpace(col))
+		if err := i.setField(col, record[n]); err != nil {
+			return Ink{}, fmt.Errorf("%s: %w", Title(col), err)
+		}
+	}
+	return i, i.Validate()
+}
+
+// InkCSVHeader is the header row written by the ink CSV export.
+func InkCSVHeader() []string {
+	return append([]string{"id"}, InkColumns...)
+}
+
+// Record returns the ink as a CSV record matching InkCSVHeader.
+func (i Ink) Record() []string {
+	header := InkCSVHeader()
+	record := make([]string, len(header))
+	for n, col := range header {
+		record[n] = i.Field(col)
+	}
+	return record
+}
@@ -0,0 +1,135 @@
+// handlers/ink_database.go
+
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// inkInsertQuery is the INSERT statement for the inks table.
+var inkInsertQuery = fmt.Sprintf("INSERT INTO inks (%s) VALUES (?%s)",
+	strings.Join(InkColumns, ", "), strings.Repeat(", ?", len(InkColumns)-1))
+
+// inkUpdateQuery is the UPDATE statement for the inks table.
+var inkUpdateQuery = fmt.Sprintf("UPDATE inks SET %s = ? WHERE id = ?", strings.Join(InkColumns, " = ?, "))
+
+// SelectInks fetches all inks from the user's database.
+func (s *SQLiteStore) SelectInks(userID int64) ([]Ink, error) {
+	userDB, err := s.openUserDB(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := userDB.Query("SELECT " + inkSelectColumns + " FROM inks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var inks []Ink
+	for rows.Next() {
+		ink, err := scanInk(rows)
+		if err != nil {
+			return nil, err
+		}
+		inks = append(inks, ink)
+	}
+
+	return inks, rows.Err()
+}
+
+// GetInkByID retrieves an ink by its ID for a specific user.
+func (s *SQLiteStore) GetInkByID(userID, inkID int64) (Ink, error) {
+	userDB, err := s.openUserDB(userID)
+	if err != nil {
+		return Ink{}, err
+	}
+
+	ink, err := scanInk(userDB.QueryRow("SELECT "+inkSelectColumns+" FROM inks WHERE id = ?", inkID))
+	if err == sql.ErrNoRows {
+		return Ink{}, ErrInkNotFound
+	}
+	return ink, err
+}
+
+// InsertInk inserts a new ink record into the database.
+func (s *SQLiteStore) InsertInk(userID int64, ink Ink, activities ...Activity) (int64, error) {
+	if err := ink.Validate(); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := s.changeWithActivity(userID, activities, func(tx *sql.Tx) error {
+		result, err := tx.Exec(inkInsertQuery, ink.args()...)
+		if err != nil {
+			return err
+		}
+		id, err = result.LastInsertId()
+		setActivityItemID(activities, id)
+		return err
+	})
+	return id, err
+}
+
+// InsertInks inserts several ink records in a single transaction.
+func (s *SQLiteStore) InsertInks(userID int64, inks []Ink, activities ...Activity) error {
+	// Check every ink before touching the database
+	for _, ink := range inks {
+		if err := ink.Validate(); err != nil {
+			return fmt.Errorf("ink %q: %w", ink.DisplayName(), err)
+		}
+	}
+
+	return s.changeWithActivity(userID, activities, func(tx *sql.Tx) error {
+		for i, ink := range inks {
+			result, err := tx.Exec(inkInsertQuery, ink.args()...)
+			if err != nil {
+				return fmt.Errorf("ink %q: %w", ink.DisplayName(), err)
+			}
+			id, err := result.LastInsertId()
+			if err != nil {
+				return err
+			}
+			if i < len(activities) {
+				activities[i].ItemID = id
+			}
+		}
+		return nil
+	})
+}
+
+// UpdateInk updates an ink record in the database.
+func (s *SQLiteStore) UpdateInk(userID int64, ink Ink, activities ...Activity) error {
+	if err := ink.Validate(); err != nil {
+		return err
+	}
+
+	return s.changeWithActivity(userID, activities, func(tx *sql.Tx) error {
+		result, err := tx.Exec(inkUpdateQuery, append(ink.args(), ink.ID)...)
+		if err != nil {
+			return err
+		}
+
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return ErrInkNotFound
+		}
+		return nil
+	})
+}
+
+// DeleteInkByID deletes an ink from the database by its ID.
+func (s *SQLiteStore) DeleteInkByID(userID, inkID int64, activities ...Activity) error {
+	return s.changeWithActivity(userID, activities, func(tx *sql.Tx) error {
+		result, err := tx.Exec("DELETE FROM inks WHERE id = ?", inkID)
+		if err != nil {
+			return err
+		}
+
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return ErrInkNotFound
+		}
+		return nil
+	})
+}
@@ -0,0 +1,102 @@
+// handlers/ink_up.go
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InkUp starts an inking session for a pen with the ink chosen on the modify page.
+func InkUp(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	// Get the pen ID from the URL parameter
+	penID, err := pathID(r)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Invalid pen ID")
+		return
+	}
+	penURL := fmt.Sprintf("/modify/%d", penID)
+
+	inkID, err := strconv.ParseInt(r.FormValue("ink_id"), 10, 64)
+	if err != nil {
+		RedirectWithError(w, r, penURL, "Please choose an ink")
+		return
+	}
+
+	inkedAt, err := parseFormDate(r.FormValue("inked_at"), time.Now())
+	if err != nil {
+		RedirectWithError(w, r, penURL, err.Error())
+		return
+	}
+
+	notes := strings.TrimSpace(r.FormValue("notes"))
+	ink, _ := inkStore.GetInkByID(userID, inkID)
+	inking := Inking{PenName: penName(userID, penID), InkName: ink.DisplayName(), InkedAt: inkedAt, Notes: notes}
+
+	_, err = inkingStore.StartInking(userID, penID, inkID, inkedAt, notes, newActivity(r, ActivityInsert, ItemInking, 0, inkingName(inking), nil, inkingValues(inking)))
+	switch err {
+	case nil:
+	case ErrPenAlreadyInked, ErrPenNotFound, ErrInkNotFound:
+		RedirectWithError(w, r, penURL, err.Error())
+		return
+	default:
+		RedirectWithError(w, r, penURL, "Unable to ink up your pen, please try again")
+		return
+	}
+
+	http.Redirect(w, r, penURL, http.StatusSeeOther)
+}
+
+// CleanPen ends the current inking session of a pen.
+func CleanPen(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	// Get the pen ID from the URL parameter
+	penID, err := pathID(r)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Invalid pen ID")
+		return
+	}
+	penURL := fmt.Sprintf("/modify/%d", penID)
+
+	cleanedAt, err := parseFormDate(r.FormValue("cleaned_at"), time.Now())
+	if err != nil {
+		RedirectWithError(w, r, penURL, err.Error())
+		return
+	}
+
+	// Keep the inking being ended for the activity
+	history, err := inkingStore.InkingHistory(userID, penID)
+	if err != nil {
+		RedirectWithError(w, r, penURL, "Unable to clean your pen, please try again")
+		return
+	}
+
+	var activities []Activity
+	if len(history) > 0 {
+		before, after := history[0], history[0]
+		after.CleanedAt = cleanedAt
+		activities = append(activities, newActivity(r, ActivityUpdate, ItemInking, before.ID, inkingName(before), inkingValues(before), inkingValues(after)))
+	}
+
+	err = inkingStore.EndInking(userID, penID, cleanedAt, activities...)
+	if err == ErrPenNotInked || err == ErrCleanedBeforeInked {
+		RedirectWithError(w, r, penURL, err.Error())
+		return
+	}
+	if err != nil {
+		RedirectWithError(w, r, penURL, "Unable to clean your pen, please try again")
+		return
+	}
+	// Go back to where the pen was cleaned from, the dashboard or the pen's page
+	if r.FormValue("from") == "dashboard" {
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, penURL, http.StatusSeeOther)
+}
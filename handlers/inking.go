@@ -0,0 +1,83 @@
+// handlers/inking.go
+
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrPenAlreadyInked is returned when inking a pen that still holds ink.
+var ErrPenAlreadyInked = errors.New("pen is already inked, clean it first")
+
+// ErrPenNotInked is returned when cleaning a pen that holds no ink.
+var ErrPenNotInked = errors.New("pen is not inked")
+
+// ErrCleanedBeforeInked is returned when the cleaning date is before the inking date.
+var ErrCleanedBeforeInked = errors.New("a pen cannot be cleaned before it was inked")
+
+// Inking is a period during which a pen was filled with an ink.
+type Inking struct {
+	ID        int64
+	PenID     int64
+	PenName   string
+	InkID     int64  // zero once the ink has been deleted
+	InkName   string // kept so that history survives deleting the ink
+	InkedAt   time.Time
+	CleanedAt time.Time // zero while the pen is still inked
+	Notes     string
+}
+
+// Current reports whether the pen is still inked.
+func (i Inking) Current() bool {
+	return i.CleanedAt.IsZero()
+}
+
+// Duration is how long the pen was inked, or has been inked so far.
+func (i Inking) Duration() time.Duration {
+	end := i.CleanedAt
+	if i.Current() {
+		end = time.Now()
+	}
+	return end.Sub(i.InkedAt)
+}
+
+// Days is the number of whole days the pen was inked.
+func (i Inking) Days() int {
+	return int(i.Duration().Hours() / 24)
+}
+
+// DurationString formats the duration in days for templates.
+func (i Inking) DurationString() string {
+	switch days := i.Days(); days {
+	case 0:
+		return "less than a day"
+	case 1:
+		return "1 day"
+	default:
+		return fmt.Sprintf("%d days", days)
+	}
+}
+
+// parseFormDate parses a date from a form, using now when it is empty.
+// Dates without a time are taken as the current time of that day.
+func parseFormDate(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return now, nil
+	}
+
+	day, err := time.ParseInLocation("2006-01-02", value, now.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a date like 2006-01-02", value)
+	}
+	if day.After(now) {
+		return time.Time{}, fmt.Errorf("%s is in the future", value)
+	}
+
+	// Keep the time of day so that sessions started today sort correctly
+	if day.Year() == now.Year() && day.YearDay() == now.YearDay() {
+		return now, nil
+	}
+	return day.Add(12 * time.Hour), nil
+}
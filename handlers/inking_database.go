@@ -0,0 +1,119 @@
+// handlers/inking_database.go
+
+package handlers
+
+import (
+	"database/sql"
+	"time"
+)
+
+// inkingSelect selects inkings joined with the pen name, scanned by scanInking.
+const inkingSelect = `SELECT inkings.id, inkings.pen_id, COALESCE(pens.name, ''), COALESCE(inkings.ink_id, 0),
+	inkings.ink_name, inkings.inked_at, inkings.cleaned_at, COALESCE(inkings.notes, '')
+	FROM inkings JOIN pens ON pens.id = inkings.pen_id`
+
+// scanInking scans a row selected with inkingSelect.
+func scanInking(row rowScanner) (Inking, error) {
+	var i Inking
+	var cleanedAt sql.NullTime
+	err := row.Scan(&i.ID, &i.PenID, &i.PenName, &i.InkID, &i.InkName, &i.InkedAt, &cleanedAt, &i.Notes)
+	if err != nil {
+		return Inking{}, err
+	}
+	i.CleanedAt = cleanedAt.Time
+	return i, nil
+}
+
+// queryInkings runs an inking query and scans every row.
+func queryInkings(userDB *sql.DB, query string, args ...interface{}) ([]Inking, error) {
+	rows, err := userDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var inkings []Inking
+	for rows.Next() {
+		inking, err := scanInking(rows)
+		if err != nil {
+			return nil, err
+		}
+		inkings = append(inkings, inking)
+	}
+	return inkings, rows.Err()
+}
+
+// StartInking records that a pen has been filled with an ink.
+func (s *SQLiteStore) StartInking(userID, penID, inkID int64, at time.Time, notes string, activities ...Activity) (int64, error) {
+	if !s.PenExists(userID, penID) {
+		return 0, ErrPenNotFound
+	}
+
+	ink, err := s.GetInkByID(userID, inkID)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = s.changeWithActivity(userID, activities, func(tx *sql.Tx) error {
+		var current int
+		err := tx.QueryRow("SELECT COUNT(*) FROM inkings WHERE pen_id = ? AND cleaned_at IS NULL", penID).Scan(&current)
+		if err != nil {
+			return err
+		}
+		if current > 0 {
+			return ErrPenAlreadyInked
+		}
+
+		result, err := tx.Exec("INSERT INTO inkings (pen_id, ink_id, ink_name, inked_at, notes) VALUES (?, ?, ?, ?, ?)",
+			penID, inkID, ink.DisplayName(), at, notes)
+		if err != nil {
+			return err
+		}
+		id, err = result.LastInsertId()
+		setActivityItemID(activities, id)
+		return err
+	})
+	return id, err
+}
+
+// EndInking records that a pen has been cleaned.
+func (s *SQLiteStore) EndInking(userID, penID int64, at time.Time, activities ...Activity) error {
+	return s.changeWithActivity(userID, activities, func(tx *sql.Tx) error {
+		var inkingID int64
+		var inkedAt time.Time
+		err := tx.QueryRow("SELECT id, inked_at FROM inkings WHERE pen_id = ? AND cleaned_at IS NULL", penID).Scan(&inkingID, &inkedAt)
+		if err == sql.ErrNoRows {
+			return ErrPenNotInked
+		}
+		if err != nil {
+			return err
+		}
+		if at.Before(inkedAt) {
+			return ErrCleanedBeforeInked
+		}
+
+		_, err = tx.Exec("UPDATE inkings SET cleaned_at = ? WHERE id = ?", at, inkingID)
+		return err
+	})
+}
+
+// CurrentInkings returns every pen that is inked right now, longest inked first.
+func (s *SQLiteStore) CurrentInkings(userID int64) ([]Inking, error) {
+	userDB, err := s.openUserDB(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return queryInkings(userDB, inkingSelect+" WHERE inkings.cleaned_at IS NULL AND pens.deleted_at IS NULL ORDER BY inkings.inked_at")
+}
+
+// InkingHistory returns every inking of a pen, newest first.
+func (s *SQLiteStore) InkingHistory(userID, penID int64) ([]Inking, error) {
+	userDB, err := s.openUserDB(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return queryInkings(userDB, inkingSelect+" WHERE inkings.pen_id = ? ORDER BY inkings.inked_at DESC", penID)
+}
@@ -0,0 +1,43 @@
+// handlers/list_inks.go
+
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// ListInks retrieves the user's inks from the database and renders them using a template.
+func ListInks(w http.ResponseWriter, r *http.Request) {
+	// Define data at the beginning
+	var data struct {
+		Inks        []Ink
+		Error       string
+		RedirectURL string
+	}
+
+	userID := currentUserID(r)
+
+	// Fetch inks from the user's database
+	inks, err := inkStore.SelectInks(userID)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Unable to get your inks, please try later")
+		return
+	}
+
+	// Prepare data for template rendering
+	data.Inks = inks
+
+	// Check if there's any error message or redirection URL in the query parameters
+	queryParams := r.URL.Query()
+	if len(queryParams["error"]) > 0 {
+		data.Error = queryParams["error"][0]
+	}
+	if len(queryParams["redirect"]) > 0 {
+		data.RedirectURL = queryParams["redirect"][0]
+	}
+
+	// Parse and execute the template
+	tmpl := template.Must(template.New("inks.html").Funcs(template.FuncMap{"Add": Add}).ParseFiles(templatePath("inks.html")))
+	tmpl.Execute(w, data)
+}
@@ -3,7 +3,7 @@ package handlers
 
 import (
 	"net/http"
-	"golang.org/x/crypto/bcrypt"
+	"time"
 )
 
 // Login handles user login
@@ -13,27 +13,59 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		username := r.FormValue("username")
 		password := r.FormValue("password")
 
-		// Retrieve hashed password from the database based on the username
-		hashedPassword, err := GetPasswordByUsername(username)
+		// Refuse attempts while the username or the client is backing off,
+		// checking and recording one attempt of each at a time
+		ip := clientAddress(r)
+		unlock := lockThrottle("username:"+username, "ip:"+ip)
+		defer unlock()
+		wait, err := loginRetryAfter(username, ip, time.Now())
 		if err != nil {
-			RedirectWithError(w, r, "/login", "Invalid username or password")
+			RedirectWithError(w, r, "/login", "Unable to login, please try later")
+			return
+		}
+		if wait > 0 {
+			RedirectWithError(w, r, "/login", "Too many failed logins, please try again in "+formatWait(wait))
 			return
 		}
 
-		// Compare the hashed password with the provided password
-		err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+		// Compare the password with the stored hash, unknown users take as long as known ones
+		userID, ok, err := checkLoginPassword(username, password)
 		if err != nil {
+			RedirectWithError(w, r, "/login", "Unable to login, please try later")
+			return
+		}
+		if !ok {
+			recordLogin(r, username, userID, false)
 			RedirectWithError(w, r, "/login", "Invalid username or password")
 			return
 		}
 
-		// Authentication successful, set user ID in the session
-		userID, err := GetUserIDByUsername(username)
+		// Disabled accounts keep their data but can't log in
+		if !activeUser(userID) {
+			recordLogin(r, username, userID, false)
+			RedirectWithError(w, r, "/login", "This account is disabled, please contact the administrator")
+			return
+		}
+
+		// Users with two-factor authentication log in once their code is checked
+		tf, err := twoFactorStore.GetTwoFactor(userID)
 		if err != nil {
-			RedirectWithError(w, r, "/login", "Error getting user ID")
+			RedirectWithError(w, r, "/login", "Unable to login, please try later")
+			return
+		}
+		if tf.Enabled() {
+			// Until the code is given the login counts as failed, so that starting
+			// logins over doesn't get around the limit of codes per login
+			recordLoginAttempt(r, LoginAttempt{Username: username, UserID: userID, AwaitingCode: true})
+			if err := startSecondFactor(w, r, userID); err != nil {
+				RedirectWithError(w, r, "/login", "Unable to login, please try later")
+				return
+			}
+			http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
 			return
 		}
 
+		recordLogin(r, username, userID, true)
 		SetUserIDInSession(w, r, userID)
 
 		// Redirect to the user dashboard
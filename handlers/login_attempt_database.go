@@ -0,0 +1,98 @@
+// handlers/login_attempt_database.go
+
+package handlers
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RecordLoginAttempt stores a login attempt.
+func (s *SQLiteStore) RecordLoginAttempt(attempt LoginAttempt) error {
+	var userID interface{}
+	if attempt.UserID != 0 {
+		userID = attempt.UserID
+	}
+
+	_, err := s.db.Exec(`INSERT INTO login_attempts (username, user_id, ip, user_agent, succeeded, awaiting_code, attempted_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		attempt.Username, userID, attempt.IP, attempt.UserAgent, attempt.Succeeded, attempt.AwaitingCode, attempt.AttemptedAt)
+	return err
+}
+
+// countFailures counts the attempts selected by a query of attempted_at,
+// newest first, and returns the time of the newest.
+func (s *SQLiteStore) countFailures(query string, args ...interface{}) (int, time.Time, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer rows.Close()
+
+	var n int
+	var last time.Time
+	for rows.Next() {
+		var at time.Time
+		if err := rows.Scan(&at); err != nil {
+			return 0, time.Time{}, err
+		}
+		if n == 0 {
+			last = at
+		}
+		n++
+	}
+	return n, last, rows.Err()
+}
+
+// LoginFailures counts the recent failed logins for a username and from a client address.
+func (s *SQLiteStore) LoginFailures(username, ip string, since time.Time) (LoginFailures, error) {
+	var f LoginFailures
+
+	// A successful login starts the count of the username over
+	var lastSuccess time.Time
+	err := s.db.QueryRow(`SELECT attempted_at FROM login_attempts WHERE username = ? AND succeeded = 1
+		ORDER BY attempted_at DESC LIMIT 1`, username).Scan(&lastSuccess)
+	if err != nil && err != sql.ErrNoRows {
+		return f, err
+	}
+	usernameSince := since
+	if lastSuccess.After(since) {
+		usernameSince = lastSuccess
+	}
+
+	f.Username, f.LastUsername, err = s.countFailures(`SELECT attempted_at FROM login_attempts
+		WHERE username = ? AND succeeded = 0 AND attempted_at > ? ORDER BY attempted_at DESC`, username, usernameSince)
+	if err != nil {
+		return f, err
+	}
+
+	f.IP, f.LastIP, err = s.countFailures(`SELECT attempted_at FROM login_attempts
+		WHERE ip = ? AND succeeded = 0 AND awaiting_code = 0 AND attempted_at > ? ORDER BY attempted_at DESC`, ip, since)
+	return f, err
+}
+
+// ListLoginAttempts returns a user's most recent login attempts, newest first.
+func (s *SQLiteStore) ListLoginAttempts(userID int64, limit int) ([]LoginAttempt, error) {
+	rows, err := s.db.Query(`SELECT id, username, user_id, ip, user_agent, succeeded, awaiting_code, attempted_at
+		FROM login_attempts WHERE user_id = ? ORDER BY attempted_at DESC LIMIT ?`, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []LoginAttempt
+	for rows.Next() {
+		var a LoginAttempt
+		if err := rows.Scan(&a.ID, &a.Username, &a.UserID, &a.IP, &a.UserAgent, &a.Succeeded, &a.AwaitingCode, &a.AttemptedAt); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// DeleteLoginAttemptsBefore removes the login attempts made before the given time.
+func (s *SQLiteStore) DeleteLoginAttemptsBefore(before time.Time) error {
+	_, err := s.db.Exec("DELETE FROM login_attempts WHERE attempted_at < ?", before)
+	return err
+}
@@ -0,0 +1,236 @@
+// handlers/login_throttle.go
+
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"flock/config"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// loginHistoryRetention is how long login attempts are kept for the account page.
+	loginHistoryRetention = 90 * 24 * time.Hour
+	// loginPruneInterval is how often older login attempts and reset requests are deleted.
+	loginPruneInterval = time.Hour
+)
+
+// LoginAttempt is a recorded login, shown on the account page of its user.
+type LoginAttempt struct {
+	ID           int64
+	Username     string
+	UserID       int64 // 0 when no user has the username
+	IP           string
+	UserAgent    string
+	Succeeded    bool
+	AwaitingCode bool // the password was right, the second factor is still to come
+	AttemptedAt  time.Time
+}
+
+// LoginFailures counts the recent failed logins for a username and from a client address.
+type LoginFailures struct {
+	Username     int
+	LastUsername time.Time
+	IP           int
+	LastIP       time.Time
+}
+
+// loginLimits are the limits applied by Login, set by ConfigureLoginLimits.
+var loginLimits = config.DefaultLoginLimits
+
+// loginWait returns how long after the last of n failures another attempt is allowed.
+func loginWait(l config.LoginLimits, n, free, lockoutAfter int) time.Duration {
+	if n >= lockoutAfter {
+		return l.LockoutDuration
+	}
+	if n <= free {
+		return 0
+	}
+	delay := l.BaseDelay
+	for i := free + 1; i < n && delay < l.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, l.MaxDelay)
+}
+
+// loginRetryDelay returns how long to wait before the next attempt, or 0 if it is allowed now.
+func loginRetryDelay(l config.LoginLimits, f LoginFailures, now time.Time) time.Duration {
+	wait := max(
+		f.LastUsername.Add(loginWait(l, f.Username, l.FreeAttempts, l.LockoutAfter)).Sub(now),
+		f.LastIP.Add(loginWait(l, f.IP, l.IPFreeAttempts, l.IPLockoutAfter)).Sub(now),
+	)
+	return max(wait, 0)
+}
+
+// ConfigureLoginLimits sets the limits on failed logins.
+func ConfigureLoginLimits(limits config.LoginLimits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+	loginLimits = limits
+	return nil
+}
+
+// ScheduleLoginPrune deletes the login attempts and reset requests older
+// than loginHistoryRetention, on start and then every hour, until the
+// returned stop function is called.
+func ScheduleLoginPrune(s Store) (stop func()) {
+	quit := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(loginPruneInterval)
+		defer ticker.Stop()
+
+		for {
+			pruneLoginHistory(s, time.Now().Add(-loginHistoryRetention))
+
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(quit)
+		<-done
+	}
+}
+
+// pruneLoginHistory deletes the login attempts and reset requests made
+// before the given time, logging failures to do so.
+func pruneLoginHistory(s Store, before time.Time) {
+	if err := s.DeleteLoginAttemptsBefore(before); err != nil {
+		slog.Error("Error deleting old login attempts", "error", err)
+	}
+	if err := s.DeleteResetRequestsBefore(before); err != nil {
+		slog.Error("Error deleting old password reset requests", "error", err)
+	}
+}
+
+// loginLocks serializes the attempts sharing a throttle key, so that attempts
+// made at once can't all pass the check before any of them is recorded.
+// Entries are removed when no attempt holds or waits for them.
+var loginLocks = struct {
+	sync.Mutex
+	m map[string]*loginLock
+}{m: make(map[string]*loginLock)}
+
+// loginLock is the lock of a throttle key, with the attempts using it.
+type loginLock struct {
+	sync.Mutex
+	users int
+}
+
+// lockThrottle locks the throttle keys, such as a username and a client
+// address, until the returned function is called. Keys are locked in order,
+// so that attempts sharing several keys don't deadlock.
+func lockThrottle(keys ...string) (unlock func()) {
+	sort.Strings(keys)
+
+	locks := make([]*loginLock, len(keys))
+	loginLocks.Lock()
+	for i, key := range keys {
+		l, ok := loginLocks.m[key]
+		if !ok {
+			l = &loginLock{}
+			loginLocks.m[key] = l
+		}
+		l.users++
+		locks[i] = l
+	}
+	loginLocks.Unlock()
+
+	for _, l := range locks {
+		l.Lock()
+	}
+
+	return func() {
+		for _, l := range locks {
+			l.Unlock()
+		}
+
+		loginLocks.Lock()
+		for i, key := range keys {
+			if locks[i].users--; locks[i].users == 0 {
+				delete(loginLocks.m, key)
+			}
+		}
+		loginLocks.Unlock()
+	}
+}
+
+// loginRetryAfter returns how long a client must wait before trying to log in
+// as username. Unknown usernames are throttled the same way as real ones.
+func loginRetryAfter(username, ip string, now time.Time) (time.Duration, error) {
+	failures, err := loginAttemptStore.LoginFailures(username, ip, now.Add(-loginLimits.LockoutDuration))
+	if err != nil {
+		return 0, err
+	}
+	return loginRetryDelay(loginLimits, failures, now), nil
+}
+
+// formatWait describes a wait in whole seconds or minutes, rounded up.
+func formatWait(d time.Duration) string {
+	n, unit := int((d+time.Second-1)/time.Second), "second"
+	if d > time.Minute {
+		n, unit = int((d+time.Minute-1)/time.Minute), "minute"
+	}
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// recordLogin records a login attempt, logging failures to do so.
+func recordLogin(r *http.Request, username string, userID int64, succeeded bool) {
+	recordLoginAttempt(r, LoginAttempt{Username: username, UserID: userID, Succeeded: succeeded})
+}
+
+// recordLoginAttempt records an attempt made by the request, logging failures to do so.
+func recordLoginAttempt(r *http.Request, attempt LoginAttempt) {
+	attempt.IP = clientAddress(r)
+	attempt.UserAgent = r.UserAgent()
+	attempt.AttemptedAt = time.Now()
+	if err := loginAttemptStore.RecordLoginAttempt(attempt); err != nil {
+		requestLogger(r).Error("Error recording login attempt", "username", attempt.Username, "error", err)
+	}
+}
+
+// dummyPasswordHash is compared against when a username is unknown, so that
+// the time taken doesn't tell whether the user exists.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not the password of any user"), bcrypt.DefaultCost)
+
+// checkLoginPassword returns the user ID if the password is right for the
+// username, taking a bcrypt comparison whether the username exists or not.
+func checkLoginPassword(username, password string) (int64, bool, error) {
+	hashedPassword, err := userStore.GetPasswordByUsername(username)
+	if err != nil {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		if err == ErrUserNotFound {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	if bcrypt.CompareHashAndPassword(hashedPassword, []byte(password)) != nil {
+		userID, _ := userStore.GetUserIDByUsername(username)
+		return userID, false, nil
+	}
+
+	userID, err := userStore.GetUserIDByUsername(username)
+	if err != nil {
+		return 0, false, err
+	}
+	return userID, true, nil
+}
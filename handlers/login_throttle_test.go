@@ -0,0 +1,172 @@
+// handlers/login_throttle_test.go
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"flock/config"
+)
+
+func TestLoginWait(t *testing.T) {
+	limits := config.LoginLimits{
+		FreeAttempts:    3,
+		LockoutAfter:    10,
+		BaseDelay:       time.Second,
+		MaxDelay:        10 * time.Second,
+		LockoutDuration: 15 * time.Minute,
+	}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{8, 10 * time.Second}, // capped at MaxDelay
+		{9, 10 * time.Second},
+		{10, 15 * time.Minute}, // locked out
+		{50, 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := loginWait(limits, tt.failures, limits.FreeAttempts, limits.LockoutAfter); got != tt.want {
+			t.Errorf("wait after %d failures = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginRetryDelay(t *testing.T) {
+	limits := config.DefaultLoginLimits
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		failures LoginFailures
+		want     time.Duration
+	}{
+		{"no failures", LoginFailures{}, 0},
+		{"free attempts", LoginFailures{Username: 3, LastUsername: now, IP: 3, LastIP: now}, 0},
+		{"delayed username", LoginFailures{Username: 5, LastUsername: now}, 2 * time.Second},
+		{"delay partly waited", LoginFailures{Username: 5, LastUsername: now.Add(-1500 * time.Millisecond)}, 500 * time.Millisecond},
+		{"delay over", LoginFailures{Username: 5, LastUsername: now.Add(-time.Minute)}, 0},
+		{"delayed address", LoginFailures{IP: 12, LastIP: now}, 2 * time.Second},
+		{"longest of both", LoginFailures{Username: 4, LastUsername: now, IP: 13, LastIP: now}, 4 * time.Second},
+		{"locked out username", LoginFailures{Username: 10, LastUsername: now.Add(-5 * time.Minute)}, 10 * time.Minute},
+		{"locked out address", LoginFailures{IP: 50, LastIP: now}, 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := loginRetryDelay(limits, tt.failures, now); got != tt.want {
+				t.Errorf("loginRetryDelay = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatWait(t *testing.T) {
+	tests := []struct {
+		wait time.Duration
+		want string
+	}{
+		{time.Millisecond, "1 second"},
+		{time.Second, "1 second"},
+		{1500 * time.Millisecond, "2 seconds"},
+		{time.Minute, "60 seconds"},
+		{61 * time.Second, "2 minutes"},
+		{15 * time.Minute, "15 minutes"},
+	}
+
+	for _, tt := range tests {
+		if got := formatWait(tt.wait); got != tt.want {
+			t.Errorf("formatWait(%v) = %q, want %q", tt.wait, got, tt.want)
+		}
+	}
+}
+
+func TestLoginRetryAfterCountsRecordedFailures(t *testing.T) {
+	c := newTestClient(t)
+	r, _ := http.NewRequest(http.MethodPost, "/login", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+
+	for i := 0; i <= loginLimits.FreeAttempts; i++ {
+		recordLogin(r, c.user.Username, c.user.ID, false)
+	}
+
+	wait, err := loginRetryAfter(c.user.Username, "198.51.100.1", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait <= 0 || wait > loginLimits.BaseDelay {
+		t.Errorf("wait for the username = %v, want up to %v", wait, loginLimits.BaseDelay)
+	}
+
+	wait, _ = loginRetryAfter("someone else", "198.51.100.1", time.Now())
+	if wait != 0 {
+		t.Errorf("wait for another username from another address = %v, want 0", wait)
+	}
+}
+
+func TestLoginChecksAndRecordsAttemptsInTurn(t *testing.T) {
+	c := newTestClient(t)
+	// Unknown usernames take a bcrypt comparison between the check and the record
+	form := url.Values{"username": {"nobody"}, "password": {"wrong"}}.Encode()
+
+	// Attempts made at once still see the failures of each other
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < loginLimits.FreeAttempts+5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			<-start
+			Login(httptest.NewRecorder(), r)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	f, err := c.store.LoginFailures("nobody", "192.0.2.1", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Username != loginLimits.FreeAttempts+1 {
+		t.Errorf("%d attempts checked the password, want %d", f.Username, loginLimits.FreeAttempts+1)
+	}
+	if len(loginLocks.m) != 0 {
+		t.Errorf("%d throttle locks left, want none", len(loginLocks.m))
+	}
+}
+
+func TestPruneLoginHistory(t *testing.T) {
+	c := newTestClient(t)
+	now := time.Now()
+	for _, at := range []time.Time{now.Add(-2 * loginHistoryRetention), now} {
+		c.store.RecordLoginAttempt(LoginAttempt{Username: c.user.Username, UserID: c.user.ID, AttemptedAt: at})
+		c.store.RecordResetRequest(c.user.Email, "192.0.2.1", at)
+	}
+
+	pruneLoginHistory(c.store, now.Add(-loginHistoryRetention))
+
+	attempts, _ := c.store.ListLoginAttempts(c.user.ID, 10)
+	if len(attempts) != 1 || !attempts[0].AttemptedAt.Equal(now) {
+		t.Errorf("login attempts = %+v, want the recent one", attempts)
+	}
+	requests, _ := c.store.ResetRequests(c.user.Email, "192.0.2.1", now.Add(-3*loginHistoryRetention))
+	if requests.IP != 1 {
+		t.Errorf("%d reset requests left, want 1", requests.IP)
+	}
+}
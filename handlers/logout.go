@@ -8,12 +8,16 @@ import (
 
 // Logout handles user logout
 func Logout(w http.ResponseWriter, r *http.Request) {
-	// Clear the user session
-	session, _ := store.Get(r, "session-name")
-	session.Values["userID"] = nil
-	session.Options.MaxAge = -1
-	session.Save(r, w)
+	clearSession(w, r)
 
 	// Redirect to the login page
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
+
+// clearSession logs the browser out by expiring its session.
+func clearSession(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, sessionName)
+	session.Values["userID"] = nil
+	session.Options.MaxAge = -1
+	session.Save(r, w)
+}
@@ -0,0 +1,129 @@
+// handlers/mailer.go
+
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is an email sent to a user.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers email.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// format returns the message in RFC 5322 form, ready for SMTP.
+func (m Message) format(from string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// validateHeaders rejects addresses and subjects that could inject headers.
+func (m Message) validateHeaders() error {
+	if strings.ContainsAny(m.To+m.Subject, "\r\n") {
+		return fmt.Errorf("invalid email header in message to %q", m.To)
+	}
+	return nil
+}
+
+// SMTPMailer sends email through an SMTP server.
+type SMTPMailer struct {
+	Addr     string // host:port of the server
+	Username string // no authentication when empty
+	Password string
+	From     string
+}
+
+// Send delivers the message through the SMTP server.
+func (s SMTPMailer) Send(msg Message) error {
+	if err := msg.validateHeaders(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	return smtp.SendMail(s.Addr, auth, s.From, []string{msg.To}, msg.format(s.From))
+}
+
+// LogMailer logs email instead of sending it, for local testing. When Dir is
+// set every message is also written there as an .eml file.
+type LogMailer struct {
+	Dir  string
+	From string
+}
+
+// Send logs the message and writes it to Dir.
+func (l LogMailer) Send(msg Message) error {
+	if err := msg.validateHeaders(); err != nil {
+		return err
+	}
+
+	log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	if l.Dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(l.Dir, 0700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000000000"), strings.NewReplacer("@", "_at_", "/", "_").Replace(msg.To))
+	return os.WriteFile(filepath.Join(l.Dir, name), msg.format(l.From), 0600)
+}
+
+// The mailer used by the handlers and the base URL of links in emails, set by InitMailer.
+var (
+	mailer  Mailer = LogMailer{}
+	baseURL        = "http://localhost:8000"
+)
+
+// InitMailer sets the mailer and the base URL used in links sent by email.
+// Links are never built from the request's Host header, which clients control.
+func InitMailer(m Mailer, url string) {
+	mailer = m
+	baseURL = strings.TrimSuffix(url, "/")
+}
+
+// backgroundMail counts the emails being sent after their response.
+var backgroundMail sync.WaitGroup
+
+// sendInBackground runs send after the response, so that the time a request
+// takes doesn't depend on sending email.
+func sendInBackground(send func()) {
+	backgroundMail.Add(1)
+	go func() {
+		defer backgroundMail.Done()
+		send()
+	}()
+}
+
+// WaitForMail waits for the emails still being sent, before the server exits.
+func WaitForMail() {
+	backgroundMail.Wait()
+}
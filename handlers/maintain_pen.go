@@ -0,0 +1,136 @@
+// handlers/maintain_pen.go
+
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+// AddMaintenance records a servicing of a pen from the modify page.
+func AddMaintenance(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	// Get the pen ID from the URL parameter
+	penID, err := pathID(r)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Invalid pen ID")
+		return
+	}
+	penURL := fmt.Sprintf("/modify/%d", penID)
+
+	event, err := MaintenanceEventFromForm(r, penID)
+	if err != nil {
+		RedirectWithError(w, r, penURL, err.Error())
+		return
+	}
+
+	name := penName(userID, penID)
+	_, err = maintenanceStore.AddMaintenanceEvent(userID, event, newActivity(r, ActivityInsert, ItemMaintenance, 0, fmt.Sprintf("%s of %s", event.Label(), name), nil, maintenanceValues(event, name)))
+	if err == ErrPenNotFound {
+		RedirectWithError(w, r, "/dashboard", err.Error())
+		return
+	}
+	if err != nil {
+		RedirectWithError(w, r, penURL, "Unable to log the maintenance, please try again")
+		return
+	}
+
+	http.Redirect(w, r, penURL, http.StatusSeeOther)
+}
+
+// DeleteMaintenance removes a servicing from a pen's maintenance log.
+func DeleteMaintenance(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	// Get the event ID from the URL parameter
+	eventID, err := pathID(r)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Invalid maintenance ID")
+		return
+	}
+
+	// The form says which pen page to go back to, whose log holds the
+	// servicing kept for the activity
+	penURL := "/dashboard"
+	event := MaintenanceEvent{ID: eventID}
+	if penID, err := strconv.ParseInt(r.FormValue("pen_id"), 10, 64); err == nil {
+		penURL = fmt.Sprintf("/modify/%d", penID)
+		history, _ := maintenanceStore.MaintenanceHistory(userID, penID)
+		for _, e := range history {
+			if e.ID == eventID {
+				event = e
+			}
+		}
+	}
+
+	name := penName(userID, event.PenID)
+	err = maintenanceStore.DeleteMaintenanceEvent(userID, eventID, newActivity(r, ActivityDelete, ItemMaintenance, eventID, fmt.Sprintf("%s of %s", event.Label(), name), maintenanceValues(event, name), nil))
+	if err == ErrMaintenanceNotFound {
+		RedirectWithError(w, r, penURL, err.Error())
+		return
+	}
+	if err != nil {
+		RedirectWithError(w, r, penURL, "Unable to delete the maintenance, please try again")
+		return
+	}
+
+	http.Redirect(w, r, penURL, http.StatusSeeOther)
+}
+
+// FlushDue lists the inked pens by how soon they should be flushed, and saves
+// the number of days after which each kind of ink should be flushed.
+func FlushDue(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	if r.Method == http.MethodPost {
+		settings, err := FlushSettingsFromForm(r)
+		if err != nil {
+			RedirectWithError(w, r, "/flush", err.Error())
+			return
+		}
+
+		if err := settingsStore.SaveFlushSettings(userID, settings); err != nil {
+			RedirectWithError(w, r, "/flush", "Unable to save your flush reminders, please try again")
+			return
+		}
+
+		http.Redirect(w, r, "/flush", http.StatusSeeOther)
+		return
+	}
+
+	settings, err := settingsStore.GetFlushSettings(userID)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Unable to get your flush reminders, please try later")
+		return
+	}
+
+	// The thresholds depend on the properties of the ink in each pen
+	inked, err := inkingStore.CurrentInkings(userID)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Unable to get your inked pens, please try later")
+		return
+	}
+
+	inks, err := inkStore.SelectInks(userID)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Unable to get your inks, please try later")
+		return
+	}
+
+	data := struct {
+		Reminders   []FlushReminder
+		Settings    FlushSettings
+		Error       string
+		RedirectURL string
+	}{
+		Reminders: flushReminders(inked, inks, settings),
+		Settings:  settings,
+		Error:     r.URL.Query().Get("error"),
+	}
+
+	tmpl := template.Must(template.ParseFiles(templatePath("flush.html")))
+	tmpl.Execute(w, data)
+}
@@ -0,0 +1,182 @@
+// handlers/maintenance.go
+
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrMaintenanceNotFound is returned when a maintenance event does not exist.
+var ErrMaintenanceNotFound = errors.New("maintenance event not found")
+
+// MaintenanceEventType is a kind of servicing done on a pen.
+type MaintenanceEventType struct {
+	Value string
+	Label string
+}
+
+// MaintenanceEventTypes lists the kinds of servicing offered on the modify page.
+var MaintenanceEventTypes = []MaintenanceEventType{
+	{"flush", "Flush"},
+	{"sac_replacement", "Sac replacement"},
+	{"nib_tuning", "Nib tuning"},
+	{"regrind", "Regrind"},
+	{"repair", "Repair"},
+	{"polish", "Polish"},
+	{"other", "Other"},
+}
+
+// maintenanceEventLabel returns the label of an event type, or the value itself if unknown.
+func maintenanceEventLabel(value string) string {
+	for _, t := range MaintenanceEventTypes {
+		if t.Value == value {
+			return t.Label
+		}
+	}
+	return value
+}
+
+// MaintenanceEvent is a servicing done on a pen.
+type MaintenanceEvent struct {
+	ID          int64
+	PenID       int64
+	EventType   string
+	PerformedAt time.Time
+	Cost        Money
+	Notes       string
+}
+
+// Label is the human readable event type.
+func (e MaintenanceEvent) Label() string {
+	return maintenanceEventLabel(e.EventType)
+}
+
+// Validate checks that the event can be stored.
+func (e MaintenanceEvent) Validate() error {
+	if e.EventType == "" {
+		return errors.New("please choose what was done to the pen")
+	}
+	if e.PerformedAt.IsZero() {
+		return errors.New("please give the date of the maintenance")
+	}
+	return e.Cost.Validate()
+}
+
+// MaintenanceEventFromForm builds a maintenance event from the modify page.
+func MaintenanceEventFromForm(r *http.Request, penID int64) (MaintenanceEvent, error) {
+	performedAt, err := parseFormDate(r.FormValue("performed_at"), time.Now())
+	if err != nil {
+		return MaintenanceEvent{}, err
+	}
+
+	cost, err := ParseMoney(r.FormValue("cost"), r.FormValue("currency"))
+	if err != nil {
+		return MaintenanceEvent{}, fmt.Errorf("Cost: %w", err)
+	}
+
+	e := MaintenanceEvent{
+		PenID:       penID,
+		EventType:   strings.TrimSpace(r.FormValue("event_type")),
+		PerformedAt: performedAt,
+		Cost:        cost,
+		Notes:       strings.TrimSpace(r.FormValue("notes")),
+	}
+	return e, e.Validate()
+}
+
+// FlushSettings are the per-user number of days after which an inked pen
+// should be flushed. Shimmer and pigment inks clog feeds sooner, so they have
+// their own, usually shorter, thresholds.
+type FlushSettings struct {
+	DefaultDays int
+	ShimmerDays int
+	PigmentDays int
+}
+
+// DefaultFlushSettings are used until a user saves their own.
+var DefaultFlushSettings = FlushSettings{DefaultDays: 30, ShimmerDays: 14, PigmentDays: 14}
+
+// Validate checks that every threshold is at least a day.
+func (f FlushSettings) Validate() error {
+	if f.DefaultDays < 1 || f.ShimmerDays < 1 || f.PigmentDays < 1 {
+		return errors.New("flush reminders need at least one day")
+	}
+	return nil
+}
+
+// ThresholdFor returns the number of days after which a pen filled with ink should be flushed.
+func (f FlushSettings) ThresholdFor(ink Ink) int {
+	days := f.DefaultDays
+	if ink.Shimmer && f.ShimmerDays < days {
+		days = f.ShimmerDays
+	}
+	if ink.Pigment && f.PigmentDays < days {
+		days = f.PigmentDays
+	}
+	return days
+}
+
+// FlushSettingsFromForm reads the thresholds from the flush page.
+func FlushSettingsFromForm(r *http.Request) (FlushSettings, error) {
+	var f FlushSettings
+	fields := []struct {
+		name string
+		dest *int
+	}{
+		{"default_days", &f.DefaultDays},
+		{"shimmer_days", &f.ShimmerDays},
+		{"pigment_days", &f.PigmentDays},
+	}
+	for _, field := range fields {
+		days, err := strconv.Atoi(strings.TrimSpace(r.FormValue(field.name)))
+		if err != nil {
+			return FlushSettings{}, fmt.Errorf("%s: please give a number of days", Title(field.name))
+		}
+		*field.dest = days
+	}
+	return f, f.Validate()
+}
+
+// FlushReminder is a currently inked pen with its flush threshold.
+type FlushReminder struct {
+	Inking
+	ThresholdDays int
+}
+
+// DaysLeft is the number of days until the pen should be flushed, negative when overdue.
+func (f FlushReminder) DaysLeft() int {
+	return f.ThresholdDays - f.Days()
+}
+
+// Due reports whether the pen should be flushed now.
+func (f FlushReminder) Due() bool {
+	return f.DaysLeft() <= 0
+}
+
+// flushReminders computes the reminders for the current inkings, most urgent first.
+func flushReminders(inkings []Inking, inks []Ink, settings FlushSettings) []FlushReminder {
+	inksByID := make(map[int64]Ink, len(inks))
+	for _, ink := range inks {
+		inksByID[ink.ID] = ink
+	}
+
+	reminders := make([]FlushReminder, 0, len(inkings))
+	for _, inking := range inkings {
+		// Deleted inks have no known properties and use the default threshold
+		reminders = append(reminders, FlushReminder{
+			Inking:        inking,
+			ThresholdDays: settings.ThresholdFor(inksByID[inking.InkID]),
+		})
+	}
+
+	sort.SliceStable(reminders, func(i, j int) bool {
+		return reminders[i].DaysLeft() < reminders[j].DaysLeft()
+	})
+	return reminders
+}
@@ -0,0 +1,141 @@
+// handlers/maintenance_database.go
+
+package handlers
+
+import (
+	"database/sql"
+	"strconv"
+)
+
+// AddMaintenanceEvent records a servicing of a pen.
+func (s *SQLiteStore) AddMaintenanceEvent(userID int64, event MaintenanceEvent, activities ...Activity) (int64, error) {
+	if err := event.Validate(); err != nil {
+		return 0, err
+	}
+
+	if !s.PenExists(userID, event.PenID) {
+		return 0, ErrPenNotFound
+	}
+
+	var id int64
+	err := s.changeWithActivity(userID, activities, func(tx *sql.Tx) error {
+		result, err := tx.Exec(`INSERT INTO maintenance (pen_id, event_type, performed_at, cost, currency, notes)
+			VALUES (?, ?, ?, ?, ?, ?)`, event.PenID, event.EventType, event.PerformedAt, event.Cost, event.Cost.Currency, event.Notes)
+		if err != nil {
+			return err
+		}
+		id, err = result.LastInsertId()
+		setActivityItemID(activities, id)
+		return err
+	})
+	return id, err
+}
+
+// MaintenanceHistory returns every servicing of a pen, newest first.
+func (s *SQLiteStore) MaintenanceHistory(userID, penID int64) ([]MaintenanceEvent, error) {
+	userDB, err := s.openUserDB(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := userDB.Query(`SELECT id, pen_id, event_type, performed_at, cost, currency, COALESCE(notes, '')
+		FROM maintenance WHERE pen_id = ? ORDER BY performed_at DESC`, penID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []MaintenanceEvent
+	for rows.Next() {
+		var e MaintenanceEvent
+		if err := rows.Scan(&e.ID, &e.PenID, &e.EventType, &e.PerformedAt, &e.Cost, &e.Cost.Currency, &e.Notes); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// DeleteMaintenanceEvent removes a servicing from a pen's log.
+func (s *SQLiteStore) DeleteMaintenanceEvent(userID, eventID int64, activities ...Activity) error {
+	return s.changeWithActivity(userID, activities, func(tx *sql.Tx) error {
+		result, err := tx.Exec("DELETE FROM maintenance WHERE id = ?", eventID)
+		if err != nil {
+			return err
+		}
+
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			return ErrMaintenanceNotFound
+		}
+		return nil
+	})
+}
+
+// getSetting reads a setting from the user's database, returning "" when unset.
+func getSetting(userDB *sql.DB, key string) (string, error) {
+	var value string
+	err := userDB.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+// setSetting writes a setting to the user's database.
+func setSetting(tx *sql.Tx, key, value string) error {
+	_, err := tx.Exec("INSERT INTO settings (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value", key, value)
+	return err
+}
+
+// flushSettingKeys maps the settings keys to the flush thresholds.
+func flushSettingKeys(f *FlushSettings) map[string]*int {
+	return map[string]*int{
+		"flush_default_days": &f.DefaultDays,
+		"flush_shimmer_days": &f.ShimmerDays,
+		"flush_pigment_days": &f.PigmentDays,
+	}
+}
+
+// GetFlushSettings returns the user's flush thresholds, falling back to the defaults.
+func (s *SQLiteStore) GetFlushSettings(userID int64) (FlushSettings, error) {
+	userDB, err := s.openUserDB(userID)
+	if err != nil {
+		return FlushSettings{}, err
+	}
+
+	settings := DefaultFlushSettings
+	for key, dest := range flushSettingKeys(&settings) {
+		value, err := getSetting(userDB, key)
+		if err != nil {
+			return FlushSettings{}, err
+		}
+		if days, err := strconv.Atoi(value); err == nil {
+			*dest = days
+		}
+	}
+	return settings, nil
+}
+
+// SaveFlushSettings stores the user's flush thresholds.
+func (s *SQLiteStore) SaveFlushSettings(userID int64, settings FlushSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+
+	userDB, err := s.openUserDB(userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := userDB.Begin()
+	if err != nil {
+		return err
+	}
+	for key, days := range flushSettingKeys(&settings) {
+		if err := setSetting(tx, key, strconv.Itoa(*days)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,1419 @@
+// handlers/memory_store.go
+
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryUser is a user account held by MemoryStore.
+type memoryUser struct {
+	id             int64
+	username       string
+	firstName      string
+	middleName     string
+	lastName       string
+	email          string
+	hashedPassword []byte
+	bio            string
+	verifiedAt     time.Time
+	sentAt         time.Time
+	isAdmin        bool
+	disabledAt     time.Time
+	isDemo         bool
+	twoFactor      TwoFactor
+	recoveryCodes  map[string]bool // keyed by code hash, true once used
+}
+
+// memoryReset is a password reset token held by MemoryStore.
+type memoryReset struct {
+	userID    int64
+	expiresAt time.Time
+	used      bool
+}
+
+// memoryResetRequest is a request for password reset links.
+type memoryResetRequest struct {
+	email string
+	ip    string
+	at    time.Time
+}
+
+// user returns the account without its password.
+func (u *memoryUser) user() User {
+	return User{ID: u.id, Username: u.username, FirstName: u.firstName, MiddleName: u.middleName, LastName: u.lastName, Email: u.email, Bio: u.bio,
+		EmailVerifiedAt: u.verifiedAt, VerificationSentAt: u.sentAt, IsAdmin: u.isAdmin, DisabledAt: u.disabledAt}
+}
+
+// memoryPhoto is a photo held by MemoryStore together with its files.
+type memoryPhoto struct {
+	photo     Photo
+	data      []byte
+	thumbnail []byte
+}
+
+// Ensure MemoryStore satisfies Store.
+var _ Store = (*MemoryStore)(nil)
+
+// MemoryStore implements Store in memory. It is meant for tests and for
+// trying out Flock without touching the database directory.
+type MemoryStore struct {
+	mu         sync.Mutex
+	users      map[int64]*memoryUser
+	pens       map[int64]map[int64]Pen
+	inks       map[int64]map[int64]Ink
+	inkings    map[int64][]Inking
+	events     map[int64][]MaintenanceEvent
+	flush      map[int64]FlushSettings
+	photos     map[int64]map[int64]memoryPhoto
+	activity   map[int64][]Activity // oldest first
+	tokens     map[string]APIToken  // keyed by token hash
+	sessions   map[string]UserSession
+	resets     map[string]memoryReset // keyed by token hash
+	resetReqs  []memoryResetRequest
+	logins     []LoginAttempt
+	audit      []AdminAuditEntry
+	nextUserID int64
+	nextID     int64
+	nextPenID  map[int64]int64
+	nextInkID  map[int64]int64
+}
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:     make(map[int64]*memoryUser),
+		pens:      make(map[int64]map[int64]Pen),
+		inks:      make(map[int64]map[int64]Ink),
+		inkings:   make(map[int64][]Inking),
+		events:    make(map[int64][]MaintenanceEvent),
+		flush:     make(map[int64]FlushSettings),
+		photos:    make(map[int64]map[int64]memoryPhoto),
+		activity:  make(map[int64][]Activity),
+		tokens:    make(map[string]APIToken),
+		sessions:  make(map[string]UserSession),
+		resets:    make(map[string]memoryReset),
+		nextPenID: make(map[int64]int64),
+		nextInkID: make(map[int64]int64),
+	}
+}
+
+// Close releases nothing, it only satisfies Store.
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// CreateUserDB prepares an empty collection for the user.
+func (m *MemoryStore) CreateUserDB(userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.pens[userID]; !ok {
+		m.pens[userID] = make(map[int64]Pen)
+	}
+	return nil
+}
+
+// SelectPens returns the user's pens ordered by ID.
+func (m *MemoryStore) SelectPens(userID int64) ([]Pen, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var pens []Pen
+	for _, pen := range m.pens[userID] {
+		if pen.DeletedAt.IsZero() {
+			pens = append(pens, pen)
+		}
+	}
+	sort.Slice(pens, func(i, j int) bool {
+		return pens[i].ID < pens[j].ID
+	})
+
+	return pens, nil
+}
+
+// penLocked returns a pen of the user that isn't in the trash, the caller
+// must hold m.mu.
+func (m *MemoryStore) penLocked(userID, penID int64) (Pen, bool) {
+	pen, ok := m.pens[userID][penID]
+	if !ok || !pen.DeletedAt.IsZero() {
+		return Pen{}, false
+	}
+	return pen, true
+}
+
+// GetPenByID returns a single pen of the user.
+func (m *MemoryStore) GetPenByID(userID, penID int64) (Pen, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pen, ok := m.penLocked(userID, penID)
+	if !ok {
+		return Pen{}, ErrPenNotFound
+	}
+	return pen, nil
+}
+
+// insertPenLocked adds a pen, the caller must hold m.mu.
+func (m *MemoryStore) insertPenLocked(userID int64, pen Pen) int64 {
+	if _, ok := m.pens[userID]; !ok {
+		m.pens[userID] = make(map[int64]Pen)
+	}
+
+	pen.ID = m.nextPenID[userID] + 1
+	m.pens[userID][pen.ID] = pen
+	m.nextPenID[userID] = pen.ID
+	return pen.ID
+}
+
+// InsertPen adds a pen to the user's collection.
+func (m *MemoryStore) InsertPen(userID int64, pen Pen, activities ...Activity) (int64, error) {
+	if err := pen.Validate(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.insertPenLocked(userID, pen)
+	setActivityItemID(activities, id)
+	m.addActivityLocked(userID, activities)
+	return id, nil
+}
+
+// InsertPens adds several pens, either all of them or none, and returns
+// their new IDs.
+func (m *MemoryStore) InsertPens(userID int64, pens []Pen, activities ...Activity) ([]int64, error) {
+	for _, pen := range pens {
+		if err := pen.Validate(); err != nil {
+			return nil, fmt.Errorf("pen %q: %w", pen.Name, err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := make([]int64, len(pens))
+	for i, pen := range pens {
+		ids[i] = m.insertPenLocked(userID, pen)
+		if i < len(activities) {
+			activities[i].ItemID = ids[i]
+		}
+	}
+	m.addActivityLocked(userID, activities)
+	return ids, nil
+}
+
+// UpdatePen replaces the values of an existing pen.
+func (m *MemoryStore) UpdatePen(userID int64, pen Pen, activities ...Activity) error {
+	if err := pen.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.penLocked(userID, pen.ID); !ok {
+		return ErrPenNotFound
+	}
+	pen.DeletedAt = time.Time{}
+	m.pens[userID][pen.ID] = pen
+	m.addActivityLocked(userID, activities)
+	return nil
+}
+
+// DeletePenByID moves a pen of the user's collection to the trash.
+func (m *MemoryStore) DeletePenByID(userID, penID int64, activities ...Activity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pen, ok := m.penLocked(userID, penID)
+	if !ok {
+		return ErrPenNotFound
+	}
+	pen.DeletedAt = time.Now()
+	m.pens[userID][penID] = pen
+	m.addActivityLocked(userID, activities)
+	return nil
+}
+
+// DeletedPens returns the user's pens in the trash, last deleted first.
+func (m *MemoryStore) DeletedPens(userID int64) ([]Pen, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var pens []Pen
+	for _, pen := range m.pens[userID] {
+		if !pen.DeletedAt.IsZero() {
+			pens = append(pens, pen)
+		}
+	}
+	sort.Slice(pens, func(i, j int) bool {
+		return pens[i].DeletedAt.After(pens[j].DeletedAt)
+	})
+	return pens, nil
+}
+
+// RestorePen takes a pen out of the trash.
+func (m *MemoryStore) RestorePen(userID, penID int64, activities ...Activity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pen, ok := m.pens[userID][penID]
+	if !ok || pen.DeletedAt.IsZero() {
+		return ErrPenNotFound
+	}
+	pen.DeletedAt = time.Time{}
+	m.pens[userID][penID] = pen
+	m.addActivityLocked(userID, activities)
+	return nil
+}
+
+// PurgePen removes a pen for good, with its history and photos.
+func (m *MemoryStore) PurgePen(userID, penID int64, activities ...Activity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.pens[userID][penID]; !ok {
+		return ErrPenNotFound
+	}
+	m.purgePenLocked(userID, penID)
+	m.addActivityLocked(userID, activities)
+	return nil
+}
+
+// PurgeDeletedPens removes the pens deleted before the given time for good.
+func (m *MemoryStore) PurgeDeletedPens(userID int64, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for id, pen := range m.pens[userID] {
+		if !pen.DeletedAt.IsZero() && pen.DeletedAt.Before(before) {
+			m.purgePenLocked(userID, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// purgePenLocked removes a pen and everything about it, the caller must
+// hold m.mu.
+func (m *MemoryStore) purgePenLocked(userID, penID int64) {
+	delete(m.pens[userID], penID)
+
+	// Drop the pen's history, like ON DELETE CASCADE
+	var inkings []Inking
+	for _, inking := range m.inkings[userID] {
+		if inking.PenID != penID {
+			inkings = append(inkings, inking)
+		}
+	}
+	m.inkings[userID] = inkings
+
+	var events []MaintenanceEvent
+	for _, event := range m.events[userID] {
+		if event.PenID != penID {
+			events = append(events, event)
+		}
+	}
+	m.events[userID] = events
+
+	for id, p := range m.photos[userID] {
+		if p.photo.PenID == penID {
+			delete(m.photos[userID], id)
+		}
+	}
+}
+
+// PenExists reports whether the pen is part of the user's collection.
+func (m *MemoryStore) PenExists(userID, penID int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.penLocked(userID, penID)
+	return ok
+}
+
+// SelectInks returns the user's inks ordered by ID.
+func (m *MemoryStore) SelectInks(userID int64) ([]Ink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var inks []Ink
+	for _, ink := range m.inks[userID] {
+		inks = append(inks, ink)
+	}
+	sort.Slice(inks, func(i, j int) bool {
+		return inks[i].ID < inks[j].ID
+	})
+
+	return inks, nil
+}
+
+// GetInkByID returns a single ink of the user.
+func (m *MemoryStore) GetInkByID(userID, inkID int64) (Ink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ink, ok := m.inks[userID][inkID]
+	if !ok {
+		return Ink{}, ErrInkNotFound
+	}
+	return ink, nil
+}
+
+// insertInkLocked adds an ink, the caller must hold m.mu.
+func (m *MemoryStore) insertInkLocked(userID int64, ink Ink) int64 {
+	if _, ok := m.inks[userID]; !ok {
+		m.inks[userID] = make(map[int64]Ink)
+	}
+
+	ink.ID = m.nextInkID[userID] + 1
+	m.inks[userID][ink.ID] = ink
+	m.nextInkID[userID] = ink.ID
+	return ink.ID
+}
+
+// InsertInk adds an ink to the user's collection.
+func (m *MemoryStore) InsertInk(userID int64, ink Ink, activities ...Activity) (int64, error) {
+	if err := ink.Validate(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.insertInkLocked(userID, ink)
+	setActivityItemID(activities, id)
+	m.addActivityLocked(userID, activities)
+	return id, nil
+}
+
+// InsertInks adds several inks, either all of them or none.
+func (m *MemoryStore) InsertInks(userID int64, inks []Ink, activities ...Activity) error {
+	for _, ink := range inks {
+		if err := ink.Validate(); err != nil {
+			return fmt.Errorf("ink %q: %w", ink.DisplayName(), err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, ink := range inks {
+		id := m.insertInkLocked(userID, ink)
+		if i < len(activities) {
+			activities[i].ItemID = id
+		}
+	}
+	m.addActivityLocked(userID, activities)
+	return nil
+}
+
+// UpdateInk replaces the values of an existing ink.
+func (m *MemoryStore) UpdateInk(userID int64, ink Ink, activities ...Activity) error {
+	if err := ink.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.inks[userID][ink.ID]; !ok {
+		return ErrInkNotFound
+	}
+	m.inks[userID][ink.ID] = ink
+	m.addActivityLocked(userID, activities)
+	return nil
+}
+
+// DeleteInkByID removes an ink from the user's collection.
+func (m *MemoryStore) DeleteInkByID(userID, inkID int64, activities ...Activity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.inks[userID][inkID]; !ok {
+		return ErrInkNotFound
+	}
+	delete(m.inks[userID], inkID)
+
+	// Keep the history, like ON DELETE SET NULL
+	for i := range m.inkings[userID] {
+		if m.inkings[userID][i].InkID == inkID {
+			m.inkings[userID][i].InkID = 0
+		}
+	}
+	m.addActivityLocked(userID, activities)
+	return nil
+}
+
+// StartInking records that a pen has been filled with an ink.
+func (m *MemoryStore) StartInking(userID, penID, inkID int64, at time.Time, notes string, activities ...Activity) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pen, ok := m.penLocked(userID, penID)
+	if !ok {
+		return 0, ErrPenNotFound
+	}
+	ink, ok := m.inks[userID][inkID]
+	if !ok {
+		return 0, ErrInkNotFound
+	}
+	for _, inking := range m.inkings[userID] {
+		if inking.PenID == penID && inking.Current() {
+			return 0, ErrPenAlreadyInked
+		}
+	}
+
+	m.nextID++
+	m.inkings[userID] = append(m.inkings[userID], Inking{
+		ID:      m.nextID,
+		PenID:   penID,
+		PenName: pen.Name,
+		InkID:   inkID,
+		InkName: ink.DisplayName(),
+		InkedAt: at,
+		Notes:   notes,
+	})
+	setActivityItemID(activities, m.nextID)
+	m.addActivityLocked(userID, activities)
+	return m.nextID, nil
+}
+
+// EndInking records that a pen has been cleaned.
+func (m *MemoryStore) EndInking(userID, penID int64, at time.Time, activities ...Activity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, inking := range m.inkings[userID] {
+		if inking.PenID == penID && inking.Current() {
+			if at.Before(inking.InkedAt) {
+				return ErrCleanedBeforeInked
+			}
+			m.inkings[userID][i].CleanedAt = at
+			m.addActivityLocked(userID, activities)
+			return nil
+		}
+	}
+	return ErrPenNotInked
+}
+
+// CurrentInkings returns every pen that is inked right now, longest inked first.
+func (m *MemoryStore) CurrentInkings(userID int64) ([]Inking, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var inkings []Inking
+	for _, inking := range m.inkings[userID] {
+		if pen, ok := m.penLocked(userID, inking.PenID); ok && inking.Current() {
+			inking.PenName = pen.Name
+			inkings = append(inkings, inking)
+		}
+	}
+	sort.Slice(inkings, func(i, j int) bool {
+		return inkings[i].InkedAt.Before(inkings[j].InkedAt)
+	})
+	return inkings, nil
+}
+
+// InkingHistory returns every inking of a pen, newest first.
+func (m *MemoryStore) InkingHistory(userID, penID int64) ([]Inking, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var inkings []Inking
+	for _, inking := range m.inkings[userID] {
+		if inking.PenID == penID {
+			inking.PenName = m.pens[userID][penID].Name
+			inkings = append(inkings, inking)
+		}
+	}
+	sort.Slice(inkings, func(i, j int) bool {
+		return inkings[i].InkedAt.After(inkings[j].InkedAt)
+	})
+	return inkings, nil
+}
+
+// AddMaintenanceEvent records a servicing of a pen.
+func (m *MemoryStore) AddMaintenanceEvent(userID int64, event MaintenanceEvent, activities ...Activity) (int64, error) {
+	if err := event.Validate(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.penLocked(userID, event.PenID); !ok {
+		return 0, ErrPenNotFound
+	}
+
+	m.nextID++
+	event.ID = m.nextID
+	m.events[userID] = append(m.events[userID], event)
+	setActivityItemID(activities, event.ID)
+	m.addActivityLocked(userID, activities)
+	return event.ID, nil
+}
+
+// MaintenanceHistory returns every servicing of a pen, newest first.
+func (m *MemoryStore) MaintenanceHistory(userID, penID int64) ([]MaintenanceEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []MaintenanceEvent
+	for _, event := range m.events[userID] {
+		if event.PenID == penID {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].PerformedAt.After(events[j].PerformedAt)
+	})
+	return events, nil
+}
+
+// DeleteMaintenanceEvent removes a servicing from a pen's log.
+func (m *MemoryStore) DeleteMaintenanceEvent(userID, eventID int64, activities ...Activity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, event := range m.events[userID] {
+		if event.ID == eventID {
+			m.events[userID] = append(m.events[userID][:i], m.events[userID][i+1:]...)
+			m.addActivityLocked(userID, activities)
+			return nil
+		}
+	}
+	return ErrMaintenanceNotFound
+}
+
+// addActivityLocked records the activities of a change, but the updates that
+// changed nothing. The caller must hold m.mu.
+func (m *MemoryStore) addActivityLocked(userID int64, activities []Activity) {
+	for _, a := range recordedActivities(activities) {
+		m.nextID++
+		a.ID = m.nextID
+		m.activity[userID] = append(m.activity[userID], a)
+	}
+}
+
+// ListActivity returns the user's most recent changes, newest first, or
+// every change when limit is 0.
+func (m *MemoryStore) ListActivity(userID int64, limit int) ([]Activity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var activities []Activity
+	for i := len(m.activity[userID]) - 1; i >= 0; i-- {
+		if limit > 0 && len(activities) == limit {
+			break
+		}
+		activities = append(activities, m.activity[userID][i])
+	}
+	return activities, nil
+}
+
+// PenRevisions returns the activity of every modification of a pen, newest
+// first.
+func (m *MemoryStore) PenRevisions(userID, penID int64) ([]Activity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var revisions []Activity
+	for i := len(m.activity[userID]) - 1; i >= 0; i-- {
+		if a := m.activity[userID][i]; a.Action == ActivityUpdate && a.ItemType == ItemPen && a.ItemID == penID {
+			revisions = append(revisions, a)
+		}
+	}
+	return revisions, nil
+}
+
+// GetFlushSettings returns the user's flush thresholds, or the defaults.
+func (m *MemoryStore) GetFlushSettings(userID int64) (FlushSettings, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if settings, ok := m.flush[userID]; ok {
+		return settings, nil
+	}
+	return DefaultFlushSettings, nil
+}
+
+// SaveFlushSettings stores the user's flush thresholds.
+func (m *MemoryStore) SaveFlushSettings(userID int64, settings FlushSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.flush[userID] = settings
+	return nil
+}
+
+// AddPhoto stores a photo and its thumbnail.
+func (m *MemoryStore) AddPhoto(userID int64, photo Photo, data, thumbnail []byte, activities ...Activity) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.penLocked(userID, photo.PenID); !ok {
+		return 0, ErrPenNotFound
+	}
+	if m.photos[userID] == nil {
+		m.photos[userID] = make(map[int64]memoryPhoto)
+	}
+
+	m.nextID++
+	photo.ID = m.nextID
+	m.photos[userID][photo.ID] = memoryPhoto{photo: photo, data: data, thumbnail: thumbnail}
+	setActivityItemID(activities, photo.ID)
+	m.addActivityLocked(userID, activities)
+	return photo.ID, nil
+}
+
+// PenPhotos returns the photos of a pen, oldest first.
+func (m *MemoryStore) PenPhotos(userID, penID int64) ([]Photo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var photos []Photo
+	for _, p := range m.photos[userID] {
+		if p.photo.PenID == penID {
+			photos = append(photos, p.photo)
+		}
+	}
+	sort.Slice(photos, func(i, j int) bool { return photos[i].ID < photos[j].ID })
+	return photos, nil
+}
+
+// ReadPhoto returns a photo with its image or thumbnail.
+func (m *MemoryStore) ReadPhoto(userID, photoID int64, thumbnail bool) (Photo, []byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.photos[userID][photoID]
+	if !ok {
+		return Photo{}, nil, ErrPhotoNotFound
+	}
+	if thumbnail {
+		return p.photo, p.thumbnail, nil
+	}
+	return p.photo, p.data, nil
+}
+
+// DeletePhoto removes a photo.
+func (m *MemoryStore) DeletePhoto(userID, photoID int64, activities ...Activity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.photos[userID][photoID]; !ok {
+		return ErrPhotoNotFound
+	}
+	delete(m.photos[userID], photoID)
+	m.addActivityLocked(userID, activities)
+	return nil
+}
+
+// InsertUser adds a user, rejecting duplicate usernames like the SQLite UNIQUE constraint.
+func (m *MemoryStore) InsertUser(username, firstName, middleName, lastName, email string, hashedPassword []byte, bio string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.username == username {
+			return 0, errors.New("UNIQUE constraint failed: users.username")
+		}
+	}
+
+	m.nextUserID++
+	m.users[m.nextUserID] = &memoryUser{
+		id:             m.nextUserID,
+		username:       username,
+		firstName:      firstName,
+		middleName:     middleName,
+		lastName:       lastName,
+		email:          email,
+		hashedPassword: hashedPassword,
+		bio:            bio,
+	}
+	return m.nextUserID, nil
+}
+
+// userByUsernameLocked finds a user by name, the caller must hold m.mu.
+func (m *MemoryStore) userByUsernameLocked(username string) (*memoryUser, error) {
+	for _, u := range m.users {
+		if u.username == username {
+			return u, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+// GetPasswordByUsername returns the bcrypt hash of the user's password.
+func (m *MemoryStore) GetPasswordByUsername(username string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, err := m.userByUsernameLocked(username)
+	if err != nil {
+		return nil, err
+	}
+	return u.hashedPassword, nil
+}
+
+// GetUserIDByUsername returns the ID of the user.
+func (m *MemoryStore) GetUserIDByUsername(username string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, err := m.userByUsernameLocked(username)
+	if err != nil {
+		return 0, err
+	}
+	return u.id, nil
+}
+
+// GetUserByID returns the user.
+func (m *MemoryStore) GetUserByID(userID int64) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return u.user(), nil
+}
+
+// GetUsersByEmail returns every user registered with the email address.
+func (m *MemoryStore) GetUsersByEmail(email string) ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var users []User
+	for _, u := range m.users {
+		if strings.EqualFold(u.email, strings.TrimSpace(email)) {
+			users = append(users, u.user())
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// UpdatePassword replaces the hashed password of a user.
+func (m *MemoryStore) UpdatePassword(userID int64, hashedPassword []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.hashedPassword = hashedPassword
+	return nil
+}
+
+// UpdateProfile saves a user's names, email address, bio and email verification time.
+func (m *MemoryStore) UpdateProfile(u User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[u.ID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	stored.firstName, stored.middleName, stored.lastName = u.FirstName, u.MiddleName, u.LastName
+	stored.email, stored.bio, stored.verifiedAt = u.Email, u.Bio, u.EmailVerifiedAt
+	return nil
+}
+
+// UpdateUsername renames a user.
+func (m *MemoryStore) UpdateUsername(userID int64, username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	for _, u := range m.users {
+		if u.username == username && u.id != userID {
+			return ErrUsernameTaken
+		}
+	}
+	stored.username = username
+	return nil
+}
+
+// DeleteUser removes a user and everything the user stored.
+func (m *MemoryStore) DeleteUser(userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return ErrUserNotFound
+	}
+	delete(m.users, userID)
+	delete(m.pens, userID)
+	delete(m.inks, userID)
+	delete(m.inkings, userID)
+	delete(m.events, userID)
+	delete(m.flush, userID)
+	delete(m.photos, userID)
+	delete(m.activity, userID)
+	delete(m.nextPenID, userID)
+	delete(m.nextInkID, userID)
+
+	for hash, t := range m.tokens {
+		if t.UserID == userID {
+			delete(m.tokens, hash)
+		}
+	}
+	for id, session := range m.sessions {
+		if session.UserID == userID {
+			delete(m.sessions, id)
+		}
+	}
+	for hash, reset := range m.resets {
+		if reset.userID == userID {
+			delete(m.resets, hash)
+		}
+	}
+	logins := m.logins[:0]
+	for _, a := range m.logins {
+		if a.UserID != userID {
+			logins = append(logins, a)
+		}
+	}
+	m.logins = logins
+	return nil
+}
+
+// MarkEmailVerified records that a user verified their email address.
+func (m *MemoryStore) MarkEmailVerified(userID int64, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.verifiedAt = at
+	return nil
+}
+
+// SetVerificationSent records when a verification email was last sent to a user.
+func (m *MemoryStore) SetVerificationSent(userID int64, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[userID]; ok {
+		u.sentAt = at
+	}
+	return nil
+}
+
+// MarkDemo flags the user as the demo account.
+func (m *MemoryStore) MarkDemo(userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.isDemo = true
+	return nil
+}
+
+// GetDemoUserID returns the ID of the account flagged as the demo account.
+func (m *MemoryStore) GetDemoUserID() (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var demoID int64
+	for id, u := range m.users {
+		if u.isDemo && (demoID == 0 || id < demoID) {
+			demoID = id
+		}
+	}
+	if demoID == 0 {
+		return 0, ErrUserNotFound
+	}
+	return demoID, nil
+}
+
+// CreatePasswordReset stores the hash of a password reset token.
+func (m *MemoryStore) CreatePasswordReset(userID int64, tokenHash string, createdAt, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.resets[tokenHash] = memoryReset{userID: userID, expiresAt: expiresAt}
+	return nil
+}
+
+// UsePasswordReset marks a password reset token as used and returns its user.
+func (m *MemoryStore) UsePasswordReset(tokenHash string, now time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reset, ok := m.resets[tokenHash]
+	if !ok || reset.used || !reset.expiresAt.After(now) {
+		return 0, ErrResetTokenInvalid
+	}
+
+	for hash, r := range m.resets {
+		if r.userID == reset.userID {
+			r.used = true
+			m.resets[hash] = r
+		}
+	}
+	return reset.userID, nil
+}
+
+// RecordResetRequest stores a request for password reset links.
+func (m *MemoryStore) RecordResetRequest(email, ip string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.resetReqs = append(m.resetReqs, memoryResetRequest{email: email, ip: ip, at: at})
+	return nil
+}
+
+// ResetRequests counts the recent reset requests for an email address and from a client address.
+func (m *MemoryStore) ResetRequests(email, ip string, since time.Time) (LoginFailures, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var f LoginFailures
+	for _, req := range m.resetReqs {
+		if !req.at.After(since) {
+			continue
+		}
+		if req.email == email {
+			f.Username++
+			if req.at.After(f.LastUsername) {
+				f.LastUsername = req.at
+			}
+		}
+		if req.ip == ip {
+			f.IP++
+			if req.at.After(f.LastIP) {
+				f.LastIP = req.at
+			}
+		}
+	}
+	return f, nil
+}
+
+// DeleteResetRequestsBefore removes the reset requests made before the given time.
+func (m *MemoryStore) DeleteResetRequestsBefore(before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.resetReqs[:0]
+	for _, req := range m.resetReqs {
+		if !req.at.Before(before) {
+			kept = append(kept, req)
+		}
+	}
+	m.resetReqs = kept
+	return nil
+}
+
+// CreateAPIToken stores the hash of a new API token.
+func (m *MemoryStore) CreateAPIToken(token APIToken, tokenHash string) (int64, error) {
+	if err := token.Validate(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	token.ID = m.nextID
+	m.tokens[tokenHash] = token
+	return token.ID, nil
+}
+
+// ListAPITokens returns the user's API tokens, newest first.
+func (m *MemoryStore) ListAPITokens(userID int64) ([]APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tokens []APIToken
+	for _, t := range m.tokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID > tokens[j].ID })
+	return tokens, nil
+}
+
+// RevokeAPIToken deletes an API token of the user.
+func (m *MemoryStore) RevokeAPIToken(userID, tokenID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, t := range m.tokens {
+		if t.ID == tokenID && t.UserID == userID {
+			delete(m.tokens, hash)
+			return nil
+		}
+	}
+	return ErrAPITokenNotFound
+}
+
+// UseAPIToken looks up an API token by its hash and records when it was used.
+func (m *MemoryStore) UseAPIToken(tokenHash string, at time.Time) (APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tokens[tokenHash]
+	if !ok {
+		return APIToken{}, ErrAPITokenNotFound
+	}
+	t.LastUsedAt = at
+	m.tokens[tokenHash] = t
+	return t, nil
+}
+
+// SaveSession inserts or updates a server-side session.
+func (m *MemoryStore) SaveSession(session UserSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.sessions[session.ID]; ok {
+		session.CreatedAt = existing.CreatedAt
+	}
+	m.sessions[session.ID] = session
+	return nil
+}
+
+// GetSession returns a session that has not expired.
+func (m *MemoryStore) GetSession(id string) (UserSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok || !session.ExpiresAt.After(time.Now()) {
+		return UserSession{}, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// TouchSession records that a session was used.
+func (m *MemoryStore) TouchSession(id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session, ok := m.sessions[id]; ok {
+		session.LastSeenAt = at
+		m.sessions[id] = session
+	}
+	return nil
+}
+
+// DeleteSession removes a session.
+func (m *MemoryStore) DeleteSession(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sessions[id]; !ok {
+		return ErrSessionNotFound
+	}
+	delete(m.sessions, id)
+	return nil
+}
+
+// ListSessions returns the user's sessions that have not expired, most recently used first.
+func (m *MemoryStore) ListSessions(userID int64) ([]UserSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var sessions []UserSession
+	for _, session := range m.sessions {
+		if session.UserID == userID && session.ExpiresAt.After(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+	return sessions, nil
+}
+
+// DeleteUserSessions removes every session of the user except keepID.
+func (m *MemoryStore) DeleteUserSessions(userID int64, keepID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, session := range m.sessions {
+		if session.UserID == userID && id != keepID {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+// DeleteExpiredSessions removes the sessions that expired before now.
+func (m *MemoryStore) DeleteExpiredSessions(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, session := range m.sessions {
+		if !session.ExpiresAt.After(now) {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+// GetTwoFactor returns a user's two-factor setting with the number of unused recovery codes.
+func (m *MemoryStore) GetTwoFactor(userID int64) (TwoFactor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return TwoFactor{}, ErrUserNotFound
+	}
+	t := u.twoFactor
+	for _, used := range u.recoveryCodes {
+		if !used {
+			t.RecoveryCodesLeft++
+		}
+	}
+	return t, nil
+}
+
+// newRecoveryCodeSet returns unused recovery codes keyed by hash.
+func newRecoveryCodeSet(recoveryHashes []string) map[string]bool {
+	codes := make(map[string]bool, len(recoveryHashes))
+	for _, hash := range recoveryHashes {
+		codes[hash] = false
+	}
+	return codes
+}
+
+// EnableTwoFactor stores a user's TOTP secret and recovery codes.
+func (m *MemoryStore) EnableTwoFactor(userID int64, secret string, step int64, recoveryHashes []string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.twoFactor = TwoFactor{Secret: secret, EnabledAt: at, LastStep: step}
+	u.recoveryCodes = newRecoveryCodeSet(recoveryHashes)
+	return nil
+}
+
+// DisableTwoFactor removes a user's TOTP secret and recovery codes.
+func (m *MemoryStore) DisableTwoFactor(userID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[userID]; ok {
+		u.twoFactor = TwoFactor{}
+		u.recoveryCodes = nil
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes replaces a user's recovery codes.
+func (m *MemoryStore) ReplaceRecoveryCodes(userID int64, recoveryHashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[userID]; ok {
+		u.recoveryCodes = newRecoveryCodeSet(recoveryHashes)
+	}
+	return nil
+}
+
+// UseTOTPStep records the time step of an accepted code if it is newer than the last one.
+func (m *MemoryStore) UseTOTPStep(userID, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok || u.twoFactor.LastStep >= step {
+		return false, nil
+	}
+	u.twoFactor.LastStep = step
+	return true, nil
+}
+
+// UseRecoveryCode marks an unused recovery code of a user as used.
+func (m *MemoryStore) UseRecoveryCode(userID int64, codeHash string, at time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return false, nil
+	}
+	if used, ok := u.recoveryCodes[codeHash]; !ok || used {
+		return false, nil
+	}
+	u.recoveryCodes[codeHash] = true
+	return true, nil
+}
+
+// RecordLoginAttempt stores a login attempt.
+func (m *MemoryStore) RecordLoginAttempt(attempt LoginAttempt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	attempt.ID = m.nextID
+	m.logins = append(m.logins, attempt)
+	return nil
+}
+
+// LoginFailures counts the recent failed logins for a username and from a client address.
+func (m *MemoryStore) LoginFailures(username, ip string, since time.Time) (LoginFailures, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// A successful login starts the count of the username over
+	usernameSince := since
+	for _, a := range m.logins {
+		if a.Username == username && a.Succeeded && a.AttemptedAt.After(usernameSince) {
+			usernameSince = a.AttemptedAt
+		}
+	}
+
+	var f LoginFailures
+	for _, a := range m.logins {
+		if a.Succeeded {
+			continue
+		}
+		if a.Username == username && a.AttemptedAt.After(usernameSince) {
+			f.Username++
+			if a.AttemptedAt.After(f.LastUsername) {
+				f.LastUsername = a.AttemptedAt
+			}
+		}
+		if a.IP == ip && !a.AwaitingCode && a.AttemptedAt.After(since) {
+			f.IP++
+			if a.AttemptedAt.After(f.LastIP) {
+				f.LastIP = a.AttemptedAt
+			}
+		}
+	}
+	return f, nil
+}
+
+// ListLoginAttempts returns a user's most recent login attempts, newest first.
+func (m *MemoryStore) ListLoginAttempts(userID int64, limit int) ([]LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var attempts []LoginAttempt
+	for i := len(m.logins) - 1; i >= 0 && len(attempts) < limit; i-- {
+		if m.logins[i].UserID == userID {
+			attempts = append(attempts, m.logins[i])
+		}
+	}
+	return attempts, nil
+}
+
+// DeleteLoginAttemptsBefore removes the login attempts made before the given time.
+func (m *MemoryStore) DeleteLoginAttemptsBefore(before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.logins[:0]
+	for _, a := range m.logins {
+		if !a.AttemptedAt.Before(before) {
+			kept = append(kept, a)
+		}
+	}
+	m.logins = kept
+	return nil
+}
+
+// ListUsers returns every user ordered by ID.
+func (m *MemoryStore) ListUsers() ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := make([]User, 0, len(m.users))
+	for _, u := range m.users {
+		users = append(users, u.user())
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// UserStorage returns the number of pens of a user and the size of their photos.
+func (m *MemoryStore) UserStorage(userID int64) (UserStorage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	storage := UserStorage{Pens: len(m.pens[userID])}
+	for _, p := range m.photos[userID] {
+		storage.AttachmentsSize += int64(len(p.data) + len(p.thumbnail))
+	}
+	return storage, nil
+}
+
+// SetAdmin grants or removes a user's administrator role.
+func (m *MemoryStore) SetAdmin(userID int64, admin bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.isAdmin = admin
+	return nil
+}
+
+// SetUserDisabled disables a user from the given time, or enables them for a zero time.
+func (m *MemoryStore) SetUserDisabled(userID int64, disabledAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.disabledAt = disabledAt
+	return nil
+}
+
+// AddAdminAudit records an administrator's action.
+func (m *MemoryStore) AddAdminAudit(entry AdminAuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	entry.ID = m.nextID
+	m.audit = append(m.audit, entry)
+	return nil
+}
+
+// ListAdminAudit returns the most recent administrator actions, newest first.
+func (m *MemoryStore) ListAdminAudit(limit int) ([]AdminAuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var entries []AdminAuditEntry
+	for i := len(m.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, m.audit[i])
+	}
+	return entries, nil
+}
@@ -0,0 +1,180 @@
+// handlers/memory_store_test.go
+
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClient makes requests to the handlers as a logged in user, keeping the
+// session cookies between them like a browser.
+type testClient struct {
+	t       *testing.T
+	store   *MemoryStore
+	user    User
+	cookies map[string]*http.Cookie
+}
+
+// newTestClient sets the handlers up on an empty MemoryStore, with a user to
+// make requests as.
+func newTestClient(t *testing.T) *testClient {
+	t.Helper()
+
+	s := NewMemoryStore()
+	InitStore(s)
+	SetTemplateDir("../templates")
+	keys := []SessionKeyPair{{HashKey: []byte(strings.Repeat("h", 32)), BlockKey: []byte(strings.Repeat("b", 32))}}
+	if err := ConfigureSessions(keys, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	userID, err := s.InsertUser("tester", "Test", "", "User", "tester@example.com", []byte("hash"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateUserDB(userID); err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.GetUserByID(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClient{t: t, store: s, user: u, cookies: make(map[string]*http.Cookie)}
+}
+
+// do serves a request to the handler registered for the pattern, as the
+// client's user, and returns the response. Forms are posted URL-encoded.
+func (c *testClient) do(pattern string, h http.HandlerFunc, method, target string, form url.Values) *httptest.ResponseRecorder {
+	c.t.Helper()
+
+	var r *http.Request
+	if form != nil {
+		r = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r = httptest.NewRequest(method, target, nil)
+	}
+	for _, cookie := range c.cookies {
+		r.AddCookie(cookie)
+	}
+	r = r.WithContext(ContextWithUser(r.Context(), c.user))
+
+	router := NewRouter()
+	router.HandleFunc(pattern, h)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	for _, cookie := range w.Result().Cookies() {
+		c.cookies[cookie.Name] = cookie
+	}
+	return w
+}
+
+// addPen adds a pen straight to the store and returns its ID.
+func (c *testClient) addPen(name string) int64 {
+	c.t.Helper()
+	id, err := c.store.InsertPen(c.user.ID, Pen{Name: name, Maker: "Maker"})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return id
+}
+
+// wantRedirect fails the test unless the response redirects to target.
+func wantRedirect(t *testing.T, w *httptest.ResponseRecorder, target string) {
+	t.Helper()
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if got := w.Header().Get("Location"); got != target {
+		t.Fatalf("redirect = %q, want %q", got, target)
+	}
+}
+
+func TestMemoryStoreTrash(t *testing.T) {
+	c := newTestClient(t)
+	s, userID := c.store, c.user.ID
+	kept, trashed := c.addPen("Kept"), c.addPen("Trashed")
+
+	if err := s.DeletePenByID(userID, trashed); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeletePenByID(userID, trashed); !errors.Is(err, ErrPenNotFound) {
+		t.Errorf("deleting twice: err = %v, want ErrPenNotFound", err)
+	}
+
+	pens, _ := s.SelectPens(userID)
+	if len(pens) != 1 || pens[0].ID != kept {
+		t.Errorf("SelectPens = %v, want only the kept pen", pens)
+	}
+	if s.PenExists(userID, trashed) {
+		t.Error("PenExists is true for a pen in the trash")
+	}
+	if _, err := s.GetPenByID(userID, trashed); !errors.Is(err, ErrPenNotFound) {
+		t.Errorf("GetPenByID of a pen in the trash: err = %v", err)
+	}
+	if err := s.UpdatePen(userID, Pen{ID: trashed, Name: "Changed"}); !errors.Is(err, ErrPenNotFound) {
+		t.Errorf("UpdatePen of a pen in the trash: err = %v", err)
+	}
+
+	deleted, _ := s.DeletedPens(userID)
+	if len(deleted) != 1 || deleted[0].ID != trashed || deleted[0].DeletedAt.IsZero() {
+		t.Fatalf("DeletedPens = %v, want the trashed pen with its time", deleted)
+	}
+
+	if err := s.RestorePen(userID, trashed); err != nil {
+		t.Fatal(err)
+	}
+	if !s.PenExists(userID, trashed) {
+		t.Error("restored pen doesn't exist")
+	}
+	if err := s.RestorePen(userID, kept); !errors.Is(err, ErrPenNotFound) {
+		t.Errorf("restoring a pen outside the trash: err = %v", err)
+	}
+}
+
+func TestMemoryStorePurgeDeletedPens(t *testing.T) {
+	c := newTestClient(t)
+	s, userID := c.store, c.user.ID
+	penID := c.addPen("Old")
+	if err := s.DeletePenByID(userID, penID); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := s.PurgeDeletedPens(userID, time.Now().Add(-time.Hour))
+	if err != nil || n != 0 {
+		t.Fatalf("purging before the deletion: n = %d, err = %v, want 0", n, err)
+	}
+	n, err = s.PurgeDeletedPens(userID, time.Now().Add(time.Second))
+	if err != nil || n != 1 {
+		t.Fatalf("purging after the deletion: n = %d, err = %v, want 1", n, err)
+	}
+	if deleted, _ := s.DeletedPens(userID); len(deleted) != 0 {
+		t.Errorf("DeletedPens after the purge = %v", deleted)
+	}
+	if err := s.RestorePen(userID, penID); !errors.Is(err, ErrPenNotFound) {
+		t.Errorf("restoring a purged pen: err = %v", err)
+	}
+}
+
+func TestMemoryStoreInsertPensIsAllOrNothing(t *testing.T) {
+	c := newTestClient(t)
+
+	ids, err := c.store.InsertPens(c.user.ID, []Pen{{Name: "One"}, {Name: "Two"}})
+	if err != nil || len(ids) != 2 || ids[1] != ids[0]+1 {
+		t.Fatalf("InsertPens = %v, %v, want two consecutive IDs", ids, err)
+	}
+
+	if _, err := c.store.InsertPens(c.user.ID, []Pen{{Name: "Three"}, {}}); err == nil {
+		t.Fatal("InsertPens with a pen without a name succeeded")
+	}
+	if pens, _ := c.store.SelectPens(c.user.ID); len(pens) != 2 {
+		t.Errorf("a failed InsertPens left %d pens, want 2", len(pens))
+	}
+}
@@ -0,0 +1,244 @@
+// handlers/middleware.go
+
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// ContextWithUser returns a copy of ctx carrying the logged in user, who is
+// also noted for the request log.
+func ContextWithUser(ctx context.Context, u User) context.Context {
+	if info, ok := ctx.Value(requestInfoKey).(*requestInfo); ok {
+		info.userID = u.ID
+	}
+	return context.WithValue(ctx, userKey, u)
+}
+
+// UserFromContext returns the user added by RequireAuth or RequireAPIAuth.
+func UserFromContext(ctx context.Context) (User, bool) {
+	u, ok := ctx.Value(userKey).(User)
+	return u, ok
+}
+
+// currentUserID returns the ID of the user of a request that went through
+// RequireAuth or RequireAPIAuth.
+func currentUserID(r *http.Request) int64 {
+	u, _ := UserFromContext(r.Context())
+	return u.ID
+}
+
+// sessionUser returns the user logged in with the request's session, who
+// wasn't deleted or disabled since.
+func sessionUser(r *http.Request) (User, bool) {
+	session, _ := store.Get(r, sessionName)
+	userID, ok := session.Values["userID"].(int64)
+	if !ok {
+		return User{}, false
+	}
+
+	u, err := userStore.GetUserByID(userID)
+	if err != nil || u.Disabled() {
+		return User{}, false
+	}
+	return u, true
+}
+
+// RequireAuth lets only logged in users through, adding the user to the
+// request context. Others are sent to the login page.
+func RequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := sessionUser(r)
+		if !ok {
+			RedirectWithError(w, r, "/login", "Please login")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ContextWithUser(r.Context(), u)))
+	})
+}
+
+// RequireAPIAuth is RequireAuth for the API, answering with JSON errors.
+// Requests are authenticated with an "Authorization: Bearer" API token, or
+// else with the browser session. Read-only tokens may only be used to read.
+func RequireAPIAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := apiUserID(w, r)
+		if userID == 0 {
+			return
+		}
+
+		u, err := userStore.GetUserByID(userID)
+		if err != nil {
+			writeAPIError(w, http.StatusUnauthorized, apiErrUnauthorized, "please login or use an API token")
+			return
+		}
+		if emailVerification && !u.Verified() {
+			writeAPIError(w, http.StatusForbidden, apiErrForbidden, "please verify your email address first")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ContextWithUser(r.Context(), u)))
+	})
+}
+
+// RequireAdmin lets only administrators through, after RequireAuth.
+// Administrators impersonating a user are that user until they stop.
+func RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := UserFromContext(r.Context())
+		if !ok || !u.IsAdmin || impersonator(r) != 0 {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusWriter remembers the status and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(p)
+	sw.size += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// requestIDHeader returns the ID of a request to the client, so that a
+// problem they report can be found in the log.
+const requestIDHeader = "X-Request-ID"
+
+// requestInfo is what the request log learns about a request while it is
+// served. Handlers further in only get a copy of the context, so it is kept
+// behind a pointer.
+type requestInfo struct {
+	id     string
+	userID int64
+}
+
+// newRequestID returns a random ID for a request.
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RequestID returns the ID LogRequests gave the request, or "".
+func RequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoKey).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+// requestLogger returns the default logger with the request's ID and user.
+func requestLogger(r *http.Request) *slog.Logger {
+	info, ok := r.Context().Value(requestInfoKey).(*requestInfo)
+	if !ok {
+		return slog.Default()
+	}
+	logger := slog.With("request_id", info.id)
+	if info.userID != 0 {
+		logger = logger.With("user_id", info.userID)
+	}
+	return logger
+}
+
+// LogRequests gives every request an ID, sent back in the X-Request-ID
+// header, and logs it with its user, status, size and latency. Server errors
+// are logged as errors.
+func LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{id: newRequestID()}
+		w.Header().Set(requestIDHeader, info.id)
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), requestInfoKey, info)))
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if sw.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("request_id", info.id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", sw.status),
+			slog.Int("size", sw.size),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("remote", clientAddress(r)),
+		}
+		if info.userID != 0 {
+			attrs = append(attrs, slog.Int64("user_id", info.userID))
+		}
+		slog.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+// Recover answers a request whose handler panicked with a 500 error and logs
+// the panic, instead of dropping the connection.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if p := recover(); p != nil {
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				requestLogger(r).Error("panic", "method", r.Method, "path", r.URL.Path, "panic", p, "stack", string(debug.Stack()))
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// contentSecurityPolicy only lets pages load Flock's own scripts, styles and
+// images. The templates use inline scripts for their alerts.
+const contentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data:; object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// SecurityHeaders sets the headers asking browsers to restrict what pages
+// can do. Over HTTPS browsers are also told to keep using it.
+func SecurityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Referrer-Policy", "same-origin")
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			h.Set("Content-Security-Policy", contentSecurityPolicy)
+		}
+		if r.TLS != nil {
+			h.Set("Strict-Transport-Security", "max-age=31536000")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
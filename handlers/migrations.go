@@ -0,0 +1,617 @@
+// handlers/migrations.go
+
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+)
+
+// Migration describes a single forward schema change for a database.
+// Versions are tracked with SQLite's PRAGMA user_version, so every migration
+// in a list must have a unique, increasing Version.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *sql.Tx) error
+}
+
+// AppliedMigration records a migration that was applied to a database file.
+type AppliedMigration struct {
+	Database    string
+	Version     int
+	Description string
+}
+
+// MigrationReport lists every migration applied during a migration run.
+type MigrationReport []AppliedMigration
+
+// mainMigrations upgrade the main database holding the users table.
+var mainMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "create users table",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS users (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username TEXT UNIQUE NOT NULL,
+				first_name TEXT NOT NULL,
+				middle_name TEXT,
+				last_name TEXT NOT NULL,
+				email TEXT NOT NULL,
+				password TEXT NOT NULL,
+				bio TEXT
+			)`)
+			return err
+		},
+	},
+	{
+		Version:     2,
+		Description: "create api_tokens table",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS api_tokens (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				token_hash TEXT UNIQUE NOT NULL,
+				scope TEXT NOT NULL,
+				created_at DATETIME NOT NULL,
+				last_used_at DATETIME
+			)`)
+			return err
+		},
+	},
+	{
+		Version:     3,
+		Description: "create sessions table",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS sessions (
+				id TEXT PRIMARY KEY,
+				user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+				data TEXT NOT NULL,
+				user_agent TEXT NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL,
+				last_seen_at DATETIME NOT NULL,
+				expires_at DATETIME NOT NULL
+			)`)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS sessions_user_id ON sessions (user_id)`)
+			return err
+		},
+	},
+	{
+		Version:     4,
+		Description: "create password_resets table",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS password_resets (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				token_hash TEXT UNIQUE NOT NULL,
+				created_at DATETIME NOT NULL,
+				expires_at DATETIME NOT NULL,
+				used_at DATETIME
+			)`)
+			return err
+		},
+	},
+	{
+		Version:     5,
+		Description: "add email verification to users",
+		Up: func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`ALTER TABLE users ADD COLUMN email_verified_at DATETIME`); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`ALTER TABLE users ADD COLUMN verification_sent_at DATETIME`); err != nil {
+				return err
+			}
+			// Accounts created before verification existed are trusted as they are
+			_, err := tx.Exec(`UPDATE users SET email_verified_at = CURRENT_TIMESTAMP`)
+			return err
+		},
+	},
+	{
+		Version:     6,
+		Description: "add two-factor authentication",
+		Up: func(tx *sql.Tx) error {
+			for _, stmt := range []string{
+				`ALTER TABLE users ADD COLUMN totp_secret TEXT`,
+				`ALTER TABLE users ADD COLUMN totp_enabled_at DATETIME`,
+				`ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0`,
+				`CREATE TABLE IF NOT EXISTS recovery_codes (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+					code_hash TEXT NOT NULL,
+					used_at DATETIME
+				)`,
+				`CREATE INDEX IF NOT EXISTS recovery_codes_user_id ON recovery_codes (user_id)`,
+			} {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     7,
+		Description: "create login_attempts table",
+		Up: func(tx *sql.Tx) error {
+			for _, stmt := range []string{
+				`CREATE TABLE IF NOT EXISTS login_attempts (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					username TEXT NOT NULL,
+					user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+					ip TEXT NOT NULL,
+					user_agent TEXT NOT NULL DEFAULT '',
+					succeeded INTEGER NOT NULL,
+					attempted_at DATETIME NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS login_attempts_username ON login_attempts (username, attempted_at)`,
+				`CREATE INDEX IF NOT EXISTS login_attempts_ip ON login_attempts (ip, attempted_at)`,
+				`CREATE INDEX IF NOT EXISTS login_attempts_user_id ON login_attempts (user_id, attempted_at)`,
+			} {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     8,
+		Description: "add administrators and the admin audit",
+		Up: func(tx *sql.Tx) error {
+			for _, stmt := range []string{
+				`ALTER TABLE users ADD COLUMN is_admin INTEGER NOT NULL DEFAULT 0`,
+				`ALTER TABLE users ADD COLUMN disabled_at DATETIME`,
+				`CREATE TABLE IF NOT EXISTS admin_audit (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					admin_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+					admin_username TEXT NOT NULL,
+					action TEXT NOT NULL,
+					target_id INTEGER,
+					target_username TEXT NOT NULL DEFAULT '',
+					details TEXT NOT NULL DEFAULT '',
+					created_at DATETIME NOT NULL
+				)`,
+			} {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     9,
+		Description: "create password_reset_requests table",
+		Up: func(tx *sql.Tx) error {
+			for _, stmt := range []string{
+				`CREATE TABLE IF NOT EXISTS password_reset_requests (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					email TEXT NOT NULL,
+					ip TEXT NOT NULL,
+					requested_at DATETIME NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS password_reset_requests_email ON password_reset_requests (email, requested_at)`,
+				`CREATE INDEX IF NOT EXISTS password_reset_requests_ip ON password_reset_requests (ip, requested_at)`,
+			} {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     10,
+		Description: "flag the demo account",
+		Up: func(tx *sql.Tx) error {
+			for _, stmt := range []string{
+				`ALTER TABLE users ADD COLUMN is_demo INTEGER NOT NULL DEFAULT 0`,
+				// Earlier versions added this account on every start
+				`UPDATE users SET is_demo = 1 WHERE username = 'demo' AND email = 'demo@example.com'`,
+			} {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     11,
+		Description: "record logins waiting for their second factor",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`ALTER TABLE login_attempts ADD COLUMN awaiting_code INTEGER NOT NULL DEFAULT 0`)
+			return err
+		},
+	},
+}
+
+// userMigrations upgrade every user's pens database.
+var userMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "create pens table",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS pens (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT,
+				maker TEXT,
+				color TEXT,
+				material TEXT,
+				nib_size TEXT,
+				nib_color TEXT,
+				filling_system TEXT,
+				trims TEXT,
+				year INTEGER,
+				price REAL,
+				misc TEXT
+			)`)
+			return err
+		},
+	},
+	{
+		Version:     2,
+		Description: "add currency to pens",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`ALTER TABLE pens ADD COLUMN currency TEXT NOT NULL DEFAULT ''`)
+			return err
+		},
+	},
+	{
+		Version:     3,
+		Description: "create inks table",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS inks (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				brand TEXT,
+				line TEXT,
+				color_name TEXT,
+				bottle_size REAL NOT NULL DEFAULT 0,
+				volume_remaining REAL NOT NULL DEFAULT 0,
+				shimmer INTEGER NOT NULL DEFAULT 0,
+				sheen INTEGER NOT NULL DEFAULT 0,
+				waterproof INTEGER NOT NULL DEFAULT 0,
+				pigment INTEGER NOT NULL DEFAULT 0,
+				purchased TEXT,
+				price REAL,
+				currency TEXT NOT NULL DEFAULT '',
+				notes TEXT
+			)`)
+			return err
+		},
+	},
+	{
+		Version:     4,
+		Description: "create inkings table",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS inkings (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				pen_id INTEGER NOT NULL REFERENCES pens(id) ON DELETE CASCADE,
+				ink_id INTEGER REFERENCES inks(id) ON DELETE SET NULL,
+				ink_name TEXT NOT NULL DEFAULT '',
+				inked_at DATETIME NOT NULL,
+				cleaned_at DATETIME,
+				notes TEXT
+			)`)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS inkings_pen_id ON inkings (pen_id)`)
+			return err
+		},
+	},
+	{
+		Version:     5,
+		Description: "create maintenance and settings tables",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS maintenance (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				pen_id INTEGER NOT NULL REFERENCES pens(id) ON DELETE CASCADE,
+				event_type TEXT NOT NULL,
+				performed_at DATETIME NOT NULL,
+				cost REAL,
+				currency TEXT NOT NULL DEFAULT '',
+				notes TEXT
+			)`)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS maintenance_pen_id ON maintenance (pen_id)`)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS settings (
+				key TEXT PRIMARY KEY,
+				value TEXT NOT NULL
+			)`)
+			return err
+		},
+	},
+	{
+		Version:     6,
+		Description: "create photos table",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS photos (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				pen_id INTEGER NOT NULL REFERENCES pens(id) ON DELETE CASCADE,
+				file_name TEXT NOT NULL,
+				original_name TEXT NOT NULL DEFAULT '',
+				content_type TEXT NOT NULL,
+				size INTEGER NOT NULL,
+				uploaded_at DATETIME NOT NULL
+			)`)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS photos_pen_id ON photos (pen_id)`)
+			return err
+		},
+	},
+	{
+		Version:     7,
+		Description: "create activity table",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS activity (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				action TEXT NOT NULL,
+				item_type TEXT NOT NULL,
+				item_id INTEGER NOT NULL DEFAULT 0,
+				item_name TEXT NOT NULL DEFAULT '',
+				before_values TEXT,
+				after_values TEXT,
+				request_id TEXT NOT NULL DEFAULT '',
+				created_at DATETIME NOT NULL
+			)`)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS activity_created_at ON activity (created_at)`)
+			return err
+		},
+	},
+	{
+		Version:     8,
+		Description: "add deleted_at to pens for the trash",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`ALTER TABLE pens ADD COLUMN deleted_at DATETIME`)
+			return err
+		},
+	},
+	{
+		Version:     9,
+		Description: "index activity by item for the revisions of pens",
+		Up: func(tx *sql.Tx) error {
+			_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS activity_item ON activity (item_type, item_id)`)
+			return err
+		},
+	},
+	{
+		Version:     10,
+		Description: "store prices as minor units and move unreadable years and prices to the notes",
+		Up: func(tx *sql.Tx) error {
+			if err := migratePrices(tx, "pens", "misc"); err != nil {
+				return err
+			}
+			if err := migratePrices(tx, "inks", "notes"); err != nil {
+				return err
+			}
+			return migrateYears(tx)
+		},
+	},
+}
+
+// legacyValue is a value of a pen or an ink written before it was checked.
+type legacyValue struct {
+	id    int64
+	value interface{}
+}
+
+// selectLegacyValues reads the non-null values of a column, closing the rows
+// before the values are rewritten.
+func selectLegacyValues(tx *sql.Tx, table, column string) ([]legacyValue, error) {
+	rows, err := tx.Query(fmt.Sprintf("SELECT id, %s FROM %s WHERE %s IS NOT NULL", column, table, column))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []legacyValue
+	for rows.Next() {
+		var v legacyValue
+		if err := rows.Scan(&v.id, &v.value); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// keepInNotes appends a value that couldn't be read to the notes of a row,
+// so that the text typed in is not lost.
+func keepInNotes(tx *sql.Tx, table, notesColumn string, id int64, column string, value interface{}) error {
+	if b, ok := value.([]byte); ok {
+		value = string(b)
+	}
+	note := fmt.Sprintf("%s: %v", Title(column), value)
+	_, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET %s = CASE WHEN %s IS NULL OR %s = '' THEN ? ELSE %s || '; ' || ? END WHERE id = ?`,
+		table, notesColumn, notesColumn, notesColumn, notesColumn), note, note, id)
+	return err
+}
+
+// migratePrices turns the REAL price column of a table, which also holds
+// whatever was typed in before prices were checked, into an INTEGER column of
+// minor units. Prices that can't be read are moved to the notes.
+func migratePrices(tx *sql.Tx, table, notesColumn string) error {
+	prices, err := selectLegacyValues(tx, table, "price")
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range []string{
+		"ALTER TABLE %s RENAME COLUMN price TO legacy_price",
+		"ALTER TABLE %s ADD COLUMN price INTEGER",
+		"ALTER TABLE %s DROP COLUMN legacy_price",
+	} {
+		if _, err := tx.Exec(fmt.Sprintf(stmt, table)); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range prices {
+		amount, err := legacyMinorUnits(p.value)
+		if err != nil {
+			if err := keepInNotes(tx, table, notesColumn, p.id, "price", p.value); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET price = ? WHERE id = ?", table), amount, p.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateYears moves the years of pens that can't be read, such as "1950s",
+// to their notes.
+func migrateYears(tx *sql.Tx) error {
+	years, err := selectLegacyValues(tx, "pens", "year")
+	if err != nil {
+		return err
+	}
+
+	for _, y := range years {
+		var year PenDate
+		if year.Scan(y.value) == nil {
+			continue
+		}
+		if err := keepInNotes(tx, "pens", "misc", y.id, "year", y.value); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE pens SET year = NULL WHERE id = ?", y.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migratedDBs remembers which database files were already migrated by this
+// process, so that repeated opens do not re-check the schema version.
+var migratedDBs sync.Map
+
+// RegisterMainMigration adds a migration for the main database.
+func RegisterMainMigration(m Migration) {
+	mainMigrations = append(mainMigrations, m)
+}
+
+// RegisterUserMigration adds a migration for the users' pens databases.
+func RegisterUserMigration(m Migration) {
+	userMigrations = append(userMigrations, m)
+}
+
+// schemaVersion reads the schema version stored in the database header.
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// Migrate applies every migration newer than the database's current version.
+// Each migration runs in its own transaction together with the version bump,
+// so a failing migration leaves the database at the last good version.
+func Migrate(db *sql.DB, name string, migrations []Migration) (MigrationReport, error) {
+	current, err := schemaVersion(db)
+	if err != nil {
+		return nil, fmt.Errorf("reading schema version of %s: %w", name, err)
+	}
+
+	var report MigrationReport
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return report, err
+		}
+
+		if err := m.Up(tx); err != nil {
+			tx.Rollback()
+			return report, fmt.Errorf("migration %d (%s) on %s: %w", m.Version, m.Description, name, err)
+		}
+
+		// PRAGMA does not accept bound parameters, the version is an int we control
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.Version)); err != nil {
+			tx.Rollback()
+			return report, err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return report, err
+		}
+
+		current = m.Version
+		report = append(report, AppliedMigration{Database: name, Version: m.Version, Description: m.Description})
+	}
+
+	return report, nil
+}
+
+// migrateOnce migrates a database the first time it is opened by this process.
+func migrateOnce(db *sql.DB, path string, migrations []Migration) error {
+	if _, done := migratedDBs.Load(path); done {
+		return nil
+	}
+
+	report, err := Migrate(db, filepath.Base(path), migrations)
+	if err != nil {
+		return err
+	}
+	for _, applied := range report {
+		log.Printf("Applied migration %d (%s) to %s", applied.Version, applied.Description, applied.Database)
+	}
+
+	migratedDBs.Store(path, true)
+	return nil
+}
+
+// MigrateAll upgrades the main database and every user's pens database found
+// in the store's directory, returning a report of the migrations applied.
+func (s *SQLiteStore) MigrateAll() (MigrationReport, error) {
+	report, err := Migrate(s.db, dbName, mainMigrations)
+	if err != nil {
+		return report, err
+	}
+	migratedDBs.Store(filepath.Join(s.dir, dbName), true)
+
+	userDBPaths, err := filepath.Glob(filepath.Join(s.dir, "*_pens.db"))
+	if err != nil {
+		return report, err
+	}
+
+	for _, path := range userDBPaths {
+		userDB, err := openSQLite(path)
+		if err != nil {
+			return report, err
+		}
+
+		applied, err := Migrate(userDB, filepath.Base(path), userMigrations)
+		userDB.Close()
+		report = append(report, applied...)
+		if err != nil {
+			return report, err
+		}
+		migratedDBs.Store(path, true)
+	}
+
+	return report, nil
+}
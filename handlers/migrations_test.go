@@ -0,0 +1,101 @@
+// handlers/migrations_test.go
+
+package handlers
+
+import (
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestMigrateLegacyPensAndInks(t *testing.T) {
+	db, err := openSQLite(filepath.Join(t.TempDir(), "1_pens.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Rows as written before years and prices were checked
+	if _, err := Migrate(db, "pens", userMigrations[:9]); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO pens (id, name, year, price, misc) VALUES
+		(1, 'Plain', 1950, 120, ''),
+		(2, 'Decimal', '2019-05', 99.5, 'boxed'),
+		(3, 'Typed', '1950s', '$120', NULL),
+		(4, 'Text', '2001', '1,250.50', '')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO inks (id, brand, price, notes) VALUES (1, 'Pilot', 12.25, '')`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Migrate(db, "pens", userMigrations); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[int64]struct {
+		year  string
+		price int64
+		misc  string
+	}{
+		1: {"1950", 12000, ""},
+		2: {"2019-05", 9950, "boxed"},
+		3: {"", 0, "Price: $120; Year: 1950s"},
+		4: {"2001", 125050, ""},
+	}
+	rows, err := db.Query("SELECT " + penSelectColumns + " FROM pens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		pen, err := scanPen(rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := want[pen.ID]
+		if pen.Year.String() != w.year || pen.Price.Amount != w.price || pen.Misc != w.misc {
+			t.Errorf("pen %d = year %q, price %d, misc %q, want %+v", pen.ID, pen.Year, pen.Price.Amount, pen.Misc, w)
+		}
+	}
+
+	ink, err := scanInk(db.QueryRow("SELECT " + inkSelectColumns + " FROM inks WHERE id = 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ink.Price.Amount != 1225 {
+		t.Errorf("ink price = %d, want 1225", ink.Price.Amount)
+	}
+}
+
+func TestMigrateFlagsLegacyDemoAccount(t *testing.T) {
+	db, err := openSQLite(filepath.Join(t.TempDir(), "main.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// The account earlier versions added on every start, and a namesake
+	if _, err := Migrate(db, "main", mainMigrations[:9]); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO users (id, username, first_name, last_name, email, password, bio) VALUES
+		(1, 'demo', 'Demo', 'User', 'demo@example.com', 'hash', 'This is a demo user.'),
+		(2, 'Demo', 'Real', 'Person', 'demo@example.org', 'hash', '')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Migrate(db, "main", mainMigrations); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &SQLiteStore{db: db}
+	demoID, err := s.GetDemoUserID()
+	if err != nil || demoID != 1 {
+		t.Errorf("GetDemoUserID = %d, %v, want 1", demoID, err)
+	}
+}
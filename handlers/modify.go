@@ -2,86 +2,137 @@
 package handlers
 
 import (
-	//"fmt"
+	"fmt"
 	"html/template"
 	"net/http"
-	"strconv"
+	"time"
 	// "log"
 )
 
 // ModifyPen handles the modification of a pen in the database.
 // It processes both GET and POST requests. For GET requests, it renders
 // the modify pen form with pre-filled values based on the pen ID.
-// For POST requests, it extracts form values, prepares column values,
-// and updates the pen in the database using the ModifyPen function.
+// For POST requests, it builds a Pen from the form values, validates it,
+// and updates the pen in the database using the pen store.
 func ModifyPen(w http.ResponseWriter, r *http.Request) {
 
-	userID := GetUserIDFromSession(r)
-	if userID == 0 {
-		RedirectWithError(w, r, "/login", "Please login to modify your pen")
+	userID := currentUserID(r)
+
+	// Get the pen ID from the URL parameter
+	penID, err := pathID(r)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Invalid pen ID")
 		return
 	}
 
 	if r.Method == http.MethodPost {
-		r.ParseForm()
+		// The form may carry new photos of the pen
+		if err := parseUploadForm(w, r); err != nil {
+			RedirectWithError(w, r, fmt.Sprintf("/modify/%d", penID), "The upload is too large, please add fewer or smaller photos")
+			return
+		}
 
-		// Get the pen ID from the URL parameter
-		penID, err := strconv.ParseInt(r.URL.Path[len("/modify/"):], 10, 64)
+		// Build the pen from the form values
+		pen, err := PenFromForm(r)
 		if err != nil {
-			RedirectWithError(w, r, "/dashboard", "Invalid pen ID")
+			RedirectWithError(w, r, fmt.Sprintf("/modify/%d", penID), err.Error())
 			return
 		}
+		pen.ID = penID
 
-		// columns := GetColumnNames("pens") // Fetch column names dynamically using handler function
-		columns := GetColumnNames(userID, "pens")
-		// Remove "id" from column names
-		columns = columns[1:]
-
-		columnValues := make([]interface{}, len(columns))
-		for i, col := range columns {
-			columnValues[i] = r.FormValue(col)
+		before, err := penStore.GetPenByID(userID, penID)
+		if err != nil {
+			RedirectWithError(w, r, "/dashboard", "Doesn't look like the pen exists anymore")
+			return
 		}
 
-		// Update the pen using the ModifyPen function from handlers
-		// err = UpdatePen(penID, convertInterfaceToStringSlice(columnValues))
-		err = UpdatePen(userID, penID, convertInterfaceToStringSlice(columnValues))
+		// Update the pen using the pen store
+		err = penStore.UpdatePen(userID, pen, newActivity(r, ActivityUpdate, ItemPen, penID, pen.Name, penValues(before), penValues(pen)))
 		if err != nil {
 			RedirectWithError(w, r, "/dashboard", "Error modifying pen")
 			return
 		}
 
+		if err := savePhotos(r, userID, penID); err != nil {
+			RedirectWithError(w, r, fmt.Sprintf("/modify/%d", penID), err.Error())
+			return
+		}
+
 		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
 		return
 	}
 
-	// Get the pen ID from the URL parameter
-	penID, err := strconv.ParseInt(r.URL.Path[len("/modify/"):], 10, 64)
+	// Fetch pen details based on ID
+	pen, err := penStore.GetPenByID(userID, penID)
 	if err != nil {
-		RedirectWithError(w, r, "/dashboard", "Invalid pen ID")
+		RedirectWithError(w, r, "/dashboard", "Doesn't look like the pen exists anymore")
 		return
 	}
 
-	// Fetch pen details based on ID
-	pen, err := GetPenByID(userID, penID)
+	// Fetch the inks to choose from and the pen's inking history
+	inks, err := inkStore.SelectInks(userID)
 	if err != nil {
-		RedirectWithError(w, r, "/dashboard", "Doesn't look like the pen exists anymore")
+		RedirectWithError(w, r, "/dashboard", "Unable to get your inks, please try later")
 		return
 	}
 
-	// columns := GetColumnNames("pens") // Fetch column names dynamically using handler function
-	columns := GetColumnNames(userID, "pens")
+	history, err := inkingStore.InkingHistory(userID, penID)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Unable to get the inking history of your pen")
+		return
+	}
+
+	maintenance, err := maintenanceStore.MaintenanceHistory(userID, penID)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Unable to get the maintenance log of your pen")
+		return
+	}
+
+	photos, err := photoStore.PenPhotos(userID, penID)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Unable to get the photos of your pen")
+		return
+	}
+
+	revisions, err := activityStore.PenRevisions(userID, penID)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Unable to get the history of your pen")
+		return
+	}
+
+	// The newest inking is the current one if the pen hasn't been cleaned
+	var current *Inking
+	if len(history) > 0 && history[0].Current() {
+		current = &history[0]
+	}
 
 	data := struct {
-		Columns []string
-		Pen     map[string]interface{}
+		Columns     []string
+		Pen         Pen
+		Inks        []Ink
+		Current     *Inking
+		History     []Inking
+		Maintenance []MaintenanceEvent
+		EventTypes  []MaintenanceEventType
+		Photos      []Photo
+		Revisions   []Activity
+		Today       string
+		Error       string
+		RedirectURL string
 	}{
-		Columns: columns, // Include all columns, excluding "id"
-		Pen:     pen,
+		Columns:     PenColumns,
+		Pen:         pen,
+		Inks:        inks,
+		Current:     current,
+		History:     history,
+		Maintenance: maintenance,
+		EventTypes:  MaintenanceEventTypes,
+		Photos:      photos,
+		Revisions:   revisions,
+		Today:       time.Now().Format("2006-01-02"),
+		Error:       r.URL.Query().Get("error"),
 	}
 
-	//fmt.Println("Data:", data)
-
-	// tmpl := template.Must(template.ParseFiles("templates/modify.html"))
-	tmpl := template.Must(template.New("modify.html").Funcs(template.FuncMap{"Title": Title}).ParseFiles("templates/modify.html"))
+	tmpl := template.Must(template.New("modify.html").Funcs(template.FuncMap{"Title": Title}).ParseFiles(templatePath("modify.html")))
 	tmpl.Execute(w, data)
 }
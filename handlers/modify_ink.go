@@ -0,0 +1,71 @@
+// handlers/modify_ink.go
+
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+// ModifyInk handles the modification of an ink in the database.
+// For GET requests, it renders the modify ink form with pre-filled values.
+// For POST requests, it builds an Ink from the form values, validates it,
+// and updates the ink using the ink store.
+func ModifyInk(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	// Get the ink ID from the URL parameter
+	inkID, err := pathID(r)
+	if err != nil {
+		RedirectWithError(w, r, "/inks", "Invalid ink ID")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		r.ParseForm()
+
+		// Build the ink from the form values
+		ink, err := InkFromForm(r)
+		if err != nil {
+			RedirectWithError(w, r, fmt.Sprintf("/inks/modify/%d", inkID), err.Error())
+			return
+		}
+		ink.ID = inkID
+
+		before, err := inkStore.GetInkByID(userID, inkID)
+		if err != nil {
+			RedirectWithError(w, r, "/inks", "Doesn't look like the ink exists anymore")
+			return
+		}
+
+		// Update the ink using the ink store
+		err = inkStore.UpdateInk(userID, ink, newActivity(r, ActivityUpdate, ItemInk, inkID, ink.DisplayName(), inkValues(before), inkValues(ink)))
+		if err != nil {
+			RedirectWithError(w, r, "/inks", "Error modifying ink")
+			return
+		}
+
+		http.Redirect(w, r, "/inks", http.StatusSeeOther)
+		return
+	}
+
+	// Fetch ink details based on ID
+	ink, err := inkStore.GetInkByID(userID, inkID)
+	if err != nil {
+		RedirectWithError(w, r, "/inks", "Doesn't look like the ink exists anymore")
+		return
+	}
+
+	data := struct {
+		Ink         Ink
+		Error       string
+		RedirectURL string
+	}{
+		Ink:   ink,
+		Error: r.URL.Query().Get("error"),
+	}
+
+	tmpl := template.Must(template.ParseFiles(templatePath("modify_ink.html")))
+	tmpl.Execute(w, data)
+}
@@ -0,0 +1,120 @@
+// handlers/modify_test.go
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestModifyPen(t *testing.T) {
+	c := newTestClient(t)
+	penID := c.addPen("Safari")
+	target := fmt.Sprintf("/modify/%d", penID)
+
+	w := c.do("/modify/{id}", ModifyPen, http.MethodGet, target, nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `value="Safari"`) {
+		t.Fatalf("the form doesn't show the pen: status %d", w.Code)
+	}
+
+	w = c.do("/modify/{id}", ModifyPen, http.MethodPost, target, url.Values{"name": {"Safari"}, "nib_size": {"B"}})
+	wantRedirect(t, w, "/dashboard")
+
+	pen, err := c.store.GetPenByID(c.user.ID, penID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pen.NibSize != "B" || pen.Maker != "" {
+		t.Errorf("pen = %+v, want the values of the form", pen)
+	}
+}
+
+func TestModifyPenErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		form     url.Values
+		redirect string
+	}{
+		{"unknown pen", "/modify/99", url.Values{"name": {"Safari"}}, "/dashboard?error=Doesn%27t+look+like+the+pen+exists+anymore"},
+		{"bad ID", "/modify/x", url.Values{"name": {"Safari"}}, "/dashboard?error=Invalid+pen+ID"},
+		{"invalid pen", "/modify/1", url.Values{"name": {""}}, "/modify/1?error=a+pen+needs+a+name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t)
+			c.addPen("Safari")
+			wantRedirect(t, c.do("/modify/{id}", ModifyPen, http.MethodPost, tt.target, tt.form), tt.redirect)
+		})
+	}
+}
+
+func TestModifyPenRecordsActivity(t *testing.T) {
+	c := newTestClient(t)
+	c.do("/add", AddPen, http.MethodPost, "/add", url.Values{"name": {"Safari"}})
+	pens, _ := c.store.SelectPens(c.user.ID)
+	if len(pens) != 1 {
+		t.Fatalf("got %d pens, want the one added", len(pens))
+	}
+	penID := pens[0].ID
+	target := fmt.Sprintf("/modify/%d", penID)
+
+	// A rejected change leaves no activity behind
+	c.do("/modify/{id}", ModifyPen, http.MethodPost, target, url.Values{"name": {""}})
+	c.do("/modify/{id}", ModifyPen, http.MethodPost, target, url.Values{"name": {"Safari"}, "nib_size": {"B"}})
+
+	activities, err := c.store.ListActivity(c.user.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 2 {
+		t.Fatalf("got %d activities, want the insert and the update", len(activities))
+	}
+	for _, a := range activities {
+		if a.ItemType != ItemPen || a.ItemID != penID {
+			t.Errorf("activity %+v, want pen %d", a, penID)
+		}
+	}
+	if a := activities[0]; a.Action != ActivityUpdate || a.After["nib_size"] != "B" {
+		t.Errorf("newest activity = %+v, want the update of the nib", a)
+	}
+}
+
+func TestRevertPen(t *testing.T) {
+	c := newTestClient(t)
+	c.do("/add", AddPen, http.MethodPost, "/add", url.Values{"name": {"Safari"}, "nib_size": {"F"}})
+	pens, _ := c.store.SelectPens(c.user.ID)
+	if len(pens) != 1 {
+		t.Fatalf("got %d pens, want the one added", len(pens))
+	}
+	penID := pens[0].ID
+	target := fmt.Sprintf("/modify/%d", penID)
+	c.do("/modify/{id}", ModifyPen, http.MethodPost, target, url.Values{"name": {"Safari"}, "nib_size": {"B"}})
+
+	// The revisions are the updates of the pen's activity
+	revisions, err := c.store.PenRevisions(c.user.ID, penID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 || revisions[0].Before["nib_size"] != "F" {
+		t.Fatalf("revisions = %+v, want the change of the nib", revisions)
+	}
+
+	w := c.do("/modify/{id}/revert/{revision}", RevertPen, http.MethodPost, fmt.Sprintf("%s/revert/%d", target, revisions[0].ID), nil)
+	wantRedirect(t, w, target)
+
+	pen, _ := c.store.GetPenByID(c.user.ID, penID)
+	if pen.NibSize != "F" {
+		t.Errorf("nib size = %q, want it reverted to F", pen.NibSize)
+	}
+	if revisions, _ = c.store.PenRevisions(c.user.ID, penID); len(revisions) != 2 {
+		t.Errorf("got %d revisions, want the revert kept as one too", len(revisions))
+	}
+
+	w = c.do("/modify/{id}/revert/{revision}", RevertPen, http.MethodPost, target+"/revert/999", nil)
+	wantRedirect(t, w, target+"?error=This+change+isn%27t+in+the+history+of+your+pen")
+}
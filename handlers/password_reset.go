@@ -0,0 +1,26 @@
+// handlers/password_reset.go
+
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// ErrResetTokenInvalid is returned for reset tokens that are unknown, used or expired.
+var ErrResetTokenInvalid = errors.New("the password reset link is invalid or has expired")
+
+// resetTokenLifetime is how long a password reset link can be used.
+const resetTokenLifetime = time.Hour
+
+// newResetToken generates a random password reset token and returns it with its hash.
+func newResetToken() (token, tokenHash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
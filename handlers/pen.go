@@ -0,0 +1,468 @@
+// handlers/pen.go
+
+package handlers
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pen is a fountain pen in a user's collection.
+type Pen struct {
+	ID            int64
+	Name          string
+	Maker         string
+	Color         string
+	Material      string
+	NibSize       string
+	NibColor      string
+	FillingSystem string
+	Trims         string
+	Year          PenDate
+	Price         Money
+	Misc          string
+	DeletedAt     time.Time // zero unless the pen is in the Trash
+}
+
+// PenColumns lists the editable pen columns in the order used by forms and CSV files.
+var PenColumns = []string{"name", "maker", "color", "material", "nib_size", "nib_color", "filling_system", "trims", "year", "price", "currency", "misc"}
+
+// penSelectColumns is the column list scanned by scanPen.
+const penSelectColumns = "id, name, maker, color, material, nib_size, nib_color, filling_system, trims, year, price, currency, misc, deleted_at"
+
+// Field returns the value of a pen column formatted as text, for templates and CSV export.
+func (p Pen) Field(column string) string {
+	switch column {
+	case "id":
+		return strconv.FormatInt(p.ID, 10)
+	case "name":
+		return p.Name
+	case "maker":
+		return p.Maker
+	case "color":
+		return p.Color
+	case "material":
+		return p.Material
+	case "nib_size":
+		return p.NibSize
+	case "nib_color":
+		return p.NibColor
+	case "filling_system":
+		return p.FillingSystem
+	case "trims":
+		return p.Trims
+	case "year":
+		return p.Year.String()
+	case "price":
+		return p.Price.AmountString()
+	case "currency":
+		return p.Price.Currency
+	case "misc":
+		return p.Misc
+	}
+	return ""
+}
+
+// setField parses text into the pen column of the same name.
+func (p *Pen) setField(column, value string) error {
+	value = strings.TrimSpace(value)
+
+	switch column {
+	case "id":
+		// Identifiers are assigned by the store
+	case "name":
+		p.Name = value
+	case "maker":
+		p.Maker = value
+	case "color":
+		p.Color = value
+	case "material":
+		p.Material = value
+	case "nib_size":
+		p.NibSize = value
+	case "nib_color":
+		p.NibColor = value
+	case "filling_system":
+		p.FillingSystem = value
+	case "trims":
+		p.Trims = value
+	case "year":
+		year, err := ParsePenDate(value)
+		if err != nil {
+			return err
+		}
+		p.Year = year
+	case "price":
+		amount, err := parseMinorUnits(value)
+		if err != nil {
+			return err
+		}
+		p.Price.Amount = amount
+	case "currency":
+		p.Price.Currency = strings.ToUpper(value)
+	case "misc":
+		p.Misc = value
+	default:
+		return fmt.Errorf("unknown pen column %q", column)
+	}
+	return nil
+}
+
+// Validate checks that the pen can be stored.
+func (p Pen) Validate() error {
+	if p.Name == "" {
+		return errors.New("a pen needs a name")
+	}
+	if err := p.Year.Validate(); err != nil {
+		return err
+	}
+	return p.Price.Validate()
+}
+
+// args returns the values of PenColumns for INSERT and UPDATE statements.
+func (p Pen) args() []interface{} {
+	return []interface{}{p.Name, p.Maker, p.Color, p.Material, p.NibSize, p.NibColor, p.FillingSystem, p.Trims, p.Year, p.Price, p.Price.Currency, p.Misc}
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanPen scans a row selected with penSelectColumns.
+func scanPen(row rowScanner) (Pen, error) {
+	var p Pen
+	var name, maker, color, material, nibSize, nibColor, fillingSystem, trims, currency, misc nullString
+	var deletedAt sql.NullTime
+	err := row.Scan(&p.ID, &name, &maker, &color, &material, &nibSize, &nibColor, &fillingSystem, &trims, &p.Year, &p.Price, &currency, &misc, &deletedAt)
+	if err != nil {
+		return Pen{}, err
+	}
+	p.DeletedAt = deletedAt.Time
+
+	p.Name, p.Maker, p.Color, p.Material = string(name), string(maker), string(color), string(material)
+	p.NibSize, p.NibColor, p.FillingSystem, p.Trims = string(nibSize), string(nibColor), string(fillingSystem), string(trims)
+	p.Price.Currency, p.Misc = string(currency), string(misc)
+	return p, nil
+}
+
+// PenFromForm builds a pen from the add and modify forms.
+func PenFromForm(r *http.Request) (Pen, error) {
+	var p Pen
+	for _, col := range PenColumns {
+		if err := p.setField(col, r.FormValue(col)); err != nil {
+			return Pen{}, fmt.Errorf("%s: %w", Title(col), err)
+		}
+	}
+	return p, p.Validate()
+}
+
+// PenFromRecord builds a pen from a CSV record, matching values to columns by
+// the header rather than by position. Unknown header columns are rejected.
+func PenFromRecord(header, record []string) (Pen, error) {
+	if len(header) != len(record) {
+		return Pen{}, fmt.Errorf("expected %d values, found %d", len(header), len(record))
+	}
+
+	var p Pen
+	for i, col := range header {
+		col = strings.ToLower(strings.TrimSpace(col))
+		if err := p.setField(col, record[i]); err != nil {
+			return Pen{}, fmt.Errorf("%s: %w", Title(col), err)
+		}
+	}
+	return p, p.Validate()
+}
+
+// PenCSVHeader is the header row written by the CSV export.
+func PenCSVHeader() []string {
+	return append([]string{"id"}, PenColumns...)
+}
+
+// Record returns the pen as a CSV record matching PenCSVHeader.
+func (p Pen) Record() []string {
+	header := PenCSVHeader()
+	record := make([]string, len(header))
+	for i, col := range header {
+		record[i] = p.Field(col)
+	}
+	return record
+}
+
+// nullString scans TEXT columns that may hold NULL as an empty string.
+type nullString string
+
+// Scan implements sql.Scanner.
+func (s *nullString) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*s = ""
+	case string:
+		*s = nullString(v)
+	case []byte:
+		*s = nullString(v)
+	default:
+		*s = nullString(fmt.Sprintf("%v", v))
+	}
+	return nil
+}
+
+// PenDate is when a pen was bought or made. Older records only know the year,
+// so the month and day are optional and zero when unknown.
+type PenDate struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// ParsePenDate parses "2006", "2006-01" or "2006-01-02". An empty string is the zero PenDate.
+func ParsePenDate(s string) (PenDate, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return PenDate{}, nil
+	}
+
+	for _, layout := range []string{"2006-01-02", "2006-01", "2006"} {
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			continue
+		}
+		switch layout {
+		case "2006":
+			return PenDate{Year: t.Year()}, nil
+		case "2006-01":
+			return PenDate{Year: t.Year(), Month: t.Month()}, nil
+		default:
+			return PenDate{Year: t.Year(), Month: t.Month(), Day: t.Day()}, nil
+		}
+	}
+
+	return PenDate{}, fmt.Errorf("%q is not a year or a date like 2006-01-02", s)
+}
+
+// IsZero reports whether the date is unknown.
+func (d PenDate) IsZero() bool {
+	return d.Year == 0
+}
+
+// String formats the date with as much precision as it holds.
+func (d PenDate) String() string {
+	switch {
+	case d.IsZero():
+		return ""
+	case d.Month == 0:
+		return fmt.Sprintf("%04d", d.Year)
+	case d.Day == 0:
+		return fmt.Sprintf("%04d-%02d", d.Year, int(d.Month))
+	default:
+		return fmt.Sprintf("%04d-%02d-%02d", d.Year, int(d.Month), d.Day)
+	}
+}
+
+// Time returns the first moment of the date, in UTC.
+func (d PenDate) Time() time.Time {
+	month, day := d.Month, d.Day
+	if month == 0 {
+		month = time.January
+	}
+	if day == 0 {
+		day = 1
+	}
+	return time.Date(d.Year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// Validate rejects dates in the future and before fountain pens were common.
+func (d PenDate) Validate() error {
+	if d.IsZero() {
+		return nil
+	}
+	if d.Year < 1800 {
+		return fmt.Errorf("year %d is too far in the past", d.Year)
+	}
+	if d.Time().After(time.Now()) {
+		return fmt.Errorf("%s is in the future", d)
+	}
+	return nil
+}
+
+// Scan implements sql.Scanner. The year column holds either an integer year or a date string.
+func (d *PenDate) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*d = PenDate{}
+		return nil
+	case int64:
+		*d = PenDate{Year: int(v)}
+		return nil
+	case float64:
+		*d = PenDate{Year: int(v)}
+		return nil
+	case []byte:
+		return d.Scan(string(v))
+	case string:
+		parsed, err := ParsePenDate(v)
+		if err != nil {
+			return err
+		}
+		*d = parsed
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into PenDate", src)
+}
+
+// Value implements driver.Valuer.
+func (d PenDate) Value() (driver.Value, error) {
+	if d.IsZero() {
+		return nil, nil
+	}
+	return d.String(), nil
+}
+
+// Money is a price stored as an integer number of minor units (cents, paise)
+// so that sums and comparisons are exact.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// parseMinorUnits parses a decimal amount such as "1,250.5" into minor units.
+func parseMinorUnits(s string) (int64, error) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	if s == "" {
+		return 0, nil
+	}
+
+	negative := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(s, "-")
+
+	whole, frac, _ := strings.Cut(digits, ".")
+	if whole == "" {
+		whole = "0"
+	}
+	if len(frac) > 2 {
+		return 0, fmt.Errorf("%q has more than two decimal places", s)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+
+	// ParseInt would accept a second sign, such as in "--5" or "-+5"
+	if !isDigits(whole) || !isDigits(frac) {
+		return 0, fmt.Errorf("%q is not a price", s)
+	}
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || units > (math.MaxInt64-99)/100 {
+		return 0, fmt.Errorf("%q is too large a price", s)
+	}
+	cents, _ := strconv.ParseInt(frac, 10, 64)
+
+	amount := units*100 + cents
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// isDigits reports whether s is made of ASCII digits only.
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// ParseMoney parses a decimal amount and an optional ISO 4217 currency code.
+func ParseMoney(amount, currency string) (Money, error) {
+	units, err := parseMinorUnits(amount)
+	if err != nil {
+		return Money{}, err
+	}
+	m := Money{Amount: units, Currency: strings.ToUpper(strings.TrimSpace(currency))}
+	return m, m.Validate()
+}
+
+// AmountString formats the amount with two decimal places and no currency.
+func (m Money) AmountString() string {
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
+
+// String formats the amount followed by the currency code, if known.
+func (m Money) String() string {
+	if m.Currency == "" {
+		return m.AmountString()
+	}
+	return m.AmountString() + " " + m.Currency
+}
+
+// Validate rejects negative prices and malformed currency codes.
+func (m Money) Validate() error {
+	if m.Amount < 0 {
+		return errors.New("price cannot be negative")
+	}
+	if m.Currency == "" {
+		return nil
+	}
+	if len(m.Currency) != 3 {
+		return fmt.Errorf("currency %q should be a three letter code like INR or USD", m.Currency)
+	}
+	for _, c := range m.Currency {
+		if c < 'A' || c > 'Z' {
+			return fmt.Errorf("currency %q should be a three letter code like INR or USD", m.Currency)
+		}
+	}
+	return nil
+}
+
+// Scan implements sql.Scanner for the price column, which holds minor
+// units. The currency is stored in its own column and is not touched.
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		m.Amount = 0
+		return nil
+	case int64:
+		m.Amount = v
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into Money", src)
+}
+
+// Value implements driver.Valuer, storing the amount as minor units in the
+// INTEGER price column.
+func (m Money) Value() (driver.Value, error) {
+	return m.Amount, nil
+}
+
+// legacyMinorUnits reads a price written before prices were stored as minor
+// units: a number of major units, or the text typed in the form.
+func legacyMinorUnits(src interface{}) (int64, error) {
+	switch v := src.(type) {
+	case int64:
+		if v > math.MaxInt64/100 || v < math.MinInt64/100 {
+			return 0, fmt.Errorf("%d is too large a price", v)
+		}
+		return v * 100, nil
+	case float64:
+		if math.IsNaN(v) || math.Abs(v) > math.MaxInt64/100 {
+			return 0, fmt.Errorf("%v is not a price", v)
+		}
+		return int64(math.Round(v * 100)), nil
+	case []byte:
+		return parseMinorUnits(string(v))
+	case string:
+		return parseMinorUnits(v)
+	}
+	return 0, fmt.Errorf("cannot read %T as a price", src)
+}
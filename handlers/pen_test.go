@@ -0,0 +1,34 @@
+// handlers/pen_test.go
+
+package handlers
+
+import "testing"
+
+func TestParseMinorUnits(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"120", 12000, false},
+		{"1,250.5", 125050, false},
+		{".99", 99, false},
+		{"-5", -500, false},
+		{"--5", 0, true},
+		{"-+5", 0, true},
+		{"+5", 0, true},
+		{"5.-1", 0, true},
+		{"$120", 0, true},
+		{"1.999", 0, true},
+		{"92233720368547758", 0, true},
+		{"92233720368547757", 9223372036854775700, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseMinorUnits(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseMinorUnits(%q) = %d, %v, want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
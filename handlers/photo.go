@@ -0,0 +1,229 @@
+// handlers/photo.go
+
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // Register the GIF decoder for image.Decode
+	"image/jpeg"
+	_ "image/png" // Register the PNG decoder for image.Decode
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// ErrPhotoNotFound is returned when a photo does not exist.
+var ErrPhotoNotFound = errors.New("photo not found")
+
+const (
+	// maxPhotoPixels guards against small files that decode into huge images.
+	maxPhotoPixels = 50_000_000
+	// thumbnailSize is the longest side of a thumbnail in pixels.
+	thumbnailSize = 240
+)
+
+var (
+	// maxPhotoSize is the largest photo that can be uploaded.
+	maxPhotoSize int64 = 10 << 20
+	// maxUploadSize is the largest add or modify form, photos included.
+	maxUploadSize int64 = 50 << 20
+)
+
+// SetUploadLimits sets the largest photo and the largest add or modify form
+// that can be uploaded, in bytes.
+func SetUploadLimits(photo, upload int64) {
+	maxPhotoSize = photo
+	maxUploadSize = upload
+}
+
+// photoExtensions maps the accepted content types to file extensions.
+var photoExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// Photo is an image attached to a pen.
+type Photo struct {
+	ID           int64
+	PenID        int64
+	FileName     string // name of the file on disk, thumbnails add thumbnailSuffix
+	OriginalName string
+	ContentType  string
+	Size         int64
+	UploadedAt   time.Time
+}
+
+// thumbnailSuffix is added to a photo's file name for its thumbnail.
+const thumbnailSuffix = "_thumb.jpg"
+
+// ThumbnailName is the name of the thumbnail file on disk.
+func (p Photo) ThumbnailName() string {
+	return p.FileName + thumbnailSuffix
+}
+
+// newPhotoFileName returns a random file name for a photo, so that file names
+// cannot be guessed and never collide.
+func newPhotoFileName(contentType string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b) + photoExtensions[contentType], nil
+}
+
+// NewPhoto checks an uploaded image and returns its metadata and thumbnail.
+// The content type is detected from the data, not trusted from the upload.
+func NewPhoto(penID int64, originalName string, data []byte) (Photo, []byte, error) {
+	if len(data) == 0 {
+		return Photo{}, nil, fmt.Errorf("%s is empty", originalName)
+	}
+	if int64(len(data)) > maxPhotoSize {
+		return Photo{}, nil, fmt.Errorf("%s is larger than %s", originalName, formatBytes(maxPhotoSize))
+	}
+
+	contentType := http.DetectContentType(data)
+	if _, ok := photoExtensions[contentType]; !ok {
+		return Photo{}, nil, fmt.Errorf("%s is not a JPEG, PNG or GIF image", originalName)
+	}
+
+	// Check the dimensions before decoding the whole image
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Photo{}, nil, fmt.Errorf("%s is not a valid image", originalName)
+	}
+	if config.Width*config.Height > maxPhotoPixels {
+		return Photo{}, nil, fmt.Errorf("%s is too large, please use a smaller image", originalName)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Photo{}, nil, fmt.Errorf("%s is not a valid image", originalName)
+	}
+
+	var thumb bytes.Buffer
+	if err := jpeg.Encode(&thumb, thumbnail(img, thumbnailSize), &jpeg.Options{Quality: 85}); err != nil {
+		return Photo{}, nil, err
+	}
+
+	fileName, err := newPhotoFileName(contentType)
+	if err != nil {
+		return Photo{}, nil, err
+	}
+
+	photo := Photo{
+		PenID:        penID,
+		FileName:     fileName,
+		OriginalName: filepath.Base(originalName),
+		ContentType:  contentType,
+		Size:         int64(len(data)),
+		UploadedAt:   time.Now(),
+	}
+	return photo, thumb.Bytes(), nil
+}
+
+// thumbnail scales img down so that its longest side is at most size pixels.
+// Each thumbnail pixel is the average of the source pixels it covers, which
+// keeps fine detail such as nib engravings from turning into noise.
+func thumbnail(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	dstW, dstH := srcW, srcH
+	if srcW > size || srcH > size {
+		if srcW >= srcH {
+			dstW, dstH = size, max(1, srcH*size/srcW)
+		} else {
+			dstW, dstH = max(1, srcW*size/srcH), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0 := bounds.Min.Y + y*srcH/dstH
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*srcH/dstH)
+		for x := 0; x < dstW; x++ {
+			x0 := bounds.Min.X + x*srcW/dstW
+			x1 := max(x0+1, bounds.Min.X+(x+1)*srcW/dstW)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// parseUploadForm parses a form that may carry photos. Plain forms are parsed as usual.
+func parseUploadForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	err := r.ParseMultipartForm(maxPhotoSize)
+	if err == http.ErrNotMultipart {
+		return r.ParseForm()
+	}
+	return err
+}
+
+// readPhotoUpload reads an uploaded file, refusing files above maxPhotoSize.
+func readPhotoUpload(header *multipart.FileHeader) ([]byte, error) {
+	if header.Size > maxPhotoSize {
+		return nil, fmt.Errorf("%s is larger than %s", header.Filename, formatBytes(maxPhotoSize))
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(io.LimitReader(file, maxPhotoSize+1))
+}
+
+// savePhotos stores the photos uploaded in the "photos" field of a pen form.
+// Every photo is checked before any is stored.
+func savePhotos(r *http.Request, userID, penID int64) error {
+	if r.MultipartForm == nil {
+		return nil
+	}
+
+	type upload struct {
+		photo       Photo
+		data, thumb []byte
+	}
+
+	var uploads []upload
+	for _, header := range r.MultipartForm.File["photos"] {
+		data, err := readPhotoUpload(header)
+		if err != nil {
+			return err
+		}
+		photo, thumb, err := NewPhoto(penID, header.Filename, data)
+		if err != nil {
+			return err
+		}
+		uploads = append(uploads, upload{photo, data, thumb})
+	}
+
+	name := penName(userID, penID)
+	for _, u := range uploads {
+		_, err := photoStore.AddPhoto(userID, u.photo, u.data, u.thumb, newActivity(r, ActivityInsert, ItemPhoto, 0, fmt.Sprintf("%s of %s", u.photo.OriginalName, name), nil, photoValues(u.photo, name)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
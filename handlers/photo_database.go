@@ -0,0 +1,159 @@
+// handlers/photo_database.go
+
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// photoSelectColumns is the column list scanned by scanPhoto.
+const photoSelectColumns = "id, pen_id, file_name, original_name, content_type, size, uploaded_at"
+
+// scanPhoto scans a row selected with photoSelectColumns.
+func scanPhoto(row rowScanner) (Photo, error) {
+	var p Photo
+	err := row.Scan(&p.ID, &p.PenID, &p.FileName, &p.OriginalName, &p.ContentType, &p.Size, &p.UploadedAt)
+	return p, err
+}
+
+// AttachmentDir is the directory holding the photos of a user.
+func (s *SQLiteStore) AttachmentDir(userID int64) string {
+	return filepath.Join(s.dir, "attachments", strconv.FormatInt(userID, 10))
+}
+
+// removePhotoFiles deletes the image and thumbnail of photos from disk.
+// Failures are logged, the database no longer refers to the files.
+func (s *SQLiteStore) removePhotoFiles(userID int64, photos []Photo) {
+	dir := s.AttachmentDir(userID)
+	for _, p := range photos {
+		for _, name := range []string{p.FileName, p.ThumbnailName()} {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				log.Printf("Error removing photo file %s of user %d: %s", name, userID, err)
+			}
+		}
+	}
+}
+
+// AddPhoto writes a photo and its thumbnail to the user's attachment directory
+// and records it in the user's database.
+func (s *SQLiteStore) AddPhoto(userID int64, photo Photo, data, thumbnail []byte, activities ...Activity) (int64, error) {
+	if !s.PenExists(userID, photo.PenID) {
+		return 0, ErrPenNotFound
+	}
+
+	// Write the files first so that a recorded photo always has its files
+	dir := s.AttachmentDir(userID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, photo.FileName), data, 0600); err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, photo.ThumbnailName()), thumbnail, 0600); err != nil {
+		s.removePhotoFiles(userID, []Photo{photo})
+		return 0, err
+	}
+
+	var id int64
+	err := s.changeWithActivity(userID, activities, func(tx *sql.Tx) error {
+		result, err := tx.Exec(`INSERT INTO photos (pen_id, file_name, original_name, content_type, size, uploaded_at)
+			VALUES (?, ?, ?, ?, ?, ?)`, photo.PenID, photo.FileName, photo.OriginalName, photo.ContentType, photo.Size, photo.UploadedAt)
+		if err != nil {
+			return err
+		}
+		id, err = result.LastInsertId()
+		setActivityItemID(activities, id)
+		return err
+	})
+	if err != nil {
+		s.removePhotoFiles(userID, []Photo{photo})
+		return 0, err
+	}
+	return id, nil
+}
+
+// queryPhotos runs a query selecting photoSelectColumns.
+func queryPhotos(userDB *sql.DB, query string, args ...interface{}) ([]Photo, error) {
+	rows, err := userDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var photos []Photo
+	for rows.Next() {
+		p, err := scanPhoto(rows)
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, p)
+	}
+	return photos, rows.Err()
+}
+
+// PenPhotos returns the photos of a pen, oldest first.
+func (s *SQLiteStore) PenPhotos(userID, penID int64) ([]Photo, error) {
+	userDB, err := s.openUserDB(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return queryPhotos(userDB, "SELECT "+photoSelectColumns+" FROM photos WHERE pen_id = ? ORDER BY id", penID)
+}
+
+// ReadPhoto returns a photo with the contents of its image or thumbnail file.
+func (s *SQLiteStore) ReadPhoto(userID, photoID int64, thumbnail bool) (Photo, []byte, error) {
+	userDB, err := s.openUserDB(userID)
+	if err != nil {
+		return Photo{}, nil, err
+	}
+
+	photo, err := scanPhoto(userDB.QueryRow("SELECT "+photoSelectColumns+" FROM photos WHERE id = ?", photoID))
+	if err == sql.ErrNoRows {
+		return Photo{}, nil, ErrPhotoNotFound
+	}
+	if err != nil {
+		return Photo{}, nil, err
+	}
+
+	name := photo.FileName
+	if thumbnail {
+		name = photo.ThumbnailName()
+	}
+	data, err := os.ReadFile(filepath.Join(s.AttachmentDir(userID), name))
+	if os.IsNotExist(err) {
+		return Photo{}, nil, ErrPhotoNotFound
+	}
+	return photo, data, err
+}
+
+// DeletePhoto removes a photo from the user's database and its files from disk.
+func (s *SQLiteStore) DeletePhoto(userID, photoID int64, activities ...Activity) error {
+	userDB, err := s.openUserDB(userID)
+	if err != nil {
+		return err
+	}
+
+	photo, err := scanPhoto(userDB.QueryRow("SELECT "+photoSelectColumns+" FROM photos WHERE id = ?", photoID))
+	if err == sql.ErrNoRows {
+		return ErrPhotoNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	err = s.changeWithActivity(userID, activities, func(tx *sql.Tx) error {
+		_, err := tx.Exec("DELETE FROM photos WHERE id = ?", photoID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	s.removePhotoFiles(userID, []Photo{photo})
+	return nil
+}
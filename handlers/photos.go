@@ -0,0 +1,92 @@
+// handlers/photos.go
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// ServePhoto sends a pen photo.
+func ServePhoto(w http.ResponseWriter, r *http.Request) {
+	servePhoto(w, r, false)
+}
+
+// ServeThumbnail sends the thumbnail of a pen photo.
+func ServeThumbnail(w http.ResponseWriter, r *http.Request) {
+	servePhoto(w, r, true)
+}
+
+// servePhoto sends the photo with the ID of the path, or its thumbnail.
+func servePhoto(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+	userID := currentUserID(r)
+
+	// Get the photo ID from the URL parameter
+	photoID, err := pathID(r)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	photo, data, err := photoStore.ReadPhoto(userID, photoID, thumbnail)
+	if err == ErrPhotoNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	contentType := photo.ContentType
+	if thumbnail {
+		contentType = "image/jpeg"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Write(data)
+}
+
+// RemovePhoto deletes a photo from the pen's gallery on the modify page.
+func RemovePhoto(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	// Get the photo ID from the URL parameter
+	photoID, err := pathID(r)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Invalid photo ID")
+		return
+	}
+
+	// The form says which pen page to go back to
+	penURL := "/dashboard"
+	if penID, err := strconv.ParseInt(r.FormValue("pen_id"), 10, 64); err == nil {
+		penURL = fmt.Sprintf("/modify/%d", penID)
+	}
+
+	// Keep the photo for the activity
+	photo, _, err := photoStore.ReadPhoto(userID, photoID, true)
+	if err == ErrPhotoNotFound {
+		RedirectWithError(w, r, penURL, err.Error())
+		return
+	}
+	if err != nil {
+		RedirectWithError(w, r, penURL, "Unable to delete the photo, please try again")
+		return
+	}
+
+	name := penName(userID, photo.PenID)
+	err = photoStore.DeletePhoto(userID, photoID, newActivity(r, ActivityDelete, ItemPhoto, photoID, fmt.Sprintf("%s of %s", photo.OriginalName, name), photoValues(photo, name), nil))
+	if err == ErrPhotoNotFound {
+		RedirectWithError(w, r, penURL, err.Error())
+		return
+	}
+	if err != nil {
+		RedirectWithError(w, r, penURL, "Unable to delete the photo, please try again")
+		return
+	}
+
+	http.Redirect(w, r, penURL, http.StatusSeeOther)
+}
@@ -0,0 +1,504 @@
+// handlers/qrcode.go
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// A minimal QR code encoder, enough to show the provisioning URI of an
+// authenticator app as an image: byte mode and error correction level M
+// only, following ISO/IEC 18004.
+
+// errQRTooLong is returned for data that doesn't fit in a version 40 symbol.
+var errQRTooLong = errors.New("qr code: data too long")
+
+const (
+	// qrScale is the size of a module in pixels.
+	qrScale = 4
+	// qrQuietZone is the light border around the symbol, in modules.
+	qrQuietZone = 4
+)
+
+// qrECCPerBlock and qrBlocks give, for each version at level M, the error
+// correction codewords of a block and the number of blocks. Index 0 is unused.
+var (
+	qrECCPerBlock = [41]int{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28}
+	qrBlocks      = [41]int{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49}
+)
+
+// qrCode is a QR code symbol, with modules[y][x] true for dark modules.
+type qrCode struct {
+	version  int
+	size     int
+	modules  [][]bool
+	function [][]bool // modules of the finder, timing and other patterns
+}
+
+// qrDataURI returns the QR code of text as a PNG data: URI.
+func qrDataURI(text string) (string, error) {
+	qr, err := encodeQR([]byte(text))
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, qr.image()); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// encodeQR encodes data in the smallest version that holds it.
+func encodeQR(data []byte) (*qrCode, error) {
+	version := 1
+	for ; version <= 40; version++ {
+		if qrDataBits(data, version) <= qrDataCodewords(version)*8 {
+			break
+		}
+	}
+	if version > 40 {
+		return nil, errQRTooLong
+	}
+
+	qr := &qrCode{version: version, size: version*4 + 17}
+	qr.modules = make([][]bool, qr.size)
+	qr.function = make([][]bool, qr.size)
+	for y := range qr.modules {
+		qr.modules[y] = make([]bool, qr.size)
+		qr.function[y] = make([]bool, qr.size)
+	}
+
+	qr.drawFunctionPatterns()
+	qr.drawCodewords(qr.addErrorCorrection(qr.dataCodewords(data)))
+
+	// Keep the mask that leaves the fewest patterns confusing readers
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormatBits(mask)
+		if penalty := qr.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		qr.applyMask(mask) // masks undo themselves
+	}
+	qr.applyMask(best)
+	qr.drawFormatBits(best)
+	return qr, nil
+}
+
+// qrCountBits is the length of the character count in byte mode.
+func qrCountBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+// qrDataBits is the length of data in byte mode, with its header.
+func qrDataBits(data []byte, version int) int {
+	return 4 + qrCountBits(version) + len(data)*8
+}
+
+// qrRawCodewords is the number of codewords a version holds, data and error
+// correction, after its function patterns.
+func qrRawCodewords(version int) int {
+	modules := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		modules -= (25*align-10)*align - 55
+		if version >= 7 {
+			modules -= 36
+		}
+	}
+	return modules / 8
+}
+
+// qrDataCodewords is the number of data codewords of a version at level M.
+func qrDataCodewords(version int) int {
+	return qrRawCodewords(version) - qrECCPerBlock[version]*qrBlocks[version]
+}
+
+// qrAlignmentPositions returns the centre coordinates of the alignment patterns.
+func qrAlignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	count := version/7 + 2
+	step := (version*8 + count*3 + 5) / (count*4 - 4) * 2
+	positions := make([]int, count)
+	positions[0] = 6
+	for i, pos := count-1, version*4+10; i > 0; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+	return positions
+}
+
+// dataCodewords encodes data in byte mode, with the terminator and padding.
+func (qr *qrCode) dataCodewords(data []byte) []byte {
+	capacity := qrDataCodewords(qr.version) * 8
+
+	var bits []bool
+	appendBits := func(value, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, value>>i&1 == 1)
+		}
+	}
+	appendBits(0x4, 4)
+	appendBits(len(data), qrCountBits(qr.version))
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+
+	terminator := capacity - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	appendBits(0, terminator)
+	appendBits(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		appendBits(pad, 8)
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			codewords[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return codewords
+}
+
+// addErrorCorrection splits the data codewords in blocks, adds the error
+// correction codewords of each and interleaves them.
+func (qr *qrCode) addErrorCorrection(data []byte) []byte {
+	blocks := qrBlocks[qr.version]
+	eccLen := qrECCPerBlock[qr.version]
+	raw := qrRawCodewords(qr.version)
+	shortBlocks := blocks - raw%blocks
+	shortLen := raw/blocks - eccLen
+
+	divisor := rsDivisor(eccLen)
+	dataBlocks := make([][]byte, blocks)
+	eccBlocks := make([][]byte, blocks)
+	for i, k := 0, 0; i < blocks; i++ {
+		n := shortLen
+		if i >= shortBlocks {
+			n++
+		}
+		dataBlocks[i] = data[k : k+n]
+		eccBlocks[i] = rsRemainder(dataBlocks[i], divisor)
+		k += n
+	}
+
+	result := make([]byte, 0, raw)
+	for i := 0; i <= shortLen; i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < eccLen; i++ {
+		for _, block := range eccBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+// setFunction sets a module of a function pattern.
+func (qr *qrCode) setFunction(x, y int, dark bool) {
+	qr.modules[y][x] = dark
+	qr.function[y][x] = true
+}
+
+// drawFunctionPatterns draws the finder, timing and alignment patterns and
+// the version, and reserves the format bits.
+func (qr *qrCode) drawFunctionPatterns() {
+	for i := 0; i < qr.size; i++ {
+		qr.setFunction(6, i, i%2 == 0)
+		qr.setFunction(i, 6, i%2 == 0)
+	}
+
+	for _, corner := range [][2]int{{3, 3}, {qr.size - 4, 3}, {3, qr.size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := corner[0]+dx, corner[1]+dy
+				if x < 0 || x >= qr.size || y < 0 || y >= qr.size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				qr.setFunction(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	positions := qrAlignmentPositions(qr.version)
+	last := len(positions) - 1
+	for i, cy := range positions {
+		for j, cx := range positions {
+			// The finders take these corners
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					qr.setFunction(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	qr.drawFormatBits(0)
+	qr.drawVersion()
+}
+
+// drawFormatBits draws both copies of the error correction level and mask.
+func (qr *qrCode) drawFormatBits(mask int) {
+	// Level M is 00, followed by the mask and a BCH code
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		qr.setFunction(8, i, bit(i))
+	}
+	qr.setFunction(8, 7, bit(6))
+	qr.setFunction(8, 8, bit(7))
+	qr.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		qr.setFunction(qr.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.setFunction(8, qr.size-15+i, bit(i))
+	}
+	qr.setFunction(8, qr.size-8, true)
+}
+
+// drawVersion draws both copies of the version, from version 7 on.
+func (qr *qrCode) drawVersion() {
+	if qr.version < 7 {
+		return
+	}
+	rem := qr.version
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1F25
+	}
+	bits := qr.version<<12 | rem
+
+	for i := 0; i < 18; i++ {
+		dark := bits>>i&1 == 1
+		a, b := qr.size-11+i%3, i/3
+		qr.setFunction(a, b, dark)
+		qr.setFunction(b, a, dark)
+	}
+}
+
+// drawCodewords places the codewords in the zigzag of two-module columns,
+// from the bottom right corner, around the function patterns.
+func (qr *qrCode) drawCodewords(codewords []byte) {
+	i := 0
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < qr.size; vert++ {
+			y := vert
+			if upward {
+				y = qr.size - 1 - vert
+			}
+			for x := right; x > right-2; x-- {
+				if qr.function[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				qr.modules[y][x] = codewords[i/8]>>(7-i%8)&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+// applyMask flips the modules outside the function patterns selected by
+// the mask pattern.
+func (qr *qrCode) applyMask(mask int) {
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !qr.function[y][x] {
+				qr.modules[y][x] = !qr.modules[y][x]
+			}
+		}
+	}
+}
+
+// qrFinderLike are the module sequences resembling a finder pattern.
+var qrFinderLike = [][]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+// penalty scores the symbol by the rules the standard uses to pick a mask.
+func (qr *qrCode) penalty() int {
+	at := func(x, y int, vertical bool) bool {
+		if vertical {
+			return qr.modules[x][y]
+		}
+		return qr.modules[y][x]
+	}
+
+	result := 0
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < qr.size; y++ {
+			// Runs of five or more modules of one colour
+			run := 1
+			for x := 1; x <= qr.size; x++ {
+				if x < qr.size && at(x, y, vertical) == at(x-1, y, vertical) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					result += run - 2
+				}
+				run = 1
+			}
+
+			// Patterns resembling a finder
+			for x := 0; x+11 <= qr.size; x++ {
+				for _, pattern := range qrFinderLike {
+					match := true
+					for k, dark := range pattern {
+						if at(x+k, y, vertical) != dark {
+							match = false
+							break
+						}
+					}
+					if match {
+						result += 40
+					}
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if qr.modules[y][x] {
+				dark++
+			}
+			// Blocks of two by two modules of one colour
+			if x > 0 && y > 0 {
+				c := qr.modules[y][x]
+				if qr.modules[y-1][x] == c && qr.modules[y][x-1] == c && qr.modules[y-1][x-1] == c {
+					result += 3
+				}
+			}
+		}
+	}
+
+	// Dark modules far from half of them
+	total := qr.size * qr.size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	result += k * 10
+	return result
+}
+
+// image draws the symbol with its quiet zone.
+func (qr *qrCode) image() image.Image {
+	side := (qr.size + 2*qrQuietZone) * qrScale
+	img := image.NewGray(image.Rect(0, 0, side, side))
+	for py := 0; py < side; py++ {
+		for px := 0; px < side; px++ {
+			x, y := px/qrScale-qrQuietZone, py/qrScale-qrQuietZone
+			c := color.Gray{Y: 0xff}
+			if x >= 0 && x < qr.size && y >= 0 && y < qr.size && qr.modules[y][x] {
+				c = color.Gray{Y: 0}
+			}
+			img.SetGray(px, py, c)
+		}
+	}
+	return img
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of the degree,
+// without its leading term, highest power first.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder returns the Reed-Solomon error correction codewords of data.
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMultiply(d, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+// abs returns the absolute value of n.
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
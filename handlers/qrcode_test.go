@@ -0,0 +1,148 @@
+// handlers/qrcode_test.go
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestRSRemainder(t *testing.T) {
+	// "HELLO WORLD" as version 1-M, from the worked example at thonky.com
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("rsRemainder = %v, want %v", got, want)
+	}
+}
+
+func TestEncodeQRVersion(t *testing.T) {
+	// Byte capacities of level M
+	for _, tt := range []struct {
+		length  int
+		version int
+	}{
+		{14, 1}, {15, 2}, {213, 10}, {214, 11}, {2331, 40},
+	} {
+		qr, err := encodeQR(bytes.Repeat([]byte("a"), tt.length))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", tt.length, err)
+		}
+		if qr.version != tt.version || qr.size != tt.version*4+17 {
+			t.Errorf("%d bytes: version %d, size %d, want version %d", tt.length, qr.version, qr.size, tt.version)
+		}
+	}
+
+	if _, err := encodeQR(bytes.Repeat([]byte("a"), 2332)); err != errQRTooLong {
+		t.Errorf("2332 bytes: err = %v, want errQRTooLong", err)
+	}
+}
+
+func TestEncodeQRLayout(t *testing.T) {
+	data := []byte(totpURI("tester", "JBSWY3DPEHPK3PXP"))
+	qr, err := encodeQR(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read the level and mask back from the first copy of the format bits
+	format := 0
+	for i, xy := range [][2]int{{8, 0}, {8, 1}, {8, 2}, {8, 3}, {8, 4}, {8, 5}, {8, 7}, {8, 8}, {7, 8}, {5, 8}, {4, 8}, {3, 8}, {2, 8}, {1, 8}, {0, 8}} {
+		if qr.modules[xy[1]][xy[0]] {
+			format |= 1 << i
+		}
+	}
+	format ^= 0x5412
+	if level := format >> 13; level != 0 {
+		t.Errorf("level bits = %b, want 00 for M", level)
+	}
+	mask := format >> 10 & 7
+
+	// Unmasked, the modules hold the codewords in their zigzag
+	qr.applyMask(mask)
+	want := qr.addErrorCorrection(qr.dataCodewords(data))
+	var got []byte
+	var b byte
+	n := 0
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < qr.size; vert++ {
+			y := vert
+			if (right+1)&2 == 0 {
+				y = qr.size - 1 - vert
+			}
+			for x := right; x > right-2; x-- {
+				if qr.function[y][x] || len(got) == len(want) {
+					continue
+				}
+				b = b<<1 | boolBit(qr.modules[y][x])
+				if n++; n%8 == 0 {
+					got = append(got, b)
+					b = 0
+				}
+			}
+		}
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("codewords read back = %v, want %v", got, want)
+	}
+}
+
+func TestDrawVersion(t *testing.T) {
+	qr, err := encodeQR(bytes.Repeat([]byte("a"), 110))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qr.version != 7 {
+		t.Fatalf("version = %d, want 7", qr.version)
+	}
+
+	// The version 7 information of the standard, read from bit 0
+	const want = 0x07C94
+	got := 0
+	for i := 0; i < 18; i++ {
+		if qr.modules[i/3][qr.size-11+i%3] {
+			got |= 1 << i
+		}
+	}
+	if got != want {
+		t.Errorf("version bits = %018b, want %018b", got, want)
+	}
+}
+
+func TestQRDataURI(t *testing.T) {
+	uri, err := qrDataURI("otpauth://totp/Flock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, ok := strings.CutPrefix(uri, "data:image/png;base64,")
+	if !ok {
+		t.Fatalf("uri = %q, want a PNG data URI", uri)
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Version 2 with the quiet zone
+	if side := (25 + 2*qrQuietZone) * qrScale; img.Bounds().Dx() != side || img.Bounds().Dy() != side {
+		t.Errorf("image is %v, want %d pixels square", img.Bounds(), side)
+	}
+}
+
+// boolBit returns 1 for true.
+func boolBit(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -5,8 +5,6 @@ package handlers
 import (
 	// "fmt"
 	// "html/template"
-	// "log"
-	"math/rand"
 	"net/http"
 	"strings"
 	"time"
@@ -14,12 +12,25 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// registrationOpen is whether anyone can register an account.
+var registrationOpen = true
+
+// SetRegistrationOpen opens or closes registration of new accounts.
+func SetRegistrationOpen(open bool) {
+	registrationOpen = open
+}
+
 // HandleRegister handles user registration
 func Register(w http.ResponseWriter, r *http.Request) {
+	if !registrationOpen {
+		RedirectWithError(w, r, "/login", "Registration is closed")
+		return
+	}
+
 	// Define data at the beginning
 	var data struct {
 		CaptchaQuestion string
-		CaptchaAnswer   string
+		CaptchaToken    string
 		Error           string
 		RedirectURL     string
 	}
@@ -33,6 +44,17 @@ func Register(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Check the CAPTCHA before doing any work for the registration
+		if captcha == nil {
+			RedirectWithError(w, r, "/register", errNoCaptchaBank.Error())
+			return
+		}
+		err = captcha.Verify(clientAddress(r), r.FormValue("captchaToken"), r.FormValue("captchaAnswer"))
+		if err != nil {
+			RedirectWithError(w, r, "/register", err.Error())
+			return
+		}
+
 		// Extract user details from the form
 		username := r.FormValue("username")
 		firstName := r.FormValue("firstName")
@@ -42,6 +64,11 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		password := r.FormValue("password")
 		bio := r.FormValue("bio")
 
+		if err := validateUsername(username); err != nil {
+			RedirectWithError(w, r, "/register", err.Error())
+			return
+		}
+
 		// Hash the password before storing it
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 		if err != nil {
@@ -50,7 +77,7 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Insert the user into the database
-		userID, err := InsertUser(username, firstName, middleName, lastName, email, hashedPassword, bio)
+		userID, err := userStore.InsertUser(username, firstName, middleName, lastName, email, hashedPassword, bio)
 		if err != nil {
 			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
 				RedirectWithError(w, r, "/register", "Username or email already exists")
@@ -61,25 +88,51 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Create or update the user's pens database
-		_, err = CreateOrUpdateUserDB(userID)
+		err = penStore.CreateUserDB(userID)
 		if err != nil {
 			RedirectWithError(w, r, "/register", "Error creating or updating user's pens database")
 			return
 		}
 
-		// Redirect to the login page or dashboard
-		SetUserIDInSession(w, r, userID) // Set the user session
-		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		// Without verification the address is trusted as given
+		if !emailVerification {
+			if err := userStore.MarkEmailVerified(userID, time.Now()); err != nil {
+				requestLogger(r).Error("Error marking a new user verified", "target_id", userID, "error", err)
+			}
+			SetUserIDInSession(w, r, userID) // Set the user session
+			http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+			return
+		}
+
+		// The account stays pending until the emailed link is opened
+		SetUserIDInSession(w, r, userID)
+		u, err := userStore.GetUserByID(userID)
+		if err == nil {
+			err = sendVerificationEmail(u)
+		}
+		if err != nil {
+			requestLogger(r).Error("Error sending verification email", "target_id", userID, "error", err)
+			RedirectWithError(w, r, "/verify", "Unable to send the verification email, please ask for a new one")
+			return
+		}
+		http.Redirect(w, r, "/verify", http.StatusSeeOther)
 		return
 	}
 
-	// Generate a random CAPTCHA question
-	question, answer := generateRandomCaptcha()
+	// Issue a CAPTCHA question, its answers stay on the server
+	if captcha == nil {
+		http.Error(w, errNoCaptchaBank.Error(), http.StatusInternalServerError)
+		return
+	}
+	question, token, err := captcha.Issue()
+	if err != nil {
+		http.Error(w, "Unable to create a CAPTCHA, please try later", http.StatusInternalServerError)
+		return
+	}
 
 	// Assign values to data
 	data.CaptchaQuestion = question
-	data.CaptchaAnswer = answer
-
+	data.CaptchaToken = token
 
 	// Check if there's any error message or redirection URL in the query parameters
 	queryParams := r.URL.Query()
@@ -93,49 +146,3 @@ func Register(w http.ResponseWriter, r *http.Request) {
 	// Render the registration form with CAPTCHA question and potential error
 	renderTemplate(w, "register", data)
 }
-
-// generateRandomCaptcha generates a random CAPTCHA question and answer.
-func generateRandomCaptcha() (question, answer string) {
-	// List of 15 sample questions with corresponding answers
-	questionsAndAnswers := map[string]string{
-		"What is the purpose of the breather hole on a nib?":                                                           "regulation",
-		"What is a common material for vintage pen bodies?":                                                            "ebonite",
-		"What is the common name for the liquid used by fountain pen?":                                                 "ink",
-		"Are demonstrators transparent or opaque?":                                                                     "transparent",
-		"What is the common and cheap nib tipping material?":                                                           "iridium",
-		"What is the name for a filling system which has an empty barrel?":                                             "eyedropper",
-		"Which is more water-resistant ink: pigment or dye?":                                                           "pigment",
-		"Why do you need a feed?":                                                                                      "regulation",
-		"Which brand of pens has 'Safari' and 'Al-Star' pens?":                                                         "lamy",
-		"What is the difference between flex and stub nib?":                                                            "flexibility",
-		"Which amongst gold and steel nibs flexes more?":                                                               "gold",
-		"What is phenomena when the nib flexes and only provides two parallel lines instead of a filled stroke?":       "railroad",
-		"Which country has brands/companies such as Pilot, Platinum, etc.?":                                            "japan",
-		"What is it called when you fix the cap of the pen to the barrel when you write?":                              "post",
-		"What is the name of the part holding ink and connecting to the nib?":                                          "reservoir",
-	}
-	// Seed the random number generator
-	rand.Seed(time.Now().UnixNano())
-
-	// Select a random question
-	randomIndex := rand.Intn(len(questionsAndAnswers))
-	i := 0
-	for q, a := range questionsAndAnswers {
-		if i == randomIndex {
-			question = q
-			answer = a
-			break
-		}
-		i++
-	}
-
-	// Use defer to ensure that the response is closed before leaving the function
-	defer func() {
-		if err := recover(); err != nil {
-			// Handle the panic and log the error
-			// log.Println("Panic in generateRandomCaptcha:", err)
-		}
-	}()
-
-	return question, answer
-}
@@ -0,0 +1,31 @@
+// handlers/register_test.go
+
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestRegisterChecksUsername(t *testing.T) {
+	c := newTestClient(t)
+	defer func(saved *Captcha) { captcha = saved }(captcha)
+	captcha = newTestCaptcha(t)
+
+	_, token, err := captcha.Issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{
+		"username": {" reset tester@example.com"}, "firstName": {"Eve"}, "lastName": {"Doe"},
+		"email": {"eve@example.com"}, "password": {"a long enough password"},
+		"captchaToken": {token}, "captchaAnswer": {"ink"},
+	}
+	w := c.do("/register", Register, http.MethodPost, "/register", form)
+	wantRedirect(t, w, "/register?error=the+username+can%27t+start+or+end+with+spaces")
+
+	if _, err := c.store.GetUserIDByUsername(" reset tester@example.com"); err != ErrUserNotFound {
+		t.Errorf("err = %v, want ErrUserNotFound", err)
+	}
+}
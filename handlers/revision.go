@@ -0,0 +1,77 @@
+// handlers/revision.go
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// penFromValues builds a pen from the values kept by its activity.
+func penFromValues(values map[string]string) (Pen, error) {
+	record := make([]string, len(PenColumns))
+	for i, col := range PenColumns {
+		record[i] = values[col]
+	}
+	return PenFromRecord(PenColumns, record)
+}
+
+// RevertPen sets a pen back to its values before one of its modifications,
+// as recorded in its activity. The revert is itself recorded as a
+// modification, so it can be reverted too.
+func RevertPen(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	penID, err := pathID(r)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Invalid pen ID")
+		return
+	}
+	modifyURL := fmt.Sprintf("/modify/%d", penID)
+
+	revisionID, err := strconv.ParseInt(PathValue(r, "revision"), 10, 64)
+	if err != nil {
+		RedirectWithError(w, r, modifyURL, "Please pick a change from the history of your pen")
+		return
+	}
+
+	current, err := penStore.GetPenByID(userID, penID)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Doesn't look like the pen exists anymore")
+		return
+	}
+
+	revisions, err := activityStore.PenRevisions(userID, penID)
+	if err != nil {
+		RedirectWithError(w, r, modifyURL, "Unable to get the history of your pen, please try later")
+		return
+	}
+
+	var found bool
+	var rev Activity
+	for _, rev = range revisions {
+		if rev.ID == revisionID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		RedirectWithError(w, r, modifyURL, "This change isn't in the history of your pen")
+		return
+	}
+
+	pen, err := penFromValues(rev.Before)
+	if err != nil {
+		RedirectWithError(w, r, modifyURL, fmt.Sprintf("Unable to revert your pen: %v", err))
+		return
+	}
+	pen.ID = penID
+
+	if err := penStore.UpdatePen(userID, pen, newActivity(r, ActivityUpdate, ItemPen, penID, pen.Name, penValues(current), penValues(pen))); err != nil {
+		RedirectWithError(w, r, modifyURL, "Error reverting pen")
+		return
+	}
+
+	http.Redirect(w, r, modifyURL, http.StatusSeeOther)
+}
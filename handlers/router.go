@@ -0,0 +1,215 @@
+// handlers/router.go
+
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Middleware wraps a handler with behaviour shared by many routes.
+type Middleware func(http.Handler) http.Handler
+
+// Chain applies middleware to h, the first one being the outermost.
+func Chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// Router sends requests to the route matching their method and path.
+//
+// Patterns are an optional method and a path, such as "GET /modify/{id}".
+// A {name} segment matches any single segment and a final {name...} segment
+// matches the rest of the path; both are read back with PathValue. Routes
+// without a method answer every method, and GET routes also answer HEAD.
+// When several routes match, the one with the most literal segments wins.
+type Router struct {
+	routes     []route
+	middleware []Middleware
+
+	// NotFound answers paths matching no route, http.NotFound by default.
+	NotFound http.Handler
+	// MethodNotAllowed answers paths whose routes don't accept the method,
+	// after the Allow header is set. A plain 405 error by default.
+	MethodNotAllowed http.Handler
+}
+
+// route is a pattern registered with its handler.
+type route struct {
+	method   string
+	segments []string
+	handler  http.Handler
+}
+
+// NewRouter returns an empty router.
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Use adds middleware wrapping every request the router serves, unmatched
+// ones included. Middleware added first runs first.
+func (rt *Router) Use(middleware ...Middleware) {
+	rt.middleware = append(rt.middleware, middleware...)
+}
+
+// Handle registers the handler for the pattern, wrapped with the route's own
+// middleware after the router's.
+func (rt *Router) Handle(pattern string, h http.Handler, middleware ...Middleware) {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		method, path = "", pattern
+	}
+	if !strings.HasPrefix(path, "/") {
+		panic("handlers: route pattern " + pattern + " should start with /")
+	}
+
+	rt.routes = append(rt.routes, route{
+		method:   method,
+		segments: strings.Split(path, "/")[1:],
+		handler:  Chain(h, middleware...),
+	})
+
+	// Keep the most specific routes first, in registration order otherwise
+	sort.SliceStable(rt.routes, func(i, j int) bool {
+		return rt.routes[i].literals() > rt.routes[j].literals()
+	})
+}
+
+// HandleFunc registers the handler function for the pattern.
+func (rt *Router) HandleFunc(pattern string, h http.HandlerFunc, middleware ...Middleware) {
+	rt.Handle(pattern, h, middleware...)
+}
+
+// ServeHTTP implements http.Handler.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	Chain(http.HandlerFunc(rt.dispatch), rt.middleware...).ServeHTTP(w, r)
+}
+
+// dispatch calls the handler of the best matching route.
+func (rt *Router) dispatch(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(r.URL.Path, "/")[1:]
+
+	var allowed []string
+	for _, rte := range rt.routes {
+		params, ok := rte.match(segments)
+		if !ok {
+			continue
+		}
+		if !rte.allows(r.Method) {
+			allowed = append(allowed, rte.method)
+			continue
+		}
+
+		if len(params) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), pathParamsKey, params))
+		}
+		rte.handler.ServeHTTP(w, r)
+		return
+	}
+
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowedMethods(allowed), ", "))
+		if rt.MethodNotAllowed != nil {
+			rt.MethodNotAllowed.ServeHTTP(w, r)
+			return
+		}
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if rt.NotFound != nil {
+		rt.NotFound.ServeHTTP(w, r)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// literals counts the fixed segments of the route's pattern.
+func (rte route) literals() int {
+	n := 0
+	for _, s := range rte.segments {
+		if _, ok := paramName(s); !ok {
+			n++
+		}
+	}
+	return n
+}
+
+// allows reports whether the route answers the method.
+func (rte route) allows(method string) bool {
+	return rte.method == "" || rte.method == method || (rte.method == http.MethodGet && method == http.MethodHead)
+}
+
+// match returns the path parameters when the path segments match the route.
+func (rte route) match(segments []string) (map[string]string, bool) {
+	params := make(map[string]string)
+	for i, pattern := range rte.segments {
+		name, isParam := paramName(pattern)
+		if rest, ok := strings.CutSuffix(name, "..."); isParam && ok {
+			params[rest] = strings.Join(segments[min(i, len(segments)):], "/")
+			return params, true
+		}
+
+		switch {
+		case i >= len(segments):
+			return nil, false
+		case isParam && segments[i] != "":
+			params[name] = segments[i]
+		case pattern != segments[i]:
+			return nil, false
+		}
+	}
+	return params, len(segments) == len(rte.segments)
+}
+
+// paramName returns the name of a {name} pattern segment.
+func paramName(segment string) (string, bool) {
+	if len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}' {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+// allowedMethods lists the methods of the Allow header, adding HEAD to GET.
+func allowedMethods(methods []string) []string {
+	seen := make(map[string]bool)
+	var allowed []string
+	add := func(m string) {
+		if !seen[m] {
+			seen[m] = true
+			allowed = append(allowed, m)
+		}
+	}
+	for _, m := range methods {
+		add(m)
+		if m == http.MethodGet {
+			add(http.MethodHead)
+		}
+	}
+	return allowed
+}
+
+// contextKey keys the values the router and its middleware add to requests.
+type contextKey int
+
+const (
+	pathParamsKey contextKey = iota
+	userKey
+	requestInfoKey
+)
+
+// PathValue returns the value of the {name} segment of the matched route.
+func PathValue(r *http.Request, name string) string {
+	params, _ := r.Context().Value(pathParamsKey).(map[string]string)
+	return params[name]
+}
+
+// pathID returns the {id} segment of the matched route as a number.
+func pathID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(PathValue(r, "id"), 10, 64)
+}
@@ -0,0 +1,157 @@
+// handlers/router_test.go
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// named answers with its name and the path values asked for, so tests can
+// tell which route served a request.
+func named(name string, params ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, name)
+		for _, p := range params {
+			fmt.Fprintf(w, " %s=%s", p, PathValue(r, p))
+		}
+	}
+}
+
+func TestRouterMatching(t *testing.T) {
+	rt := NewRouter()
+	rt.HandleFunc("GET /", named("index"))
+	rt.HandleFunc("GET /dashboard", named("dashboard"))
+	rt.HandleFunc("GET /modify/{id}", named("modify form", "id"))
+	rt.HandleFunc("POST /modify/{id}", named("modify", "id"))
+	rt.HandleFunc("GET /modify/{id}/revert/{revision}", named("revert", "id", "revision"))
+	rt.HandleFunc("GET /{section}/new", named("new", "section"))
+	rt.HandleFunc("GET /inks/new", named("new ink"))
+	rt.HandleFunc("/any", named("any"))
+	rt.HandleFunc("/files/{path...}", named("files", "path"))
+
+	tests := []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{"GET", "/", 200, "index"},
+		{"GET", "/dashboard", 200, "dashboard"},
+		{"HEAD", "/dashboard", 200, "dashboard"}, // net/http drops the body
+		{"GET", "/modify/7", 200, "modify form id=7"},
+		{"POST", "/modify/7", 200, "modify id=7"},
+		{"GET", "/modify/7/revert/3", 200, "revert id=7 revision=3"},
+		{"GET", "/modify/", 404, ""},
+		{"GET", "/modify/7/", 404, ""},
+		{"GET", "/pens/new", 200, "new section=pens"},
+		{"GET", "/inks/new", 200, "new ink"}, // more literal segments win
+		{"DELETE", "/any", 200, "any"},
+		{"GET", "/files/a/b.txt", 200, "files path=a/b.txt"},
+		{"GET", "/files/", 200, "files path="},
+		{"GET", "/nowhere", 404, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rt.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.code {
+				t.Fatalf("status = %d, want %d", w.Code, tt.code)
+			}
+			if tt.code == 200 && w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body, tt.body)
+			}
+		})
+	}
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	rt := NewRouter()
+	rt.HandleFunc("GET /modify/{id}", named("modify form"))
+	rt.HandleFunc("POST /modify/{id}", named("modify"))
+	rt.HandleFunc("DELETE /modify/{id}", named("delete"))
+
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/modify/1", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, POST, DELETE" {
+		t.Errorf("Allow = %q, want %q", allow, "GET, HEAD, POST, DELETE")
+	}
+}
+
+func TestRouterCustomErrors(t *testing.T) {
+	rt := NewRouter()
+	rt.HandleFunc("POST /pens", named("add"))
+	rt.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "custom not found", http.StatusNotFound)
+	})
+	rt.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "custom 405, allowed "+w.Header().Get("Allow"), http.StatusMethodNotAllowed)
+	})
+
+	tests := []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{"GET", "/missing", http.StatusNotFound, "custom not found"},
+		{"GET", "/pens", http.StatusMethodNotAllowed, "custom 405, allowed POST"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.code || strings.TrimSpace(w.Body.String()) != tt.body {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.path, w.Code, w.Body, tt.code, tt.body)
+		}
+	}
+}
+
+func TestRouterMiddlewareOrder(t *testing.T) {
+	var calls []string
+	mark := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	rt := NewRouter()
+	rt.Use(mark("first"), mark("second"))
+	rt.HandleFunc("GET /pens", func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	}, mark("route"), mark("route second"))
+	rt.Use(mark("third"))
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/pens", "first second third route route second handler"},
+		{"/missing", "first second third"}, // the router's middleware wraps unmatched requests too
+	}
+
+	for _, tt := range tests {
+		calls = nil
+		rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if got := strings.Join(calls, " "); got != tt.want {
+			t.Errorf("%s: calls = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRouterRejectsRelativePattern(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("HandleFunc accepted a pattern without a leading /")
+		}
+	}()
+	NewRouter().HandleFunc("GET pens", named("pens"))
+}
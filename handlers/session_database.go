@@ -0,0 +1,95 @@
+// handlers/session_database.go
+
+package handlers
+
+import (
+	"database/sql"
+	"time"
+)
+
+// sessionSelectColumns is the column list scanned by scanSession.
+const sessionSelectColumns = "id, user_id, data, user_agent, created_at, last_seen_at, expires_at"
+
+// scanSession scans a row selected with sessionSelectColumns.
+func scanSession(row rowScanner) (UserSession, error) {
+	var s UserSession
+	var userID sql.NullInt64
+	err := row.Scan(&s.ID, &userID, &s.Data, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt)
+	s.UserID = userID.Int64
+	return s, err
+}
+
+// SaveSession inserts or updates a server-side session. The creation time of
+// an existing session is kept.
+func (s *SQLiteStore) SaveSession(session UserSession) error {
+	var userID interface{}
+	if session.UserID != 0 {
+		userID = session.UserID
+	}
+
+	_, err := s.db.Exec(`INSERT INTO sessions (id, user_id, data, user_agent, created_at, last_seen_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET user_id = excluded.user_id, data = excluded.data,
+			user_agent = excluded.user_agent, last_seen_at = excluded.last_seen_at, expires_at = excluded.expires_at`,
+		session.ID, userID, session.Data, session.UserAgent, session.CreatedAt, session.LastSeenAt, session.ExpiresAt)
+	return err
+}
+
+// GetSession returns a session that has not expired.
+func (s *SQLiteStore) GetSession(id string) (UserSession, error) {
+	session, err := scanSession(s.db.QueryRow("SELECT "+sessionSelectColumns+" FROM sessions WHERE id = ? AND expires_at > ?", id, time.Now()))
+	if err == sql.ErrNoRows {
+		return UserSession{}, ErrSessionNotFound
+	}
+	return session, err
+}
+
+// TouchSession records that a session was used.
+func (s *SQLiteStore) TouchSession(id string, at time.Time) error {
+	_, err := s.db.Exec("UPDATE sessions SET last_seen_at = ? WHERE id = ?", at, id)
+	return err
+}
+
+// DeleteSession removes a session, logging its browser out.
+func (s *SQLiteStore) DeleteSession(id string) error {
+	result, err := s.db.Exec("DELETE FROM sessions WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// ListSessions returns the user's sessions that have not expired, most recently used first.
+func (s *SQLiteStore) ListSessions(userID int64) ([]UserSession, error) {
+	rows, err := s.db.Query("SELECT "+sessionSelectColumns+" FROM sessions WHERE user_id = ? AND expires_at > ? ORDER BY last_seen_at DESC", userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []UserSession
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteUserSessions removes every session of the user except keepID.
+func (s *SQLiteStore) DeleteUserSessions(userID int64, keepID string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE user_id = ? AND id != ?", userID, keepID)
+	return err
+}
+
+// DeleteExpiredSessions removes the sessions that expired before now.
+func (s *SQLiteStore) DeleteExpiredSessions(now time.Time) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE expires_at <= ?", now)
+	return err
+}
@@ -0,0 +1,124 @@
+// handlers/session_keys.go
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// SessionKeyPair signs (HashKey) and encrypts (BlockKey) session cookies.
+type SessionKeyPair struct {
+	HashKey   []byte    `json:"hash_key"`
+	BlockKey  []byte    `json:"block_key"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// sessionKeysFile is the file format holding the session keys, newest first.
+// The newest pair encodes new cookies, the older pairs still decode cookies
+// issued before the last rotation.
+type sessionKeysFile struct {
+	Keys []SessionKeyPair `json:"keys"`
+}
+
+// newSessionKeyPair generates a random key pair.
+func newSessionKeyPair() (SessionKeyPair, error) {
+	pair := SessionKeyPair{
+		HashKey:   securecookie.GenerateRandomKey(64),
+		BlockKey:  securecookie.GenerateRandomKey(32),
+		CreatedAt: time.Now().UTC(),
+	}
+	if pair.HashKey == nil || pair.BlockKey == nil {
+		return SessionKeyPair{}, errors.New("unable to generate session keys")
+	}
+	return pair, nil
+}
+
+// writeSessionKeys saves the keys, readable only by the owner.
+func writeSessionKeys(path string, keys []SessionKeyPair) error {
+	data, err := json.MarshalIndent(sessionKeysFile{Keys: keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a crash never leaves half a key file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadSessionKeys reads the session keys from path, creating the file with a
+// new key pair if it does not exist yet, so that sessions survive restarts.
+func LoadSessionKeys(path string) ([]SessionKeyPair, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		pair, err := newSessionKeyPair()
+		if err != nil {
+			return nil, err
+		}
+		keys := []SessionKeyPair{pair}
+		return keys, writeSessionKeys(path, keys)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file sessionKeysFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("session keys %s: %w", path, err)
+	}
+	if len(file.Keys) == 0 {
+		return nil, fmt.Errorf("session keys %s: no keys", path)
+	}
+	for i, pair := range file.Keys {
+		if len(pair.HashKey) < 32 {
+			return nil, fmt.Errorf("session keys %s: hash key %d is shorter than 32 bytes", path, i+1)
+		}
+		if n := len(pair.BlockKey); n != 16 && n != 24 && n != 32 {
+			return nil, fmt.Errorf("session keys %s: block key %d should be 16, 24 or 32 bytes", path, i+1)
+		}
+	}
+	return file.Keys, nil
+}
+
+// RotateSessionKeys adds a new key pair in front of the keys in path and keeps
+// at most keep of the older pairs, so that existing sessions stay valid until
+// they are rotated out.
+func RotateSessionKeys(path string, keep int) ([]SessionKeyPair, error) {
+	keys, err := LoadSessionKeys(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := newSessionKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) > keep {
+		keys = keys[:keep]
+	}
+	keys = append([]SessionKeyPair{pair}, keys...)
+	return keys, writeSessionKeys(path, keys)
+}
+
+// sessionKeyPairs flattens the keys into the hash and block key arguments of
+// sessions.NewCookieStore and securecookie.CodecsFromPairs.
+func sessionKeyPairs(keys []SessionKeyPair) [][]byte {
+	pairs := make([][]byte, 0, 2*len(keys))
+	for _, pair := range keys {
+		pairs = append(pairs, pair.HashKey, pair.BlockKey)
+	}
+	return pairs
+}
@@ -0,0 +1,145 @@
+// handlers/session_store.go
+
+package handlers
+
+import (
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// ErrSessionNotFound is returned when a server-side session does not exist or has expired.
+var ErrSessionNotFound = errors.New("session not found")
+
+// sessionTouchInterval is how often the last seen time of a session is updated.
+const sessionTouchInterval = 5 * time.Minute
+
+// UserSession is a browser session kept on the server, so that it can be
+// listed and revoked.
+type UserSession struct {
+	ID         string
+	UserID     int64
+	Data       string // the encoded session values
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
+
+// DBSessionStore is a sessions.Store keeping session values in a
+// SessionStore. The cookie only holds the signed and encrypted session ID.
+type DBSessionStore struct {
+	Codecs   []securecookie.Codec
+	Options  *sessions.Options
+	sessions SessionStore
+}
+
+// NewDBSessionStore returns a session store saving sessions in backend,
+// with cookies protected by the given keys.
+func NewDBSessionStore(backend SessionStore, keyPairs ...[]byte) *DBSessionStore {
+	s := &DBSessionStore{
+		Codecs:   securecookie.CodecsFromPairs(keyPairs...),
+		Options:  defaultSessionOptions(),
+		sessions: backend,
+	}
+	s.MaxAge(s.Options.MaxAge)
+	return s
+}
+
+// MaxAge sets the lifetime of new sessions and of the cookies holding them.
+func (s *DBSessionStore) MaxAge(age int) {
+	s.Options.MaxAge = age
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+// Get returns a session for the given name after adding it to the registry.
+func (s *DBSessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session named in the request cookie, or a new session.
+func (s *DBSessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...); err != nil {
+		return session, err
+	}
+
+	stored, err := s.sessions.GetSession(session.ID)
+	if err != nil {
+		// The session was revoked or has expired, start over
+		session.ID = ""
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, stored.Data, &session.Values, s.Codecs...); err != nil {
+		session.ID = ""
+		return session, err
+	}
+	session.IsNew = false
+
+	// Keep the last seen time roughly up to date without a write per request
+	if now := time.Now(); now.Sub(stored.LastSeenAt) > sessionTouchInterval {
+		s.sessions.TouchSession(session.ID, now)
+	}
+	return session, nil
+}
+
+// Save stores the session and sets its cookie, or deletes both when the
+// session's MaxAge is not positive.
+func (s *DBSessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			if err := s.sessions.DeleteSession(session.ID); err != nil && err != ErrSessionNotFound {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(securecookie.GenerateRandomKey(32))
+	}
+
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	userID, _ := session.Values["userID"].(int64)
+	err = s.sessions.SaveSession(UserSession{
+		ID:         session.ID,
+		UserID:     userID,
+		Data:       data,
+		UserAgent:  r.UserAgent(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(time.Duration(session.Options.MaxAge) * time.Second),
+	})
+	if err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
@@ -0,0 +1,313 @@
+// handlers/store.go
+
+package handlers
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrPenNotFound is returned when a pen does not exist in a user's collection.
+var ErrPenNotFound = errors.New("pen not found")
+
+// ErrInkNotFound is returned when an ink does not exist in a user's collection.
+var ErrInkNotFound = errors.New("ink not found")
+
+// ErrUserNotFound is returned when no user matches the lookup.
+var ErrUserNotFound = errors.New("user not found")
+
+// PenStore persists each user's collection of pens.
+type PenStore interface {
+	// CreateUserDB prepares the storage for a newly registered user.
+	CreateUserDB(userID int64) error
+	// SelectPens returns every pen of the user, but those in the trash.
+	SelectPens(userID int64) ([]Pen, error)
+	// GetPenByID returns a single pen, or ErrPenNotFound.
+	GetPenByID(userID, penID int64) (Pen, error)
+	// InsertPen adds a pen and returns its new ID, which the activities get
+	// as their item ID.
+	InsertPen(userID int64, pen Pen, activities ...Activity) (int64, error)
+	// InsertPens adds several pens at once, either all of them or none, and
+	// returns their new IDs. activities[i] records pens[i] and gets its ID.
+	InsertPens(userID int64, pens []Pen, activities ...Activity) ([]int64, error)
+	// UpdatePen replaces the values of the existing pen with pen.ID. Its
+	// activity, with the values replaced, is the revision of the pen.
+	UpdatePen(userID int64, pen Pen, activities ...Activity) error
+	// DeletePenByID moves a pen to the trash, where the other methods don't see it.
+	DeletePenByID(userID, penID int64, activities ...Activity) error
+	// DeletedPens returns the pens in the trash, last deleted first.
+	DeletedPens(userID int64) ([]Pen, error)
+	// RestorePen takes a pen out of the trash, or returns ErrPenNotFound.
+	RestorePen(userID, penID int64, activities ...Activity) error
+	// PurgePen removes a pen for good, in the trash or not, with its history and photos.
+	PurgePen(userID, penID int64, activities ...Activity) error
+	// PurgeDeletedPens removes for good the pens moved to the trash before
+	// the given time and returns how many there were.
+	PurgeDeletedPens(userID int64, before time.Time) (int, error)
+	// PenExists reports whether the pen is part of the user's collection.
+	PenExists(userID, penID int64) bool
+}
+
+// InkStore persists each user's collection of inks.
+type InkStore interface {
+	// SelectInks returns every ink of the user.
+	SelectInks(userID int64) ([]Ink, error)
+	// GetInkByID returns a single ink, or ErrInkNotFound.
+	GetInkByID(userID, inkID int64) (Ink, error)
+	// InsertInk adds an ink and returns its new ID, which the activities get
+	// as their item ID.
+	InsertInk(userID int64, ink Ink, activities ...Activity) (int64, error)
+	// InsertInks adds several inks at once, either all of them or none.
+	// activities[i] records inks[i] and gets its ID.
+	InsertInks(userID int64, inks []Ink, activities ...Activity) error
+	// UpdateInk replaces the values of the existing ink with ink.ID.
+	UpdateInk(userID int64, ink Ink, activities ...Activity) error
+	// DeleteInkByID removes an ink from the collection.
+	DeleteInkByID(userID, inkID int64, activities ...Activity) error
+}
+
+// InkingStore persists which ink is in which pen and the history of inkings.
+type InkingStore interface {
+	// StartInking fills a pen with an ink, or returns ErrPenAlreadyInked. The
+	// activities get the ID of the new inking.
+	StartInking(userID, penID, inkID int64, at time.Time, notes string, activities ...Activity) (int64, error)
+	// EndInking cleans a pen, or returns ErrPenNotInked.
+	EndInking(userID, penID int64, at time.Time, activities ...Activity) error
+	// CurrentInkings returns every pen that is inked right now.
+	CurrentInkings(userID int64) ([]Inking, error)
+	// InkingHistory returns every inking of a pen, newest first.
+	InkingHistory(userID, penID int64) ([]Inking, error)
+}
+
+// MaintenanceStore persists the servicing done on each pen.
+type MaintenanceStore interface {
+	// AddMaintenanceEvent records a servicing and returns its new ID, which
+	// the activities get as their item ID.
+	AddMaintenanceEvent(userID int64, event MaintenanceEvent, activities ...Activity) (int64, error)
+	// MaintenanceHistory returns every servicing of a pen, newest first.
+	MaintenanceHistory(userID, penID int64) ([]MaintenanceEvent, error)
+	// DeleteMaintenanceEvent removes a servicing, or returns ErrMaintenanceNotFound.
+	DeleteMaintenanceEvent(userID, eventID int64, activities ...Activity) error
+}
+
+// SettingsStore persists per-user preferences.
+type SettingsStore interface {
+	// GetFlushSettings returns the user's flush thresholds, or the defaults.
+	GetFlushSettings(userID int64) (FlushSettings, error)
+	// SaveFlushSettings stores the user's flush thresholds.
+	SaveFlushSettings(userID int64, settings FlushSettings) error
+}
+
+// PhotoStore persists the photos attached to each pen.
+type PhotoStore interface {
+	// AddPhoto stores a photo and its thumbnail and returns the photo's new
+	// ID, which the activities get as their item ID.
+	AddPhoto(userID int64, photo Photo, data, thumbnail []byte, activities ...Activity) (int64, error)
+	// PenPhotos returns the photos of a pen, oldest first.
+	PenPhotos(userID, penID int64) ([]Photo, error)
+	// ReadPhoto returns a photo with its image, or its thumbnail, or ErrPhotoNotFound.
+	ReadPhoto(userID, photoID int64, thumbnail bool) (Photo, []byte, error)
+	// DeletePhoto removes a photo and its files, or returns ErrPhotoNotFound.
+	DeletePhoto(userID, photoID int64, activities ...Activity) error
+}
+
+// ActivityStore persists the activity of each user's collection: every
+// change made to it with the values before and after. The activities of a
+// change are given to the method making it, which records them along with
+// the change, all or nothing. Updates that changed nothing aren't recorded.
+type ActivityStore interface {
+	// ListActivity returns the user's most recent changes, newest first, or
+	// every change when limit is 0.
+	ListActivity(userID int64, limit int) ([]Activity, error)
+	// PenRevisions returns the activity of every modification of a pen,
+	// newest first. Their values before the change let the pen be reverted.
+	PenRevisions(userID, penID int64) ([]Activity, error)
+}
+
+// UserStore persists user accounts.
+type UserStore interface {
+	// InsertUser adds a user and returns the new user ID.
+	InsertUser(username, firstName, middleName, lastName, email string, hashedPassword []byte, bio string) (int64, error)
+	// GetPasswordByUsername returns the bcrypt hash of the user's password.
+	GetPasswordByUsername(username string) ([]byte, error)
+	// GetUserIDByUsername returns the ID of the user, or ErrUserNotFound.
+	GetUserIDByUsername(username string) (int64, error)
+	// GetUserByID returns the user, or ErrUserNotFound.
+	GetUserByID(userID int64) (User, error)
+	// GetUsersByEmail returns every user registered with the email address, ignoring case.
+	GetUsersByEmail(email string) ([]User, error)
+	// UpdatePassword replaces the bcrypt hash of the user's password.
+	UpdatePassword(userID int64, hashedPassword []byte) error
+	// UpdateProfile saves the user's names, email address, bio and email verification time.
+	UpdateProfile(u User) error
+	// UpdateUsername renames the user, or returns ErrUsernameTaken.
+	UpdateUsername(userID int64, username string) error
+	// DeleteUser removes the user with everything they stored, their pens
+	// database and photos included.
+	DeleteUser(userID int64) error
+	// MarkEmailVerified records that the user verified their email address.
+	MarkEmailVerified(userID int64, at time.Time) error
+	// SetVerificationSent records when a verification email was last sent to the user.
+	SetVerificationSent(userID int64, at time.Time) error
+	// MarkDemo flags the user as the demo account.
+	MarkDemo(userID int64) error
+	// GetDemoUserID returns the ID of the demo account, or ErrUserNotFound.
+	GetDemoUserID() (int64, error)
+}
+
+// PasswordResetStore persists password reset tokens. Only their SHA-256 hash is stored.
+type PasswordResetStore interface {
+	// CreatePasswordReset stores a reset token hash valid until expiresAt.
+	CreatePasswordReset(userID int64, tokenHash string, createdAt, expiresAt time.Time) error
+	// UsePasswordReset marks a token as used and returns its user, or returns
+	// ErrResetTokenInvalid if the token is unknown, used or expired. Every other
+	// token of the user stops working as well.
+	UsePasswordReset(tokenHash string, now time.Time) (int64, error)
+	// RecordResetRequest stores a request for reset links sent to an email
+	// address, kept apart from the login attempts.
+	RecordResetRequest(email, ip string, at time.Time) error
+	// ResetRequests counts the reset requests since the given time for the
+	// email address, in place of the username, and from the client address.
+	ResetRequests(email, ip string, since time.Time) (LoginFailures, error)
+	// DeleteResetRequestsBefore removes the requests made before the given time.
+	DeleteResetRequestsBefore(before time.Time) error
+}
+
+// TwoFactorStore persists TOTP secrets and recovery codes. Only the SHA-256
+// hash of a recovery code is stored, the codes are shown once when generated.
+type TwoFactorStore interface {
+	// GetTwoFactor returns the user's two-factor setting, disabled if never enabled.
+	GetTwoFactor(userID int64) (TwoFactor, error)
+	// EnableTwoFactor turns on TOTP with the secret, whose code for step was just
+	// checked, and replaces the user's recovery codes.
+	EnableTwoFactor(userID int64, secret string, step int64, recoveryHashes []string, at time.Time) error
+	// DisableTwoFactor removes the user's TOTP secret and recovery codes.
+	DisableTwoFactor(userID int64) error
+	// ReplaceRecoveryCodes replaces the user's recovery codes.
+	ReplaceRecoveryCodes(userID int64, recoveryHashes []string) error
+	// UseTOTPStep records the time step of an accepted code. It returns false if
+	// that step or a later one was already used.
+	UseTOTPStep(userID, step int64) (bool, error)
+	// UseRecoveryCode marks a recovery code as used. It returns false if the
+	// code is unknown or was used before.
+	UseRecoveryCode(userID int64, codeHash string, at time.Time) (bool, error)
+}
+
+// LoginAttemptStore records login attempts, to throttle failed logins and to
+// show users the attempts on their account.
+type LoginAttemptStore interface {
+	// RecordLoginAttempt stores a login attempt.
+	RecordLoginAttempt(attempt LoginAttempt) error
+	// LoginFailures counts the failed logins since the given time for the
+	// username, ignoring those before its last successful login, and from the
+	// client address. Attempts awaiting their code count as failures of the
+	// username only.
+	LoginFailures(username, ip string, since time.Time) (LoginFailures, error)
+	// ListLoginAttempts returns the user's most recent login attempts, newest first.
+	ListLoginAttempts(userID int64, limit int) ([]LoginAttempt, error)
+	// DeleteLoginAttemptsBefore removes the attempts made before the given time.
+	DeleteLoginAttemptsBefore(before time.Time) error
+}
+
+// AdminStore supports the administration of users.
+type AdminStore interface {
+	// ListUsers returns every user ordered by ID.
+	ListUsers() ([]User, error)
+	// UserStorage returns what a user stores: their number of pens and the
+	// size of their pens database and photos.
+	UserStorage(userID int64) (UserStorage, error)
+	// SetAdmin grants or removes the user's administrator role.
+	SetAdmin(userID int64, admin bool) error
+	// SetUserDisabled disables the user from the given time, or enables them for a zero time.
+	SetUserDisabled(userID int64, disabledAt time.Time) error
+	// AddAdminAudit records an administrator's action.
+	AddAdminAudit(entry AdminAuditEntry) error
+	// ListAdminAudit returns the most recent administrator actions, newest first.
+	ListAdminAudit(limit int) ([]AdminAuditEntry, error)
+}
+
+// TokenStore persists the personal API tokens of users. Only the SHA-256
+// hash of a token is stored, the token itself is shown once when created.
+type TokenStore interface {
+	// CreateAPIToken stores a token hash and returns the token's new ID.
+	CreateAPIToken(token APIToken, tokenHash string) (int64, error)
+	// ListAPITokens returns the user's tokens, newest first.
+	ListAPITokens(userID int64) ([]APIToken, error)
+	// RevokeAPIToken deletes a token of the user, or returns ErrAPITokenNotFound.
+	RevokeAPIToken(userID, tokenID int64) error
+	// UseAPIToken returns the token with the hash and records its use at the given time,
+	// or returns ErrAPITokenNotFound.
+	UseAPIToken(tokenHash string, at time.Time) (APIToken, error)
+}
+
+// SessionStore persists server-side browser sessions.
+type SessionStore interface {
+	// SaveSession inserts or updates a session.
+	SaveSession(session UserSession) error
+	// GetSession returns a session that has not expired, or ErrSessionNotFound.
+	GetSession(id string) (UserSession, error)
+	// TouchSession records that a session was used at the given time.
+	TouchSession(id string, at time.Time) error
+	// DeleteSession removes a session, or returns ErrSessionNotFound.
+	DeleteSession(id string) error
+	// ListSessions returns the user's sessions that have not expired, most recently used first.
+	ListSessions(userID int64) ([]UserSession, error)
+	// DeleteUserSessions removes every session of the user except keepID.
+	DeleteUserSessions(userID int64, keepID string) error
+	// DeleteExpiredSessions removes the sessions that expired before now.
+	DeleteExpiredSessions(now time.Time) error
+}
+
+// Store bundles every storage interface used by the handlers.
+type Store interface {
+	PenStore
+	InkStore
+	InkingStore
+	MaintenanceStore
+	SettingsStore
+	PhotoStore
+	ActivityStore
+	UserStore
+	TokenStore
+	SessionStore
+	PasswordResetStore
+	TwoFactorStore
+	LoginAttemptStore
+	AdminStore
+	Close() error
+}
+
+// The stores used by the handlers, set by InitStore and ConfigureSessions.
+var (
+	penStore          PenStore
+	inkStore          InkStore
+	inkingStore       InkingStore
+	maintenanceStore  MaintenanceStore
+	settingsStore     SettingsStore
+	photoStore        PhotoStore
+	activityStore     ActivityStore
+	userStore         UserStore
+	tokenStore        TokenStore
+	resetStore        PasswordResetStore
+	twoFactorStore    TwoFactorStore
+	loginAttemptStore LoginAttemptStore
+	adminStore        AdminStore
+	sessionStore      SessionStore // nil unless sessions are kept on the server
+)
+
+// InitStore sets the store used by the handlers.
+func InitStore(s Store) {
+	penStore = s
+	inkStore = s
+	inkingStore = s
+	maintenanceStore = s
+	settingsStore = s
+	photoStore = s
+	activityStore = s
+	userStore = s
+	tokenStore = s
+	resetStore = s
+	twoFactorStore = s
+	loginAttemptStore = s
+	adminStore = s
+}
@@ -0,0 +1,149 @@
+// handlers/totp.go
+
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// totpPeriod is the number of seconds each code is valid for.
+	totpPeriod = 30
+	// totpDigits is the length of a code.
+	totpDigits = 6
+	// totpSkew is the number of periods accepted either side of the current
+	// one, for phones whose clocks are slightly off.
+	totpSkew = 1
+	// totpIssuer names Flock in authenticator apps.
+	totpIssuer = "Flock"
+	// recoveryCodeCount is the number of recovery codes generated at a time.
+	recoveryCodeCount = 10
+)
+
+// totpEncoding is the unpadded base32 used by authenticator apps for secrets.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactor is a user's two-factor authentication setting.
+type TwoFactor struct {
+	Secret            string    // base32 TOTP secret, empty when disabled
+	EnabledAt         time.Time // zero when disabled
+	LastStep          int64     // time step of the last accepted code
+	RecoveryCodesLeft int
+}
+
+// Enabled reports whether logging in asks for a second factor.
+func (t TwoFactor) Enabled() bool {
+	return t.Secret != "" && !t.EnabledAt.IsZero()
+}
+
+// NewTOTPSecret returns a random 160-bit secret, the size RFC 4226 recommends.
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpStep returns the RFC 6238 time step of t.
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// totpCode computes the RFC 4226 HOTP code of a secret for a time step.
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod), nil
+}
+
+// checkTOTP returns the time step of the code if it is valid at now, allowing
+// totpSkew steps of clock drift. Steps up to lastStep were used already and
+// are refused, so that a code can't be replayed.
+func checkTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpURI returns the otpauth:// provisioning URI that authenticator apps
+// read from a QR code, or accept when it is opened or pasted.
+func totpURI(account, secret string) string {
+	label := url.PathEscape(totpIssuer + ":" + account)
+	params := url.Values{
+		"secret":    {secret},
+		"issuer":    {totpIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// newRecoveryCodes generates one-time recovery codes such as "k3x9q-7hd2m"
+// and returns them with the hashes to store.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		plain := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		codes = append(codes, plain[:5]+"-"+plain[5:])
+		hashes = append(hashes, hashRecoveryCode(plain))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code the way it is stored, ignoring
+// case, spaces and dashes so that codes can be typed as they look.
+func hashRecoveryCode(code string) string {
+	code = strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+	return hashToken(code)
+}
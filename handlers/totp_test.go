@@ -0,0 +1,119 @@
+// handlers/totp_test.go
+
+package handlers
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors, "12345678901234567890".
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// The RFC gives 8 digit codes, Flock uses their last 6
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := totpCode(rfc6238Secret, totpStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.code {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestTOTPCodeRejectsBadSecret(t *testing.T) {
+	if _, err := totpCode("not base32!", 1); err == nil {
+		t.Error("totpCode accepted a secret that isn't base32")
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := totpStep(now)
+	codeAt := func(s int64) string {
+		code, err := totpCode(rfc6238Secret, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		ok       bool
+	}{
+		{"current code", codeAt(step), 0, step, true},
+		{"previous period", codeAt(step - 1), 0, step - 1, true},
+		{"next period", codeAt(step + 1), 0, step + 1, true},
+		{"two periods off", codeAt(step - 2), 0, 0, false},
+		{"surrounded by spaces", " " + codeAt(step) + " ", 0, step, true},
+		{"already used", codeAt(step), step, 0, false},
+		{"newer than the last used", codeAt(step), step - 1, step, true},
+		{"too short", codeAt(step)[1:], 0, 0, false},
+		{"wrong", "000000", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := checkTOTP(rfc6238Secret, tt.code, now, tt.lastStep)
+			if ok != tt.ok || gotStep != tt.wantStep {
+				t.Errorf("checkTOTP = %d, %v, want %d, %v", gotStep, ok, tt.wantStep, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("%d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+
+	format := regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`)
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("code %q isn't formatted as xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q is repeated", code)
+		}
+		seen[code] = true
+		if hashRecoveryCode(code) != hashes[i] {
+			t.Errorf("hash of code %d doesn't match the stored hash", i)
+		}
+	}
+}
+
+func TestHashRecoveryCodeNormalizes(t *testing.T) {
+	want := hashRecoveryCode("abcde-fghij")
+	for _, typed := range []string{"ABCDE-FGHIJ", "abcdefghij", "abcde fghij", " Abcde-Fghij "} {
+		if got := hashRecoveryCode(typed); got != want {
+			t.Errorf("hash of %q differs from the hash of abcde-fghij", typed)
+		}
+	}
+	if hashRecoveryCode("abcde-fghik") == want {
+		t.Error("different codes have the same hash")
+	}
+}
@@ -0,0 +1,316 @@
+// handlers/trash.go
+
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// undoWindow is how long the last delete or import can be undone.
+	undoWindow = 10 * time.Minute
+	// purgeInterval is how often pens past their retention leave the trash.
+	purgeInterval = time.Hour
+)
+
+// Session keys of the last delete or import, which the dashboard offers to
+// undo. undoMessageKey is removed once the dashboard has shown it.
+const (
+	undoKindKey    = "undoKind"
+	undoUserKey    = "undoUser"
+	undoPensKey    = "undoPens"
+	undoAtKey      = "undoAt"
+	undoMessageKey = "undoMessage"
+)
+
+// What undoing does: restore deleted pens or move imported ones to the trash.
+const (
+	undoDelete = "delete"
+	undoImport = "import"
+)
+
+// trashRetention is how long deleted pens stay in the trash, set by
+// SetTrashRetention. They stay until deleted by hand when zero.
+var trashRetention time.Duration
+
+// SetTrashRetention sets how long deleted pens stay in the trash.
+func SetTrashRetention(d time.Duration) {
+	trashRetention = d
+}
+
+// SchedulePurge deletes for good the pens in every user's trash for longer
+// than retention, on start and then every hour, until the returned stop
+// function is called.
+func SchedulePurge(s Store, retention time.Duration) (stop func()) {
+	quit := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			purgeTrash(s, time.Now().Add(-retention))
+
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(quit)
+		<-done
+	}
+}
+
+// purgeTrash deletes for good the pens moved to the trash before the given
+// time, for every user.
+func purgeTrash(s Store, before time.Time) {
+	users, err := s.ListUsers()
+	if err != nil {
+		slog.Error("Error listing users to empty their trash", "error", err)
+		return
+	}
+
+	for _, u := range users {
+		n, err := s.PurgeDeletedPens(u.ID, before)
+		if err != nil {
+			slog.Error("Error emptying the trash", "user_id", u.ID, "error", err)
+		}
+		if n > 0 {
+			slog.Info("Purged pens from the trash", "user_id", u.ID, "pens", n)
+		}
+	}
+}
+
+// trashItem is a pen in the trash, with when it will be purged.
+type trashItem struct {
+	Pen
+	PurgeAt time.Time // zero when pens stay until deleted by hand
+}
+
+// Trash lists the user's deleted pens, which can be restored or deleted for
+// good.
+func Trash(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	pens, err := penStore.DeletedPens(userID)
+	if err != nil {
+		requestLogger(r).Error("Error fetching the trash", "error", err)
+		RedirectWithError(w, r, "/dashboard", "Unable to get your trash, please try later")
+		return
+	}
+
+	items := make([]trashItem, len(pens))
+	for i, pen := range pens {
+		items[i] = trashItem{Pen: pen}
+		if trashRetention > 0 {
+			items[i].PurgeAt = pen.DeletedAt.Add(trashRetention)
+		}
+	}
+
+	renderTemplate(w, "trash", map[string]interface{}{
+		"Pens":          items,
+		"RetentionDays": int(trashRetention.Hours() / 24),
+		"Error":         r.URL.Query().Get("error"),
+	})
+}
+
+// deletedPen returns a pen of the user's trash, or ErrPenNotFound.
+func deletedPen(userID, penID int64) (Pen, error) {
+	pens, err := penStore.DeletedPens(userID)
+	if err != nil {
+		return Pen{}, err
+	}
+	for _, pen := range pens {
+		if pen.ID == penID {
+			return pen, nil
+		}
+	}
+	return Pen{}, ErrPenNotFound
+}
+
+// RestoreFromTrash puts a deleted pen back into the collection.
+func RestoreFromTrash(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	penID, err := pathID(r)
+	if err != nil {
+		RedirectWithError(w, r, "/trash", "Please pick a pen from the trash")
+		return
+	}
+
+	pen, err := deletedPen(userID, penID)
+	if err != nil {
+		RedirectWithError(w, r, "/trash", "This pen isn't in the trash anymore")
+		return
+	}
+	if err := penStore.RestorePen(userID, penID, newActivity(r, ActivityRestore, ItemPen, penID, pen.Name, nil, penValues(pen))); err != nil {
+		RedirectWithError(w, r, "/trash", "This pen isn't in the trash anymore")
+		return
+	}
+
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
+
+// DeleteFromTrash deletes a pen of the trash for good, with its history and
+// photos, after asking for confirmation.
+func DeleteFromTrash(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	penID, err := pathID(r)
+	if err != nil {
+		RedirectWithError(w, r, "/trash", "Please pick a pen from the trash")
+		return
+	}
+
+	pen, err := deletedPen(userID, penID)
+	if err != nil {
+		RedirectWithError(w, r, "/trash", "This pen isn't in the trash anymore")
+		return
+	}
+
+	// Ask before deleting, the deletion itself is posted from the confirmation page
+	if r.Method != http.MethodPost {
+		renderDeleteConfirmation(w, r, "Pen", pen.Name, "This can't be undone.", "/trash")
+		return
+	}
+
+	if err := penStore.PurgePen(userID, penID, newActivity(r, ActivityPurge, ItemPen, penID, pen.Name, penValues(pen), nil)); err != nil {
+		requestLogger(r).Error("Error purging pen", "pen_id", penID, "error", err)
+		RedirectWithError(w, r, "/trash", "Please try to delete once more")
+		return
+	}
+
+	http.Redirect(w, r, "/trash", http.StatusSeeOther)
+}
+
+// rememberUndo lets the user undo a delete or an import of pens for a while,
+// and has the dashboard show the message with an Undo button. It must be
+// called before the response is written.
+func rememberUndo(w http.ResponseWriter, r *http.Request, kind string, penIDs []int64, message string) {
+	session, _ := store.Get(r, sessionName)
+	session.Values[undoKindKey] = kind
+	session.Values[undoUserKey] = currentUserID(r)
+	session.Values[undoPensKey] = formatIDRanges(penIDs)
+	session.Values[undoAtKey] = time.Now().Unix()
+	session.Values[undoMessageKey] = message
+	if err := session.Save(r, w); err != nil {
+		requestLogger(r).Error("Error saving the undo", "error", err)
+	}
+}
+
+// takeUndoMessage returns the message of an undo the dashboard hasn't shown
+// yet, and forgets it so that it is shown once.
+func takeUndoMessage(w http.ResponseWriter, r *http.Request) string {
+	session, _ := store.Get(r, sessionName)
+	message, _ := session.Values[undoMessageKey].(string)
+	if message == "" {
+		return ""
+	}
+	delete(session.Values, undoMessageKey)
+	session.Save(r, w)
+
+	at, _ := session.Values[undoAtKey].(int64)
+	if time.Since(time.Unix(at, 0)) > undoWindow {
+		return ""
+	}
+	return message
+}
+
+// Undo reverts the user's last delete or import of pens: deleted pens come
+// back from the trash and imported ones go to it, keeping any change made to
+// them since so that they can still be restored.
+func Undo(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	session, _ := store.Get(r, sessionName)
+	kind, _ := session.Values[undoKindKey].(string)
+	undoUser, _ := session.Values[undoUserKey].(int64)
+	pens, _ := session.Values[undoPensKey].(string)
+	at, _ := session.Values[undoAtKey].(int64)
+	for _, key := range []string{undoKindKey, undoUserKey, undoPensKey, undoAtKey, undoMessageKey} {
+		delete(session.Values, key)
+	}
+	session.Save(r, w)
+
+	penIDs, err := parseIDRanges(pens)
+	if kind == "" || undoUser != userID || err != nil || time.Since(time.Unix(at, 0)) > undoWindow {
+		RedirectWithError(w, r, "/dashboard", "There is nothing left to undo")
+		return
+	}
+
+	for _, penID := range penIDs {
+		switch kind {
+		case undoDelete:
+			// Pens purged since are gone for good
+			pen, err := deletedPen(userID, penID)
+			if err != nil {
+				continue
+			}
+			if err := penStore.RestorePen(userID, penID, newActivity(r, ActivityRestore, ItemPen, penID, pen.Name, nil, penValues(pen))); err != nil {
+				requestLogger(r).Error("Error restoring deleted pen", "pen_id", penID, "error", err)
+			}
+		case undoImport:
+			// Pens already deleted since are left in the trash
+			pen, err := penStore.GetPenByID(userID, penID)
+			if err != nil {
+				continue
+			}
+			if err := penStore.DeletePenByID(userID, penID, newActivity(r, ActivityDelete, ItemPen, penID, pen.Name, penValues(pen), nil)); err != nil {
+				requestLogger(r).Error("Error moving imported pen to the trash", "pen_id", penID, "error", err)
+			}
+		}
+	}
+
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+// formatIDRanges writes IDs as ranges, such as "3-7,9", keeping the IDs of a
+// large import small enough for the session cookie.
+func formatIDRanges(ids []int64) string {
+	var parts []string
+	for i := 0; i < len(ids); i++ {
+		start := ids[i]
+		for i+1 < len(ids) && ids[i+1] == ids[i]+1 {
+			i++
+		}
+		if ids[i] == start {
+			parts = append(parts, strconv.FormatInt(start, 10))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", start, ids[i]))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseIDRanges reads the IDs written by formatIDRanges.
+func parseIDRanges(s string) ([]int64, error) {
+	var ids []int64
+	for _, part := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.ParseInt(last, 10, 64); err != nil {
+				return nil, err
+			}
+		}
+		for id := start; id <= end; id++ {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
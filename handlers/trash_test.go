@@ -0,0 +1,81 @@
+// handlers/trash_test.go
+
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestUndoImportMovesPensToTrash(t *testing.T) {
+	c := newTestClient(t)
+	ids, err := c.store.InsertPens(c.user.ID, []Pen{{Name: "One"}, {Name: "Two"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.do("/import/csv", func(w http.ResponseWriter, r *http.Request) {
+		rememberUndo(w, r, undoImport, ids, "Imported 2 pens.")
+	}, http.MethodPost, "/import/csv", nil)
+
+	// Edited after the import
+	if err := c.store.UpdatePen(c.user.ID, Pen{ID: ids[0], Name: "One, inked"}); err != nil {
+		t.Fatal(err)
+	}
+
+	wantRedirect(t, c.do("/undo", Undo, http.MethodPost, "/undo", nil), "/dashboard")
+	if pens, _ := c.store.SelectPens(c.user.ID); len(pens) != 0 {
+		t.Errorf("%d pens left in the collection, want 0", len(pens))
+	}
+	deleted, _ := c.store.DeletedPens(c.user.ID)
+	if len(deleted) != 2 {
+		t.Fatalf("%d pens in the trash, want 2", len(deleted))
+	}
+
+	if err := c.store.RestorePen(c.user.ID, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	if pen, _ := c.store.GetPenByID(c.user.ID, ids[0]); pen.Name != "One, inked" {
+		t.Errorf("restored pen is named %q, want its edit kept", pen.Name)
+	}
+
+	activities, _ := c.store.ListActivity(c.user.ID, 10)
+	var deletes int
+	for _, a := range activities {
+		if a.Action == ActivityDelete {
+			deletes++
+		}
+	}
+	if deletes != 2 {
+		t.Errorf("%d delete activities, want 2", deletes)
+	}
+}
+
+func TestIDRanges(t *testing.T) {
+	tests := []struct {
+		ids  []int64
+		want string
+	}{
+		{[]int64{4}, "4"},
+		{[]int64{3, 4, 5, 6, 7, 9}, "3-7,9"},
+		{[]int64{1, 3, 5}, "1,3,5"},
+		{[]int64{10, 11, 20, 21, 22}, "10-11,20-22"},
+	}
+
+	for _, tt := range tests {
+		got := formatIDRanges(tt.ids)
+		if got != tt.want {
+			t.Errorf("formatIDRanges(%v) = %q, want %q", tt.ids, got, tt.want)
+		}
+		back, err := parseIDRanges(got)
+		if err != nil || !reflect.DeepEqual(back, tt.ids) {
+			t.Errorf("parseIDRanges(%q) = %v, %v, want %v", got, back, err, tt.ids)
+		}
+	}
+
+	for _, bad := range []string{"", "a", "1-b", "1,,2"} {
+		if _, err := parseIDRanges(bad); err == nil {
+			t.Errorf("parseIDRanges(%q) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+// handlers/two_factor.go
+
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// secondFactorTimeout is how long the code can be entered after the password.
+	secondFactorTimeout = 5 * time.Minute
+	// secondFactorMaxTries is the number of codes accepted per password check.
+	secondFactorMaxTries = 5
+)
+
+// Session keys of a login waiting for its second factor, and of a TOTP secret
+// waiting for its first code during enrollment.
+const (
+	pendingLoginKey     = "pendingLogin"
+	enrollmentSecretKey = "totpSecret"
+)
+
+// pendingLogin is a login waiting for its second factor.
+type pendingLogin struct {
+	userID  int64
+	started time.Time
+	tries   int
+}
+
+// pendingLogins holds the logins waiting for their second factor, keyed by
+// the random ID kept in the session. They are kept on the server, so that
+// replaying an older session cookie doesn't reset the tries or the timeout.
+var pendingLogins = struct {
+	sync.Mutex
+	m map[string]*pendingLogin
+}{m: make(map[string]*pendingLogin)}
+
+// startSecondFactor remembers that the user gave the right password, without
+// logging them in yet.
+func startSecondFactor(w http.ResponseWriter, r *http.Request, userID int64) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	id := hex.EncodeToString(b)
+
+	now := time.Now()
+	pendingLogins.Lock()
+	for key, p := range pendingLogins.m {
+		if now.Sub(p.started) > secondFactorTimeout {
+			delete(pendingLogins.m, key)
+		}
+	}
+	pendingLogins.m[id] = &pendingLogin{userID: userID, started: now}
+	pendingLogins.Unlock()
+
+	session, _ := store.Get(r, sessionName)
+	session.Values[pendingLoginKey] = id
+	return session.Save(r, w)
+}
+
+// clearSecondFactor forgets the login waiting for its second factor.
+func clearSecondFactor(w http.ResponseWriter, r *http.Request) {
+	session, _ := store.Get(r, sessionName)
+	if id, ok := session.Values[pendingLoginKey].(string); ok {
+		pendingLogins.Lock()
+		delete(pendingLogins.m, id)
+		pendingLogins.Unlock()
+	}
+	delete(session.Values, pendingLoginKey)
+	session.Save(r, w)
+}
+
+// pendingSecondFactor returns the user whose login waits for a second factor,
+// or 0 when there is none or it timed out.
+func pendingSecondFactor(r *http.Request) int64 {
+	session, _ := store.Get(r, sessionName)
+	id, _ := session.Values[pendingLoginKey].(string)
+
+	pendingLogins.Lock()
+	defer pendingLogins.Unlock()
+	p, ok := pendingLogins.m[id]
+	if !ok || time.Since(p.started) > secondFactorTimeout {
+		return 0
+	}
+	return p.userID
+}
+
+// countSecondFactorTry counts a code entered for the pending login of the
+// session, and reports whether it may still be checked.
+func countSecondFactorTry(r *http.Request) bool {
+	session, _ := store.Get(r, sessionName)
+	id, _ := session.Values[pendingLoginKey].(string)
+
+	pendingLogins.Lock()
+	defer pendingLogins.Unlock()
+	p, ok := pendingLogins.m[id]
+	if !ok || p.tries >= secondFactorMaxTries {
+		return false
+	}
+	p.tries++
+	return true
+}
+
+// verifySecondFactor checks a TOTP code or a recovery code of the user. It
+// reports whether the code was accepted and whether it was a recovery code.
+func verifySecondFactor(userID int64, code string) (ok, recovery bool, err error) {
+	tf, err := twoFactorStore.GetTwoFactor(userID)
+	if err != nil {
+		return false, false, err
+	}
+
+	if step, valid := checkTOTP(tf.Secret, code, time.Now(), tf.LastStep); valid {
+		ok, err = twoFactorStore.UseTOTPStep(userID, step)
+		return ok, false, err
+	}
+
+	ok, err = twoFactorStore.UseRecoveryCode(userID, hashRecoveryCode(code), time.Now())
+	return ok, ok, err
+}
+
+// LoginSecondFactor asks for the authenticator or recovery code of a user who
+// gave the right password, and logs them in once it is correct.
+func LoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	userID := pendingSecondFactor(r)
+	if userID == 0 {
+		RedirectWithError(w, r, "/login", "Please login again")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		u, err := userStore.GetUserByID(userID)
+		if err != nil {
+			RedirectWithError(w, r, "/login", "Unable to login, please try later")
+			return
+		}
+
+		// Codes back off like passwords, with the failures of both counted together
+		ip := clientAddress(r)
+		unlock := lockThrottle("username:"+u.Username, "ip:"+ip)
+		defer unlock()
+		wait, err := loginRetryAfter(u.Username, ip, time.Now())
+		if err != nil {
+			RedirectWithError(w, r, "/login/2fa", "Unable to check the code, please try again")
+			return
+		}
+		if wait > 0 {
+			RedirectWithError(w, r, "/login/2fa", "Too many failed logins, please try again in "+formatWait(wait))
+			return
+		}
+		if !countSecondFactorTry(r) {
+			clearSecondFactor(w, r)
+			RedirectWithError(w, r, "/login", "Too many wrong codes, please login again")
+			return
+		}
+
+		ok, recovery, err := verifySecondFactor(userID, r.FormValue("code"))
+		if err != nil {
+			requestLogger(r).Error("Error checking the second factor", "target_id", userID, "error", err)
+			RedirectWithError(w, r, "/login/2fa", "Unable to check the code, please try again")
+			return
+		}
+		recordLogin(r, u.Username, userID, ok)
+		if !ok {
+			RedirectWithError(w, r, "/login/2fa", "Invalid code")
+			return
+		}
+
+		clearSecondFactor(w, r)
+		SetUserIDInSession(w, r, userID)
+
+		// Point out that recovery codes run out
+		if recovery {
+			tf, err := twoFactorStore.GetTwoFactor(userID)
+			if err == nil {
+				RedirectWithError(w, r, "/account", fmt.Sprintf("You used a recovery code, %d left", tf.RecoveryCodesLeft))
+				return
+			}
+		}
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	renderTemplate(w, "login_2fa", map[string]interface{}{
+		"Error":       r.URL.Query().Get("error"),
+		"RedirectURL": "",
+	})
+}
+
+// checkPassword re-authenticates the user before a sensitive change.
+func checkPassword(userID int64, password string) bool {
+	u, err := userStore.GetUserByID(userID)
+	if err != nil {
+		return false
+	}
+	hashedPassword, err := userStore.GetPasswordByUsername(u.Username)
+	if err != nil {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hashedPassword, []byte(password)) == nil
+}
+
+// enrollmentSecret returns the TOTP secret waiting for its first code, if any.
+func enrollmentSecret(r *http.Request) string {
+	session, _ := store.Get(r, sessionName)
+	secret, _ := session.Values[enrollmentSecretKey].(string)
+	return secret
+}
+
+// SetupTwoFactor starts enrolling an authenticator app by generating a
+// secret, which is kept in the session until a code proves the app has it.
+// Posting cancel drops the secret instead.
+func SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	session, _ := store.Get(r, sessionName)
+	if r.FormValue("cancel") != "" {
+		delete(session.Values, enrollmentSecretKey)
+		session.Save(r, w)
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+
+	tf, err := twoFactorStore.GetTwoFactor(userID)
+	if err != nil {
+		RedirectWithError(w, r, "/account", "Unable to get your account, please try later")
+		return
+	}
+	if tf.Enabled() {
+		RedirectWithError(w, r, "/account", "Two-factor authentication is already on")
+		return
+	}
+
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		RedirectWithError(w, r, "/account", "Unable to set up two-factor authentication, please try again")
+		return
+	}
+	session.Values[enrollmentSecretKey] = secret
+	session.Save(r, w)
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// EnableTwoFactor turns on two-factor authentication once the code from the
+// authenticator app matches the enrollment secret, and shows the recovery codes once.
+func EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	secret := enrollmentSecret(r)
+	if secret == "" {
+		RedirectWithError(w, r, "/account", "Please start the set up again")
+		return
+	}
+
+	step, ok := checkTOTP(secret, r.FormValue("code"), time.Now(), 0)
+	if !ok {
+		RedirectWithError(w, r, "/account", "Invalid code, please check the time on your phone and try again")
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		RedirectWithError(w, r, "/account", "Unable to set up two-factor authentication, please try again")
+		return
+	}
+	if err := twoFactorStore.EnableTwoFactor(userID, secret, step, hashes, time.Now()); err != nil {
+		requestLogger(r).Error("Error enabling two-factor authentication", "error", err)
+		RedirectWithError(w, r, "/account", "Unable to set up two-factor authentication, please try again")
+		return
+	}
+
+	session, _ := store.Get(r, sessionName)
+	delete(session.Values, enrollmentSecretKey)
+	session.Save(r, w)
+
+	// Keep the recovery codes out of caches and browser history
+	w.Header().Set("Cache-Control", "no-store")
+	renderAccount(w, r, userID, accountData{RecoveryCodes: codes})
+}
+
+// DisableTwoFactor turns off two-factor authentication after checking the password.
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	if !checkPassword(userID, r.FormValue("password")) {
+		RedirectWithError(w, r, "/account", "Incorrect password")
+		return
+	}
+
+	if err := twoFactorStore.DisableTwoFactor(userID); err != nil {
+		requestLogger(r).Error("Error disabling two-factor authentication", "error", err)
+		RedirectWithError(w, r, "/account", "Unable to turn off two-factor authentication, please try again")
+		return
+	}
+
+	RedirectWithError(w, r, "/account", "Two-factor authentication is off")
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes after checking the
+// password, and shows the new ones once.
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	if !checkPassword(userID, r.FormValue("password")) {
+		RedirectWithError(w, r, "/account", "Incorrect password")
+		return
+	}
+
+	tf, err := twoFactorStore.GetTwoFactor(userID)
+	if err != nil || !tf.Enabled() {
+		RedirectWithError(w, r, "/account", "Two-factor authentication is off")
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err == nil {
+		err = twoFactorStore.ReplaceRecoveryCodes(userID, hashes)
+	}
+	if err != nil {
+		requestLogger(r).Error("Error replacing the recovery codes", "error", err)
+		RedirectWithError(w, r, "/account", "Unable to create new recovery codes, please try again")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	renderAccount(w, r, userID, accountData{RecoveryCodes: codes})
+}
@@ -0,0 +1,118 @@
+// handlers/two_factor_database.go
+
+package handlers
+
+import (
+	"database/sql"
+	"time"
+)
+
+// GetTwoFactor returns a user's two-factor setting with the number of unused recovery codes.
+func (s *SQLiteStore) GetTwoFactor(userID int64) (TwoFactor, error) {
+	var t TwoFactor
+	var secret sql.NullString
+	var enabledAt sql.NullTime
+	err := s.db.QueryRow("SELECT totp_secret, totp_enabled_at, totp_last_step FROM users WHERE id = ?", userID).
+		Scan(&secret, &enabledAt, &t.LastStep)
+	if err == sql.ErrNoRows {
+		return TwoFactor{}, ErrUserNotFound
+	}
+	if err != nil {
+		return TwoFactor{}, err
+	}
+	t.Secret, t.EnabledAt = secret.String, enabledAt.Time
+
+	err = s.db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).
+		Scan(&t.RecoveryCodesLeft)
+	return t, err
+}
+
+// insertRecoveryCodes replaces the recovery codes of a user within a transaction.
+func insertRecoveryCodes(tx *sql.Tx, userID int64, recoveryHashes []string) error {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryHashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnableTwoFactor stores a user's TOTP secret and recovery codes.
+func (s *SQLiteStore) EnableTwoFactor(userID int64, secret string, step int64, recoveryHashes []string, at time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE users SET totp_secret = ?, totp_enabled_at = ?, totp_last_step = ? WHERE id = ?",
+		secret, at, step, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+
+	if err := insertRecoveryCodes(tx, userID, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableTwoFactor removes a user's TOTP secret and recovery codes.
+func (s *SQLiteStore) DisableTwoFactor(userID int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = ?", userID); err != nil {
+		return err
+	}
+	if err := insertRecoveryCodes(tx, userID, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes replaces a user's recovery codes.
+func (s *SQLiteStore) ReplaceRecoveryCodes(userID int64, recoveryHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertRecoveryCodes(tx, userID, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records the time step of an accepted code, refusing steps that
+// are not newer than the last one so that two logins can't share a code.
+func (s *SQLiteStore) UseTOTPStep(userID, step int64) (bool, error) {
+	result, err := s.db.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// UseRecoveryCode marks an unused recovery code of a user as used.
+func (s *SQLiteStore) UseRecoveryCode(userID int64, codeHash string, at time.Time) (bool, error) {
+	result, err := s.db.Exec(`UPDATE recovery_codes SET used_at = ?
+		WHERE id = (SELECT id FROM recovery_codes WHERE user_id = ? AND code_hash = ? AND used_at IS NULL LIMIT 1)`,
+		at, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
@@ -0,0 +1,165 @@
+// handlers/two_factor_test.go
+
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"flock/config"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// enableTestTwoFactor turns on two-factor authentication for the client's
+// user and returns a recovery code.
+func enableTestTwoFactor(t *testing.T, c *testClient) string {
+	t.Helper()
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.store.EnableTwoFactor(c.user.ID, rfc6238Secret, 0, hashes, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	return codes[0]
+}
+
+func TestVerifySecondFactor(t *testing.T) {
+	c := newTestClient(t)
+	recovery := enableTestTwoFactor(t, c)
+	code, err := totpCode(rfc6238Secret, totpStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		code         string
+		ok, recovery bool
+	}{
+		{"current code", code, true, false},
+		{"replayed code", code, false, false},
+		{"recovery code", recovery, true, true},
+		{"recovery code used twice", recovery, false, false},
+		{"wrong code", "123456", false, false},
+	}
+
+	for _, tt := range tests {
+		ok, isRecovery, err := verifySecondFactor(c.user.ID, tt.code)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if ok != tt.ok || isRecovery != tt.recovery {
+			t.Errorf("%s: verifySecondFactor = %v, %v, want %v, %v", tt.name, ok, isRecovery, tt.ok, tt.recovery)
+		}
+	}
+
+	tf, _ := c.store.GetTwoFactor(c.user.ID)
+	if tf.RecoveryCodesLeft != recoveryCodeCount-1 {
+		t.Errorf("%d recovery codes left, want %d", tf.RecoveryCodesLeft, recoveryCodeCount-1)
+	}
+}
+
+// startTestSecondFactor has the client wait for its second factor, as after
+// the right password.
+func startTestSecondFactor(t *testing.T, c *testClient) {
+	t.Helper()
+	c.do("/login", func(w http.ResponseWriter, r *http.Request) {
+		if err := startSecondFactor(w, r, c.user.ID); err != nil {
+			t.Fatal(err)
+		}
+	}, http.MethodPost, "/login", url.Values{})
+}
+
+func TestLoginSecondFactorLimitsTries(t *testing.T) {
+	defer func(limits config.LoginLimits) { loginLimits = limits }(loginLimits)
+	loginLimits.FreeAttempts, loginLimits.LockoutAfter = 100, 200 // only the tries of the pending login apply
+
+	c := newTestClient(t)
+	enableTestTwoFactor(t, c)
+	startTestSecondFactor(t, c)
+	pending := c.cookies[sessionName]
+
+	wrong := url.Values{"code": {"000000"}}
+	for i := 0; i < secondFactorMaxTries; i++ {
+		w := c.do("/login/2fa", LoginSecondFactor, http.MethodPost, "/login/2fa", wrong)
+		wantRedirect(t, w, "/login/2fa?error=Invalid+code")
+	}
+	w := c.do("/login/2fa", LoginSecondFactor, http.MethodPost, "/login/2fa", wrong)
+	wantRedirect(t, w, "/login?error=Too+many+wrong+codes%2C+please+login+again")
+
+	// The cookie of the pending login doesn't bring back its tries
+	c.cookies[sessionName] = pending
+	w = c.do("/login/2fa", LoginSecondFactor, http.MethodPost, "/login/2fa", wrong)
+	wantRedirect(t, w, "/login?error=Please+login+again")
+}
+
+func TestLoginSecondFactorBacksOff(t *testing.T) {
+	c := newTestClient(t)
+	enableTestTwoFactor(t, c)
+	startTestSecondFactor(t, c)
+
+	wrong := url.Values{"code": {"000000"}}
+	for i := 0; i <= loginLimits.FreeAttempts; i++ {
+		c.do("/login/2fa", LoginSecondFactor, http.MethodPost, "/login/2fa", wrong)
+	}
+
+	// Past the free attempts even the right code has to wait
+	code, _ := totpCode(rfc6238Secret, totpStep(time.Now()))
+	w := c.do("/login/2fa", LoginSecondFactor, http.MethodPost, "/login/2fa", url.Values{"code": {code}})
+	wantRedirect(t, w, "/login/2fa?error=Too+many+failed+logins%2C+please+try+again+in+1+second")
+}
+
+func TestLoginSecondFactor(t *testing.T) {
+	c := newTestClient(t)
+	enableTestTwoFactor(t, c)
+	startTestSecondFactor(t, c)
+
+	code, _ := totpCode(rfc6238Secret, totpStep(time.Now()))
+	w := c.do("/login/2fa", LoginSecondFactor, http.MethodPost, "/login/2fa", url.Values{"code": {code}})
+	wantRedirect(t, w, "/dashboard")
+
+	attempts, _ := c.store.ListLoginAttempts(c.user.ID, 10)
+	if len(attempts) != 1 || !attempts[0].Succeeded {
+		t.Errorf("login attempts = %+v, want one success", attempts)
+	}
+}
+
+func TestLoginRecordsRightPasswordAwaitingCode(t *testing.T) {
+	c := newTestClient(t)
+	enableTestTwoFactor(t, c)
+	hash, err := bcrypt.GenerateFromPassword([]byte("right password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.store.UpdatePassword(c.user.ID, hash); err != nil {
+		t.Fatal(err)
+	}
+
+	login := url.Values{"username": {"tester"}, "password": {"right password"}}
+	w := c.do("/login", Login, http.MethodPost, "/login", login)
+	wantRedirect(t, w, "/login/2fa")
+
+	attempts, _ := c.store.ListLoginAttempts(c.user.ID, 10)
+	if len(attempts) != 1 || attempts[0].Succeeded || !attempts[0].AwaitingCode {
+		t.Errorf("login attempts = %+v, want one awaiting its code", attempts)
+	}
+
+	// Starting logins over backs off like failures of the username, not of the address
+	for i := 1; i <= loginLimits.FreeAttempts; i++ {
+		c.do("/login", Login, http.MethodPost, "/login", login)
+	}
+	w = c.do("/login", Login, http.MethodPost, "/login", login)
+	wantRedirect(t, w, "/login?error=Too+many+failed+logins%2C+please+try+again+in+1+second")
+
+	f, err := c.store.LoginFailures("tester", "192.0.2.1", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.IP != 0 {
+		t.Errorf("%d failures of the address, want 0", f.IP)
+	}
+}
@@ -0,0 +1,83 @@
+// handlers/user.go
+
+package handlers
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrUsernameTaken is returned when another user already has a username.
+var ErrUsernameTaken = errors.New("the username is already taken")
+
+// maxUsernameLength is the longest username accepted when changing it.
+const maxUsernameLength = 64
+
+// minPasswordLength is the shortest password accepted when setting a new one.
+const minPasswordLength = 8
+
+// User is an account as shown to its owner. The password hash is never part of it.
+type User struct {
+	ID         int64
+	Username   string
+	FirstName  string
+	MiddleName string
+	LastName   string
+	Email      string
+	Bio        string
+
+	EmailVerifiedAt    time.Time // zero while the email address is not verified
+	VerificationSentAt time.Time // when the last verification email was sent
+	IsAdmin            bool
+	DisabledAt         time.Time // zero unless an administrator disabled the account
+}
+
+// Disabled reports whether an administrator disabled the account.
+func (u User) Disabled() bool {
+	return !u.DisabledAt.IsZero()
+}
+
+// Verified reports whether the user's email address is verified.
+func (u User) Verified() bool {
+	return !u.EmailVerifiedAt.IsZero()
+}
+
+// validatePassword checks a new password. bcrypt ignores anything past 72 bytes.
+func validatePassword(password string) error {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return errors.New("the password should be at least 8 characters long")
+	}
+	if len(password) > 72 {
+		return errors.New("the password should be at most 72 bytes long")
+	}
+	return nil
+}
+
+// validateUsername checks a new username.
+func validateUsername(username string) error {
+	if username == "" {
+		return errors.New("please enter a username")
+	}
+	if username != strings.TrimSpace(username) {
+		return errors.New("the username can't start or end with spaces")
+	}
+	if utf8.RuneCountInString(username) > maxUsernameLength {
+		return errors.New("the username should be at most 64 characters long")
+	}
+	return nil
+}
+
+// Validate checks the profile fields a user can edit.
+func (u User) Validate() error {
+	if strings.TrimSpace(u.FirstName) == "" || strings.TrimSpace(u.LastName) == "" {
+		return errors.New("please enter your first and last name")
+	}
+	addr, err := mail.ParseAddress(u.Email)
+	if err != nil || addr.Address != u.Email {
+		return errors.New("please enter a valid email address")
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+// handlers/verify_email.go
+
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// ErrVerificationLinkInvalid is returned for verification links that are forged, expired or for an old email address.
+var ErrVerificationLinkInvalid = errors.New("the verification link is invalid or has expired")
+
+const (
+	// verificationLinkLifetime is how long an email verification link works.
+	verificationLinkLifetime = 48 * time.Hour
+	// verificationResendInterval is how long a user waits before another verification email is sent.
+	verificationResendInterval = 5 * time.Minute
+)
+
+// emailVerification reports whether new accounts must verify their email
+// address, and verificationKey signs the links. They are set by
+// SetEmailVerification and LoadVerificationKey.
+var (
+	emailVerification bool
+	verificationKey   []byte
+)
+
+// SetEmailVerification turns the verification of email addresses on or off.
+// With it off, new accounts are verified when they register.
+func SetEmailVerification(enabled bool) {
+	emailVerification = enabled
+}
+
+// LoadVerificationKey reads the key signing the verification links from path,
+// creating the file with a random key if it does not exist yet. The key is
+// kept apart from the session keys, so that rotating them doesn't void the
+// links already sent.
+func LoadVerificationKey(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		key := securecookie.GenerateRandomKey(64)
+		if key == nil {
+			return errors.New("unable to generate the verification key")
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+			return err
+		}
+		verificationKey = key
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) < 32 {
+		return fmt.Errorf("verification key %s: should be at least 32 bytes in hex", path)
+	}
+	verificationKey = key
+	return nil
+}
+
+// verificationSignature signs a user's email address until an expiry time.
+// The address is part of the signature, so changing it voids old links.
+func verificationSignature(userID int64, email string, expires int64) string {
+	mac := hmac.New(sha256.New, verificationKey)
+	fmt.Fprintf(mac, "verify-email\n%d\n%s\n%d", userID, strings.ToLower(email), expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verificationToken returns the signed token of a verification link.
+func verificationToken(u User, expiresAt time.Time) string {
+	expires := expiresAt.Unix()
+	return fmt.Sprintf("%d.%d.%s", u.ID, expires, verificationSignature(u.ID, u.Email, expires))
+}
+
+// checkVerificationToken returns the user of a valid verification token.
+func checkVerificationToken(token string, now time.Time) (User, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return User{}, ErrVerificationLinkInvalid
+	}
+	userID, err1 := strconv.ParseInt(parts[0], 10, 64)
+	expires, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil || now.Unix() > expires {
+		return User{}, ErrVerificationLinkInvalid
+	}
+
+	u, err := userStore.GetUserByID(userID)
+	if err == ErrUserNotFound {
+		return User{}, ErrVerificationLinkInvalid
+	}
+	if err != nil {
+		return User{}, err
+	}
+
+	expected := verificationSignature(u.ID, u.Email, expires)
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return User{}, ErrVerificationLinkInvalid
+	}
+	return u, nil
+}
+
+// sendVerificationEmail mails a verification link to the user and records when.
+func sendVerificationEmail(u User) error {
+	now := time.Now()
+	link := fmt.Sprintf("%s/verify?token=%s", baseURL, url.QueryEscape(verificationToken(u, now.Add(verificationLinkLifetime))))
+
+	err := mailer.Send(Message{
+		To:      u.Email,
+		Subject: "Verify your email address for Flock",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Please verify the email address of your Flock account %q by opening this link within two days:\n\n%s\n\n"+
+			"If you didn't create the account, ignore this email.\n",
+			u.FirstName, u.Username, link),
+	})
+	if err != nil {
+		return err
+	}
+	return userStore.SetVerificationSent(u.ID, now)
+}
+
+// requireVerifiedEmail redirects users who still have to verify their email
+// address to the verification page, and reports whether they may go on.
+func requireVerifiedEmail(w http.ResponseWriter, r *http.Request, userID int64) bool {
+	if !emailVerification {
+		return true
+	}
+
+	u, err := userStore.GetUserByID(userID)
+	if err != nil {
+		RedirectWithError(w, r, "/dashboard", "Unable to check your account, please try later")
+		return false
+	}
+	if !u.Verified() {
+		RedirectWithError(w, r, "/verify", "Please verify your email address first")
+		return false
+	}
+	return true
+}
+
+// VerifyEmail verifies the email address of a link's account, or shows the
+// logged in user whether their address is verified, with a form to send the
+// link again.
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if token := r.URL.Query().Get("token"); token != "" {
+		u, err := checkVerificationToken(token, time.Now())
+		if err == ErrVerificationLinkInvalid {
+			RedirectWithError(w, r, "/verify", err.Error())
+			return
+		}
+		if err != nil {
+			RedirectWithError(w, r, "/verify", "Unable to verify your email address, please try again")
+			return
+		}
+
+		if !u.Verified() {
+			if err := userStore.MarkEmailVerified(u.ID, time.Now()); err != nil {
+				RedirectWithError(w, r, "/verify", "Unable to verify your email address, please try again")
+				return
+			}
+		}
+		renderTemplate(w, "verify", map[string]interface{}{
+			"Verified":    true,
+			"Email":       u.Email,
+			"LoggedIn":    GetUserIDFromSession(r) != 0,
+			"Error":       "",
+			"RedirectURL": "",
+		})
+		return
+	}
+
+	// Without a link the page shows the status of the logged in user
+	u, ok := sessionUser(r)
+	if !ok {
+		RedirectWithError(w, r, "/login", "Please login to verify your email address")
+		return
+	}
+
+	renderTemplate(w, "verify", map[string]interface{}{
+		"Verified":    u.Verified(),
+		"Email":       u.Email,
+		"LoggedIn":    true,
+		"Error":       r.URL.Query().Get("error"),
+		"RedirectURL": "",
+	})
+}
+
+// ResendVerification sends the verification link again, at most once every
+// verificationResendInterval.
+func ResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	u, err := userStore.GetUserByID(userID)
+	if err != nil {
+		RedirectWithError(w, r, "/verify", "Unable to get your account, please try later")
+		return
+	}
+	if u.Verified() {
+		http.Redirect(w, r, "/verify", http.StatusSeeOther)
+		return
+	}
+
+	if wait := time.Until(u.VerificationSentAt.Add(verificationResendInterval)); wait > 0 {
+		RedirectWithError(w, r, "/verify", fmt.Sprintf("An email was just sent, please wait %d more minutes before asking again", int(wait.Minutes())+1))
+		return
+	}
+
+	if err := sendVerificationEmail(u); err != nil {
+		requestLogger(r).Error("Error sending verification email", "error", err)
+		RedirectWithError(w, r, "/verify", "Unable to send the email, please try later")
+		return
+	}
+
+	RedirectWithError(w, r, "/verify", "A new verification link is on its way to "+u.Email)
+}
+
+// VerifyUserByName marks a user's email address as verified without a link,
+// for administrators helping users whose email never arrives.
+func VerifyUserByName(users UserStore, username string) error {
+	userID, err := users.GetUserIDByUsername(username)
+	if err != nil {
+		return fmt.Errorf("user %q: %w", username, err)
+	}
+	return users.MarkEmailVerified(userID, time.Now())
+}
@@ -0,0 +1,95 @@
+// handlers/verify_email_test.go
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// requireVerification turns the verification of email addresses on for a test.
+func requireVerification(t *testing.T) {
+	SetEmailVerification(true)
+	t.Cleanup(func() { SetEmailVerification(false) })
+}
+
+func TestUnverifiedUsersCantUseTheAPI(t *testing.T) {
+	c := newTestClient(t)
+	requireVerification(t)
+
+	w := c.do("/account/tokens", CreateToken, http.MethodPost, "/account/tokens", url.Values{"name": {"script"}, "scope": {TokenScopeRead}})
+	wantRedirect(t, w, "/verify?error=Please+verify+your+email+address+first")
+
+	// Tokens made before verification was required don't get around it
+	plain, hash, err := NewAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := APIToken{UserID: c.user.ID, Name: "old", Scope: TokenScopeRead, CreatedAt: time.Now()}
+	if _, err := c.store.CreateAPIToken(token, hash); err != nil {
+		t.Fatal(err)
+	}
+	listPens := func() int {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/pens", nil)
+		r.Header.Set("Authorization", "Bearer "+plain)
+		w := httptest.NewRecorder()
+		RequireAPIAuth(http.HandlerFunc(APIListPens)).ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := listPens(); code != http.StatusForbidden {
+		t.Errorf("unverified: status %d, want %d", code, http.StatusForbidden)
+	}
+
+	if err := c.store.MarkEmailVerified(c.user.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if code := listPens(); code != http.StatusOK {
+		t.Errorf("verified: status %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestVerificationLinksOutliveSessionKeys(t *testing.T) {
+	c := newTestClient(t)
+	path := filepath.Join(t.TempDir(), "verification_key")
+	if err := LoadVerificationKey(path); err != nil {
+		t.Fatal(err)
+	}
+	token := verificationToken(c.user, time.Now().Add(time.Hour))
+
+	keys := []SessionKeyPair{{HashKey: []byte(strings.Repeat("n", 32)), BlockKey: []byte(strings.Repeat("k", 32))}}
+	if err := ConfigureSessions(keys, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadVerificationKey(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkVerificationToken(token, time.Now()); err != nil {
+		t.Errorf("after new session keys: %v", err)
+	}
+}
+
+func TestAdminVerifiesUser(t *testing.T) {
+	c := newTestClient(t)
+	c.user.IsAdmin = true
+	userID, err := c.store.InsertUser("lost", "Lost", "", "Mail", "lost@example.com", []byte("hash"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := fmt.Sprintf("/admin/users/verify/%d", userID)
+	wantRedirect(t, c.do("/admin/users/{action}/{id}", AdminUser, http.MethodPost, target, nil), "/admin")
+
+	u, err := c.store.GetUserByID(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.Verified() {
+		t.Error("the user isn't verified")
+	}
+}
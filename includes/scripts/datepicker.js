@@ -5,6 +5,11 @@ const dateInputs = document.querySelectorAll('input[type="date"]');
 
 // Loop through the date inputs and set the date format to display only the year
 dateInputs.forEach(input => {
+  // Leave dates that were filled in by the server alone
+  if (input.value) {
+    return;
+  }
+
   // Get the year from the date value
   const currentYear = new Date().getFullYear();
   const minYear = parseInt(input.min);
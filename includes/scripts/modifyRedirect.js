@@ -9,4 +9,13 @@ document.addEventListener('DOMContentLoaded', function() {
       window.location.href = `/modify/${penID}`;
     });
   });
+
+  const inkRows = document.querySelectorAll('.ink-row');
+
+  inkRows.forEach(row => {
+    row.addEventListener('click', function() {
+      const inkID = this.id.replace('inkRow', '');
+      window.location.href = `/inks/modify/${inkID}`;
+    });
+  });
 });
@@ -1,6 +1,7 @@
-function sortTable(n) {
+function sortTable(n, tableId) {
     var table, rows, switching, i, x, y, shouldSwitch, dir, switchcount = 0;
-    table = document.getElementById("pensList");
+    // The pens table is sorted unless another table is named
+    table = document.getElementById(tableId || "pensList");
     switching = true;
     // Set the sorting direction to ascending:
     dir = "asc";
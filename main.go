@@ -2,62 +2,254 @@
 package main
 
 import (
-	"database/sql"
-	"path/filepath"
-	//"html/template"
-	//"text/template"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
-	//"strings"
-	//"time"
-	//"encoding/csv"
-	//"fmt"
-	//"reflect"
-	//"os"
-	//"encoding/json"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 
 	_ "github.com/mattn/go-sqlite3"
 
-	// "golang.org/x/crypto/bcrypt"
-
+	"flock/config"
 	"flock/handlers"
 )
 
-var db *sql.DB
-
 func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run sets Flock up from its configuration and serves it until it is
+// stopped. Returning, rather than exiting, lets the databases close cleanly.
+func run() error {
+	// Rotating the session keys happens on start, before serving as usual
+	rotateSessionKeys := flag.Bool("rotate-session-keys", false, "add a new session key pair on start, keeping the previous two for existing sessions")
 
-	// Check if the database exists, and create or open it
-	db, err := handlers.CreateDatabaseIfNotExists()
+	// Actions run instead of the server
+	verifyUser := flag.String("verify-user", "", "mark the email address of the named user as verified and exit")
+	resetDemo := flag.Bool("reset-demo", false, "reset the demo account to its fixture and exit, with seed-demo only")
+	removeDemo := flag.Bool("remove-demo", false, "delete the demo account with its pens and exit")
+
+	// Settings come from the config file, FLOCK_ variables and flags
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	slog.SetDefault(newLogger(cfg))
+
+	// Check if the database exists, create or open it, and apply any pending migrations
+	store, report, err := handlers.CreateDatabaseIfNotExists(cfg.DataDir, cfg.SeedDemo)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	for _, applied := range report {
+		log.Printf("Applied migration %d (%s) to %s", applied.Version, applied.Description, applied.Database)
+	}
+
+	// Let an administrator verify a user whose email never arrives
+	if *verifyUser != "" {
+		if err := handlers.VerifyUserByName(store, *verifyUser); err != nil {
+			return err
+		}
+		log.Printf("Verified the email address of %s", *verifyUser)
+		return nil
+	}
+
+	// Throw away what visitors did to the demo account
+	if *resetDemo {
+		if !cfg.SeedDemo {
+			return errors.New("-reset-demo needs seed_demo, use -remove-demo to delete the demo account")
+		}
+		if err := handlers.ResetDemo(store); err != nil {
+			return err
+		}
+		log.Println("Reset the demo account")
+		return nil
+	}
+	if *removeDemo {
+		removed, err := handlers.RemoveDemo(store)
+		if err != nil {
+			return err
+		}
+		if removed {
+			log.Println("Removed the demo account")
+		} else {
+			log.Println("There is no demo account to remove")
+		}
+		return nil
+	}
+	if !cfg.SeedDemo {
+		// Earlier versions always added the demo account, with its password in the Readme
+		if _, err := store.GetDemoUserID(); err == nil {
+			log.Println("The demo account exists although seed_demo is off, delete it with -remove-demo")
+		}
+	}
+	if cfg.DemoReset > 0 {
+		stopDemoReset := handlers.ScheduleDemoReset(store, cfg.DemoReset)
+		defer stopDemoReset()
+	}
+
+	// Empty the trash of pens deleted longer ago than the retention
+	handlers.SetTrashRetention(cfg.TrashRetention)
+	if cfg.TrashRetention > 0 {
+		stopPurge := handlers.SchedulePurge(store, cfg.TrashRetention)
+		defer stopPurge()
 	}
-	defer db.Close()
 
-	// Initialize the database for handlers
-	handlers.InitDB(db)
+	// Initialize the store for handlers
+	handlers.InitStore(store)
+
+	if cfg.AdminUsername != "" {
+		if err := handlers.BootstrapAdmin(store, cfg.AdminUsername, cfg.AdminEmail, cfg.AdminPassword); err != nil {
+			return err
+		}
+	}
 
 	log.Println("Database connection established")
 
-	http.HandleFunc("/", handlers.Index)                       // Handler listing pens
-	http.HandleFunc("/register", handlers.Register)            // Handler for registering user
-	http.HandleFunc("/login", handlers.Login)                  // Handler for login
-	http.HandleFunc("/dashboard", handlers.ListPens)           // Handler listing pens
-	http.HandleFunc("/add", handlers.AddPen)                   // Handler adding a pen
-	http.HandleFunc("/export/csv", handlers.ExportCSV)         // Handler exporting to CSV
-	http.HandleFunc("/import/csv", handlers.ImportCSV)         // Handler importing from CSV
-	http.HandleFunc("/import/approve", handlers.ImportApprove) // Handler approving imported data from CSV
-	http.HandleFunc("/modify/", handlers.ModifyPen)            // Handler to modify details for a pen
-	http.HandleFunc("/delete/", handlers.DeletePen)            // Handler to delete a pen
-	http.HandleFunc("/logout", handlers.Logout)                // Handler for logout
+	if err := handlers.ConfigureLoginLimits(cfg.Login); err != nil {
+		return err
+	}
+	// Forget login attempts and reset requests past their retention
+	stopLoginPrune := handlers.ScheduleLoginPrune(store)
+	defer stopLoginPrune()
 
-	// Serve static assets
-	http.HandleFunc("/includes/", func(w http.ResponseWriter, r *http.Request) {
-		// Get the requested file path
-		filePath := "." + r.URL.Path
+	// Load the persisted session keys so that logins survive restarts
+	loadKeys := handlers.LoadSessionKeys
+	if *rotateSessionKeys {
+		loadKeys = func(path string) ([]handlers.SessionKeyPair, error) { return handlers.RotateSessionKeys(path, 2) }
+	}
+	keys, err := loadKeys(cfg.SessionKeys)
+	if err != nil {
+		return err
+	}
+
+	var backend handlers.SessionStore
+	if cfg.ServerSessions {
+		backend = store
+	}
+	handlers.SetSecureCookies(cfg.TLS())
+	if err := handlers.ConfigureSessions(keys, backend); err != nil {
+		return err
+	}
+
+	// Choose how email is delivered
+	var mailer handlers.Mailer = handlers.LogMailer{Dir: cfg.MailDir, From: cfg.MailFrom}
+	if cfg.SMTPAddr != "" {
+		mailer = handlers.SMTPMailer{Addr: cfg.SMTPAddr, Username: cfg.SMTPUser, Password: cfg.SMTPPassword, From: cfg.MailFrom}
+	}
+	handlers.InitMailer(mailer, cfg.BaseURL)
+	handlers.SetEmailVerification(cfg.VerifyEmail)
+	if err := handlers.LoadVerificationKey(filepath.Join(cfg.DataDir, "verification_key")); err != nil {
+		return err
+	}
+
+	handlers.SetTemplateDir(cfg.TemplateDir)
+	handlers.SetUploadLimits(cfg.MaxPhotoSize, cfg.MaxUploadSize)
+	handlers.SetRegistrationOpen(cfg.Registration)
+	handlers.SetDemoSeeded(cfg.SeedDemo)
 
+	// Load the questions asked when registering
+	if cfg.Registration {
+		bank, err := handlers.LoadQuestionBank(cfg.CaptchaQuestions)
+		if err != nil {
+			return err
+		}
+		if err := handlers.InitCaptcha(bank); err != nil {
+			return err
+		}
+	}
+
+	// Every request is logged, recovers from panics and gets the security
+	// headers, and every form post has to carry the CSRF token of its session
+	router := handlers.NewRouter()
+	router.Use(handlers.LogRequests, handlers.Recover, handlers.SecurityHeaders, handlers.CSRF)
+
+	// Pages open to visitors
+	router.HandleFunc("GET /", handlers.Index)                                    // Handler for the home page
+	router.HandleFunc("/register", handlers.Register)                             // Handler for registering user
+	router.HandleFunc("/login", handlers.Login)                                   // Handler for login
+	router.HandleFunc("/login/2fa", handlers.LoginSecondFactor)                   // Handler for the second login step
+	router.HandleFunc("POST /logout", handlers.Logout)                            // Handler for logout
+	router.HandleFunc("/forgot", handlers.ForgotPassword)                         // Handler sending password reset links
+	router.HandleFunc("/reset", handlers.ResetPassword)                           // Handler resetting a password
+	router.HandleFunc("GET /verify", handlers.VerifyEmail)                        // Handler verifying email addresses
+	router.HandleFunc("POST /admin/impersonate/stop", handlers.StopImpersonating) // Handler to stop impersonating a user
+
+	// Pages for logged in users
+	auth := handlers.RequireAuth
+	router.HandleFunc("GET /dashboard", handlers.ListPens, auth)                                          // Handler listing pens
+	router.HandleFunc("/add", handlers.AddPen, auth)                                                      // Handler adding a pen
+	router.HandleFunc("GET /export/csv", handlers.ExportCSV, auth)                                        // Handler exporting to CSV
+	router.HandleFunc("GET /activity/export/csv", handlers.ExportActivity, auth)                          // Handler exporting the activity to CSV
+	router.HandleFunc("/import/csv", handlers.ImportCSV, auth)                                            // Handler importing from CSV
+	router.HandleFunc("/import/approve", handlers.ImportApprove, auth)                                    // Handler approving imported data from CSV
+	router.HandleFunc("/modify/{id}", handlers.ModifyPen, auth)                                           // Handler to modify details for a pen
+	router.HandleFunc("POST /modify/{id}/revert/{revision}", handlers.RevertPen, auth)                    // Handler to revert a pen to an earlier revision
+	router.HandleFunc("/delete/{id}", handlers.DeletePen, auth)                                           // Handler to delete a pen
+	router.HandleFunc("POST /undo", handlers.Undo, auth)                                                  // Handler undoing the last delete or import
+	router.HandleFunc("GET /trash", handlers.Trash, auth)                                                 // Handler listing deleted pens
+	router.HandleFunc("POST /trash/restore/{id}", handlers.RestoreFromTrash, auth)                        // Handler to restore a deleted pen
+	router.HandleFunc("/trash/delete/{id}", handlers.DeleteFromTrash, auth)                               // Handler to delete a pen for good
+	router.HandleFunc("POST /verify/resend", handlers.ResendVerification, auth)                           // Handler sending the verification link again
+	router.HandleFunc("POST /inkup/{id}", handlers.InkUp, auth)                                           // Handler to ink up a pen
+	router.HandleFunc("POST /clean/{id}", handlers.CleanPen, auth)                                        // Handler to clean a pen
+	router.HandleFunc("POST /maintenance/{id}", handlers.AddMaintenance, auth)                            // Handler to log maintenance of a pen
+	router.HandleFunc("POST /maintenance/delete/{id}", handlers.DeleteMaintenance, auth)                  // Handler to delete a maintenance event
+	router.HandleFunc("GET /photos/{id}", handlers.ServePhoto, auth)                                      // Handler serving pen photos
+	router.HandleFunc("GET /photos/{id}/thumb", handlers.ServeThumbnail, auth)                            // Handler serving pen photo thumbnails
+	router.HandleFunc("POST /photos/delete/{id}", handlers.RemovePhoto, auth)                             // Handler to delete a pen photo
+	router.HandleFunc("/flush", handlers.FlushDue, auth)                                                  // Handler showing flush reminders
+	router.HandleFunc("GET /account", handlers.Account, auth)                                             // Handler for the account page
+	router.HandleFunc("POST /account/profile", handlers.UpdateProfile, auth)                              // Handler to edit the profile
+	router.HandleFunc("POST /account/username", handlers.ChangeUsername, auth)                            // Handler to change the username
+	router.HandleFunc("POST /account/password", handlers.ChangePassword, auth)                            // Handler to change the password
+	router.HandleFunc("POST /account/delete", handlers.DeleteAccount, auth)                               // Handler to delete the account
+	router.HandleFunc("POST /account/tokens", handlers.CreateToken, auth)                                 // Handler to create an API token
+	router.HandleFunc("POST /account/tokens/revoke/{id}", handlers.RevokeToken, auth)                     // Handler to revoke an API token
+	router.HandleFunc("POST /account/sessions/logout-others", handlers.LogoutOtherSessions, auth)         // Handler to log out other devices
+	router.HandleFunc("POST /account/sessions/revoke/{id}", handlers.RevokeSession, auth)                 // Handler to log out one session
+	router.HandleFunc("POST /account/2fa/setup", handlers.SetupTwoFactor, auth)                           // Handler to start setting up two-factor authentication
+	router.HandleFunc("POST /account/2fa/enable", handlers.EnableTwoFactor, auth)                         // Handler to turn on two-factor authentication
+	router.HandleFunc("POST /account/2fa/disable", handlers.DisableTwoFactor, auth)                       // Handler to turn off two-factor authentication
+	router.HandleFunc("POST /account/2fa/recovery", handlers.RegenerateRecoveryCodes, auth)               // Handler to replace the recovery codes
+	router.HandleFunc("GET /admin", handlers.Admin, auth, handlers.RequireAdmin)                          // Handler for the admin page
+	router.HandleFunc("POST /admin/users/{action}/{id}", handlers.AdminUser, auth, handlers.RequireAdmin) // Handler for admin actions on a user
+
+	router.HandleFunc("GET /inks", handlers.ListInks, auth)                     // Handler listing inks
+	router.HandleFunc("/inks/add", handlers.AddInk, auth)                       // Handler adding an ink
+	router.HandleFunc("/inks/modify/{id}", handlers.ModifyInk, auth)            // Handler to modify details for an ink
+	router.HandleFunc("/inks/delete/{id}", handlers.DeleteInk, auth)            // Handler to delete an ink
+	router.HandleFunc("GET /inks/export/csv", handlers.ExportInksCSV, auth)     // Handler exporting inks to CSV
+	router.HandleFunc("/inks/import/csv", handlers.ImportInksCSV, auth)         // Handler importing inks from CSV
+	router.HandleFunc("/inks/import/approve", handlers.ImportInksApprove, auth) // Handler approving imported inks from CSV
+
+	// The API answers with JSON errors and takes API tokens as well as sessions
+	api := handlers.NewRouter()
+	api.NotFound = http.HandlerFunc(handlers.APINotFound)
+	api.MethodNotAllowed = http.HandlerFunc(handlers.APIMethodNotAllowed)
+	api.HandleFunc("GET /api/v1/pens", handlers.APIListPens, handlers.RequireAPIAuth)          // API listing pens
+	api.HandleFunc("POST /api/v1/pens", handlers.APIAddPen, handlers.RequireAPIAuth)           // API adding a pen
+	api.HandleFunc("GET /api/v1/pens/{id}", handlers.APIGetPen, handlers.RequireAPIAuth)       // API reading a pen
+	api.HandleFunc("PUT /api/v1/pens/{id}", handlers.APIUpdatePen, handlers.RequireAPIAuth)    // API replacing a pen
+	api.HandleFunc("PATCH /api/v1/pens/{id}", handlers.APIUpdatePen, handlers.RequireAPIAuth)  // API updating some fields of a pen
+	api.HandleFunc("DELETE /api/v1/pens/{id}", handlers.APIDeletePen, handlers.RequireAPIAuth) // API deleting a pen
+	router.Handle("/api/{path...}", api)
+
+	// Serve static assets
+	static := http.StripPrefix("/includes/", http.FileServer(http.Dir(cfg.StaticDir)))
+	router.HandleFunc("GET /includes/{path...}", func(w http.ResponseWriter, r *http.Request) {
 		// Determine the file extension
-		fileExt := filepath.Ext(filePath)
+		fileExt := filepath.Ext(r.URL.Path)
 
 		// Set the Content-Type header based on the file extension
 		switch fileExt {
@@ -67,12 +259,28 @@ func main() {
 			w.Header().Set("Content-Type", "text/css")
 		}
 
-		// Serve the file using http.FileServer
-		http.FileServer(http.Dir(".")).ServeHTTP(w, r)
+		// Serve the file from the static directory using http.FileServer
+		static.ServeHTTP(w, r)
 	})
 
-	port := ":8000"
-	log.Println("Starting server on", port)
-	log.Fatal(http.ListenAndServe(port, nil))
+	// Serve until SIGINT or SIGTERM, then let the requests in flight finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = serve(ctx, cfg, router)
 
+	// Let the emails sent after their response go out
+	handlers.WaitForMail()
+	return err
+}
+
+// newLogger returns the structured logger of the configured format and level,
+// writing to standard error. Once it is the default, the log package writes
+// through it as well.
+func newLogger(cfg config.Config) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.LogLevel}
+	if cfg.LogFormat == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
 }
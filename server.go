@@ -0,0 +1,180 @@
+// server.go runs the HTTP server of Flock
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"flock/config"
+)
+
+const (
+	// readHeaderTimeout bounds reading request headers, so that slow clients
+	// can't hold connections open with them.
+	readHeaderTimeout = 10 * time.Second
+	// selfSignedLifetime is how long a self-signed certificate is valid,
+	// within the 398 days browsers accept.
+	selfSignedLifetime = 365 * 24 * time.Hour
+	// selfSignedRenewal is how long before it expires a self-signed
+	// certificate is replaced.
+	selfSignedRenewal = 30 * 24 * time.Hour
+)
+
+// serve runs the server until ctx is done, then stops accepting connections
+// and waits up to the shutdown timeout for requests in flight.
+func serve(ctx context.Context, cfg config.Config, handler http.Handler) error {
+	server := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: min(readHeaderTimeout, cfg.ReadTimeout),
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    int(cfg.MaxHeaderSize),
+		ErrorLog:          log.Default(),
+	}
+
+	certFile, keyFile := cfg.TLSCert, cfg.TLSKey
+	if cfg.TLSSelfSigned {
+		var err error
+		certFile, keyFile, err = selfSignedCertificate(cfg.DataDir)
+		if err != nil {
+			return fmt.Errorf("self-signed certificate: %w", err)
+		}
+	}
+	if certFile != "" {
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		if certFile != "" {
+			log.Println("Starting server with HTTPS on", cfg.Addr)
+			errs <- server.ListenAndServeTLS(certFile, keyFile)
+			return
+		}
+		log.Println("Starting server on", cfg.Addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down, waiting for requests in flight")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutting down: %w", err)
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Println("Server stopped")
+	return nil
+}
+
+// selfSignedCertificate returns the certificate and key files kept in dir,
+// creating them when they are missing or about to expire.
+func selfSignedCertificate(dir string) (certFile, keyFile string, err error) {
+	certFile = filepath.Join(dir, "tls_self_signed.pem")
+	keyFile = filepath.Join(dir, "tls_self_signed.key")
+
+	if pair, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+		if leaf, err := x509.ParseCertificate(pair.Certificate[0]); err == nil && time.Until(leaf.NotAfter) > selfSignedRenewal {
+			logCertificate(leaf)
+			return certFile, keyFile, nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "Flock", Organization: []string{"Flock"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedLifetime),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	template.DNSNames, template.IPAddresses = localNames()
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return "", "", err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return "", "", err
+	}
+	log.Printf("Created a self-signed certificate in %s", certFile)
+	logCertificate(cert)
+	return certFile, keyFile, nil
+}
+
+// logCertificate logs the fingerprint of a self-signed certificate, so that
+// it can be compared with the one a browser shows before trusting it.
+func logCertificate(cert *x509.Certificate) {
+	log.Printf("TLS certificate SHA-256 fingerprint %X, valid until %s", sha256.Sum256(cert.Raw), cert.NotAfter.Format("2006-01-02"))
+}
+
+// localNames returns the host names and addresses the server can be reached
+// at on this machine and its network.
+func localNames() ([]string, []net.IP) {
+	names := []string{"localhost"}
+	if host, err := os.Hostname(); err == nil && host != "localhost" {
+		names = append(names, host)
+	}
+
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				ips = append(ips, ipNet.IP)
+			}
+		}
+	}
+	return names, ips
+}